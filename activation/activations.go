@@ -1,24 +1,68 @@
 package activation
 
-import "math"
+import (
+	"fmt"
+	"math"
+)
 
 // Activation represents an activation function.
+//
+// Forward computes the activation y of the weighted input x of a neuron. Backward computes the derivative dy/dx.
+// Depending on which is cheaper or numerically more stable, the derivative is either computed from the input x or
+// from the output y. Operand tells which of the two Backward expects.
 type Activation interface {
 	Forward(float64) float64
 	Backward(float64) float64
+	Operand() Operand
+}
+
+// Operand selects the value that is passed to the Backward method of an activation.
+type Operand int
+
+const (
+	// Input means that Backward expects the weighted input x the activation was computed from.
+	Input Operand = iota
+
+	// Output means that Backward expects the output y of Forward.
+	Output
+)
+
+func (o Operand) String() string {
+	switch o {
+	case Input:
+		return "Input"
+	case Output:
+		return "Output"
+	default:
+		return fmt.Sprintf("Operand(%d)", int(o))
+	}
 }
 
-// Tanh computes tanh(x) as the activation function.
+// Derivative computes the derivative of a at the input x, regardless of which operand the Backward method of
+// a expects.
+func Derivative(a Activation, x float64) float64 {
+	if a.Operand() == Output {
+		return a.Backward(a.Forward(x))
+	}
+	return a.Backward(x)
+}
+
+// Tanh computes tanh(x) as the activation function. Its derivative is computed from the output.
 type Tanh struct{}
 
 func (t Tanh) Forward(x float64) float64 {
 	return math.Tanh(x)
 }
-func (t Tanh) Backward(x float64) float64 {
-	return 1 - math.Pow(x, 2.0)
+func (t Tanh) Backward(y float64) float64 {
+	return 1 - math.Pow(y, 2.0)
+}
+
+func (t Tanh) Operand() Operand {
+	return Output
 }
 
-// ELU is an Exponential Linear Unit activation. It computes max(A * (e^x - 1), x).
+// ELU is an Exponential Linear Unit activation. It computes x for x > 0 and A * (e^x - 1) otherwise. Its
+// derivative is computed from the input.
 type ELU struct {
 	A float64
 }
@@ -37,9 +81,15 @@ func (e ELU) Backward(x float64) float64 {
 	return e.A * math.Exp(x)
 }
 
-// LeakyReLU is a Leaky Rectified Linear Unit activation with activation cap.
+func (e ELU) Operand() Operand {
+	return Input
+}
+
+// LeakyReLU is a Leaky Rectified Linear Unit activation with activation cap. Its derivative is computed from
+// the input.
 //
-// If Cap is 0, the unit is uncapped. Otherwise, the output is clipped between -Cap and +Cap
+// If Cap is 0, the unit is uncapped. Otherwise, the output is clipped between -Cap and +Cap, and the derivative
+// is 0 wherever the output is clipped.
 type LeakyReLU struct {
 	Leak float64
 	Cap  float64
@@ -58,39 +108,290 @@ func (r LeakyReLU) Forward(x float64) float64 {
 	return math.Max(-r.Cap, math.Min(r.Cap, res))
 }
 func (r LeakyReLU) Backward(x float64) float64 {
+	res := 1.0
 	if x < 0 {
-		return r.Leak
+		res = r.Leak
+	}
+
+	if r.Cap != 0 && math.Abs(x*res) > r.Cap {
+		return 0
 	}
-	return 1.0
+
+	return res
+}
+
+func (r LeakyReLU) Operand() Operand {
+	return Input
 }
 
-// Sigmoid is a sigmoid activation function. It computes 1/(1 + e^(-x)).
+// Sigmoid is a sigmoid activation function. It computes 1/(1 + e^(-x)). Its derivative is computed from the
+// output.
 type Sigmoid struct{}
 
 func (s Sigmoid) Forward(x float64) float64 {
 	return 1.0 / (1.0 + math.Exp(-x))
 }
-func (s Sigmoid) Backward(x float64) float64 {
-	f := s.Forward(x)
-	return f * (1.0 - f)
+func (s Sigmoid) Backward(y float64) float64 {
+	return y * (1.0 - y)
 }
 
+func (s Sigmoid) Operand() Operand {
+	return Output
+}
+
+// Softplus is a smooth approximation of a rectifier. It computes log(1 + e^x). Its derivative is computed from
+// the input.
 type Softplus struct{}
 
 func (s Softplus) Forward(v float64) float64 {
-	return math.Log(1 + math.Exp(v))
+	if v > 30 {
+		// log(1 + e^v) and v are indistinguishable here, but e^v overflows for large v
+		return v
+	}
+	return math.Log1p(math.Exp(v))
 }
 
 func (s Softplus) Backward(v float64) float64 {
 	return 1.0 / (1.0 + math.Exp(-v))
 }
 
+func (s Softplus) Operand() Operand {
+	return Input
+}
+
+// Gaussian computes e^(-x^2). Its derivative is computed from the input.
 type Gaussian struct{}
 
 func (g Gaussian) Forward(x float64) float64 {
-	return math.Exp(math.Pow(-x, 2))
+	return math.Exp(-x * x)
 }
 
 func (g Gaussian) Backward(x float64) float64 {
-	return -2 * x * math.Exp(math.Pow(-x, 2))
+	return -2 * x * math.Exp(-x*x)
+}
+
+func (g Gaussian) Operand() Operand {
+	return Input
+}
+
+// Identity passes its input through unchanged. It is useful for the output layer of regression networks.
+type Identity struct{}
+
+func (i Identity) Forward(x float64) float64 {
+	return x
+}
+
+func (i Identity) Backward(x float64) float64 {
+	return 1
+}
+
+func (i Identity) Operand() Operand {
+	return Input
+}
+
+// GELU is the Gaussian Error Linear Unit. It computes x * Phi(x), where Phi is the cumulative distribution
+// function of the standard normal distribution. Its derivative is computed from the input.
+type GELU struct{}
+
+func (g GELU) Forward(x float64) float64 {
+	return x * normalCDF(x)
+}
+
+func (g GELU) Backward(x float64) float64 {
+	return normalCDF(x) + x*math.Exp(-x*x/2)/math.Sqrt(2*math.Pi)
+}
+
+func (g GELU) Operand() Operand {
+	return Input
+}
+
+func normalCDF(x float64) float64 {
+	return (1 + math.Erf(x/math.Sqrt2)) / 2
+}
+
+// Swish computes x * sigmoid(Beta * x). A Beta of 0 is treated as 1, which makes Swish the Sigmoid Linear Unit
+// (SiLU). Its derivative is computed from the input.
+type Swish struct {
+	Beta float64
+}
+
+func (s Swish) beta() float64 {
+	if s.Beta == 0 {
+		return 1
+	}
+	return s.Beta
+}
+
+func (s Swish) Forward(x float64) float64 {
+	return x * Sigmoid{}.Forward(s.beta()*x)
+}
+
+func (s Swish) Backward(x float64) float64 {
+	beta := s.beta()
+	sig := Sigmoid{}.Forward(beta * x)
+	return sig + beta*x*sig*(1-sig)
 }
+
+func (s Swish) Operand() Operand {
+	return Input
+}
+
+// Mish computes x * tanh(softplus(x)). Its derivative is computed from the input.
+type Mish struct{}
+
+func (m Mish) Forward(x float64) float64 {
+	return x * math.Tanh(Softplus{}.Forward(x))
+}
+
+func (m Mish) Backward(x float64) float64 {
+	t := math.Tanh(Softplus{}.Forward(x))
+	return t + x*(1-t*t)*Sigmoid{}.Forward(x)
+}
+
+func (m Mish) Operand() Operand {
+	return Input
+}
+
+// Constants of SELU, chosen so that activations keep zero mean and unit variance across layers.
+const (
+	seluAlpha = 1.6732632423543772848170429916717
+	seluScale = 1.0507009873554804934193349852946
+)
+
+// SELU is the Scaled Exponential Linear Unit. It computes Scale * x for x > 0 and Scale * Alpha * (e^x - 1)
+// otherwise, with fixed constants Alpha ≈ 1.6733 and Scale ≈ 1.0507 that make networks self-normalizing. Its
+// derivative is computed from the input.
+type SELU struct{}
+
+func (s SELU) Forward(x float64) float64 {
+	if x > 0 {
+		return seluScale * x
+	}
+	return seluScale * seluAlpha * (math.Exp(x) - 1)
+}
+
+func (s SELU) Backward(x float64) float64 {
+	if x > 0 {
+		return seluScale
+	}
+	return seluScale * seluAlpha * math.Exp(x)
+}
+
+func (s SELU) Operand() Operand {
+	return Input
+}
+
+// HardSigmoid is a piecewise linear approximation of Sigmoid. It computes max(0, min(1, x/6 + 1/2)). Its
+// derivative is computed from the input.
+type HardSigmoid struct{}
+
+func (h HardSigmoid) Forward(x float64) float64 {
+	return math.Max(0, math.Min(1, x/6+0.5))
+}
+
+func (h HardSigmoid) Backward(x float64) float64 {
+	if x <= -3 || x >= 3 {
+		return 0
+	}
+	return 1.0 / 6
+}
+
+func (h HardSigmoid) Operand() Operand {
+	return Input
+}
+
+// HardTanh is a piecewise linear approximation of Tanh. It computes max(-1, min(1, x)). Its derivative is
+// computed from the input.
+type HardTanh struct{}
+
+func (h HardTanh) Forward(x float64) float64 {
+	return math.Max(-1, math.Min(1, x))
+}
+
+func (h HardTanh) Backward(x float64) float64 {
+	if x <= -1 || x >= 1 {
+		return 0
+	}
+	return 1
+}
+
+func (h HardTanh) Operand() Operand {
+	return Input
+}
+
+// Softsign computes x / (1 + |x|). Its derivative is computed from the input.
+type Softsign struct{}
+
+func (s Softsign) Forward(x float64) float64 {
+	return x / (1 + math.Abs(x))
+}
+
+func (s Softsign) Backward(x float64) float64 {
+	d := 1 + math.Abs(x)
+	return 1 / (d * d)
+}
+
+func (s Softsign) Operand() Operand {
+	return Input
+}
+
+// Vector is implemented by activations that can't be computed element-wise, because each of their outputs
+// depends on all weighted inputs of a layer. Layers use ForwardVector and BackwardVector instead of Forward and
+// Backward for these activations.
+//
+// ForwardVector computes the activation of x and writes it to dst. BackwardVector computes the error at the
+// inputs of the activation from the error at its outputs, given the inputs x and outputs y of a forward pass,
+// and writes it to dst.
+type Vector interface {
+	Activation
+	ForwardVector(dst, x []float64)
+	BackwardVector(dst, x, y, error []float64)
+}
+
+// Softmax turns a vector into a probability distribution by computing e^x_i / sum(e^x_j) for each element.
+// It is a Vector activation, calling its Forward or Backward methods panics.
+type Softmax struct{}
+
+// Operand returns Output. It is only there to satisfy Activation, BackwardVector gets both inputs and outputs.
+func (s Softmax) Operand() Operand {
+	return Output
+}
+
+func (s Softmax) Forward(x float64) float64 {
+	panic("softmax can't be computed element-wise")
+}
+
+func (s Softmax) Backward(x float64) float64 {
+	panic("softmax can't be computed element-wise")
+}
+
+func (s Softmax) ForwardVector(dst, x []float64) {
+	// Subtracting the maximum doesn't change the result, but keeps the exponentials from overflowing.
+	max := math.Inf(-1)
+	for _, v := range x {
+		max = math.Max(max, v)
+	}
+
+	sum := float64(0)
+	for idx, v := range x {
+		dst[idx] = math.Exp(v - max)
+		sum += dst[idx]
+	}
+
+	for idx := range dst {
+		dst[idx] /= sum
+	}
+}
+
+func (s Softmax) BackwardVector(dst, x, y, error []float64) {
+	dot := float64(0)
+	for idx, e := range error {
+		dot += e * y[idx]
+	}
+
+	for idx, e := range error {
+		dst[idx] = y[idx] * (e - dot)
+	}
+}
+
+var _ Vector = Softmax{}
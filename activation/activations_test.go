@@ -1,6 +1,9 @@
 package activation
 
-import "testing"
+import (
+	"math"
+	"testing"
+)
 
 func TestLeakyRELUActivationForward(t *testing.T) {
 	act := LeakyReLU{Leak: 0.001, Cap: 10}
@@ -42,3 +45,188 @@ func TestLeakyRELUActivationBackward(t *testing.T) {
 		t.Error(`-1 -> `, a)
 	}
 }
+
+func TestLeakyRELUActivationBackwardCapped(t *testing.T) {
+	act := LeakyReLU{Leak: 0.5, Cap: 1}
+
+	for x, expected := range map[float64]float64{
+		0.5:  1,
+		1.5:  0,
+		-1:   0.5,
+		-2.5: 0,
+	} {
+		if a := act.Backward(x); a != expected {
+			t.Errorf(`%f -> %f, expected %f`, x, a, expected)
+		}
+	}
+}
+
+func TestGaussianForward(t *testing.T) {
+	act := Gaussian{}
+
+	for x, expected := range map[float64]float64{
+		0:  1,
+		1:  math.Exp(-1),
+		-2: math.Exp(-4),
+	} {
+		if a := act.Forward(x); math.Abs(a-expected) > 1e-12 {
+			t.Errorf(`%f -> %f, expected %f`, x, a, expected)
+		}
+	}
+}
+
+func TestSoftplusForwardLargeInput(t *testing.T) {
+	if a := (Softplus{}).Forward(1000); a != 1000 {
+		t.Error(`1000 ->`, a)
+	}
+}
+
+// TestBackwardMatchesNumericalDerivative compares the derivatives of all element-wise built-in activations to
+// central differences of their Forward methods, taking into account which operand Backward expects.
+func TestBackwardMatchesNumericalDerivative(t *testing.T) {
+	const h = 1e-6
+
+	// Piecewise activations have no derivative at their kinks, so central differences can't be compared there.
+	kinks := map[Activation][]float64{
+		HardSigmoid{}: {-3, 3},
+	}
+
+	for _, act := range []Activation{
+		Tanh{},
+		ELU{A: 1},
+		ELU{A: 0.3},
+		LeakyReLU{Leak: 0.01},
+		LeakyReLU{},
+		LeakyReLU{Leak: 0.5, Cap: 1},
+		Sigmoid{},
+		Softplus{},
+		Gaussian{},
+		Identity{},
+		GELU{},
+		Swish{},
+		Swish{Beta: 2},
+		Mish{},
+		SELU{},
+		HardSigmoid{},
+		HardTanh{},
+		Softsign{},
+	} {
+	points:
+		for _, x := range []float64{-3, -1.7, -0.5, -0.1, 0.1, 0.5, 1.3, 3} {
+			for _, k := range kinks[act] {
+				if x == k {
+					continue points
+				}
+			}
+
+			numeric := (act.Forward(x+h) - act.Forward(x-h)) / (2 * h)
+			analytic := Derivative(act, x)
+
+			if math.Abs(numeric-analytic) > 1e-6 {
+				t.Errorf(`%T%+v at %f: Backward with operand %s gives %f, expected %f`, act, act, x, act.Operand(), analytic, numeric)
+			}
+		}
+	}
+}
+
+func TestActivationForward(t *testing.T) {
+	sig := func(x float64) float64 {
+		return 1 / (1 + math.Exp(-x))
+	}
+
+	for _, tc := range []struct {
+		act      Activation
+		x        float64
+		expected float64
+	}{
+		{act: Identity{}, x: -2.5, expected: -2.5},
+		{act: GELU{}, x: 0, expected: 0},
+		{act: GELU{}, x: 1, expected: 0.8413447460685429},
+		{act: GELU{}, x: -1, expected: -0.15865525393145707},
+		{act: Swish{}, x: 1, expected: sig(1)},
+		{act: Swish{}, x: -2, expected: -2 * sig(-2)},
+		{act: Swish{Beta: 2}, x: 1, expected: sig(2)},
+		{act: Mish{}, x: 0, expected: 0},
+		{act: Mish{}, x: 1, expected: math.Tanh(math.Log(1 + math.E))},
+		{act: SELU{}, x: 2, expected: 2 * 1.0507009873554805},
+		{act: SELU{}, x: -1, expected: 1.0507009873554805 * 1.6732632423543772 * (math.Exp(-1) - 1)},
+		{act: HardSigmoid{}, x: -4, expected: 0},
+		{act: HardSigmoid{}, x: 0, expected: 0.5},
+		{act: HardSigmoid{}, x: 1.5, expected: 0.75},
+		{act: HardSigmoid{}, x: 4, expected: 1},
+		{act: HardTanh{}, x: -2, expected: -1},
+		{act: HardTanh{}, x: 0.3, expected: 0.3},
+		{act: HardTanh{}, x: 2, expected: 1},
+		{act: Softsign{}, x: 1, expected: 0.5},
+		{act: Softsign{}, x: -3, expected: -0.75},
+	} {
+		if a := tc.act.Forward(tc.x); math.Abs(a-tc.expected) > 1e-12 {
+			t.Errorf(`%T%+v: %f -> %f, expected %f`, tc.act, tc.act, tc.x, a, tc.expected)
+		}
+	}
+}
+
+func TestSoftmaxForwardVector(t *testing.T) {
+	act := Softmax{}
+
+	x := []float64{1, 2, 3}
+	y := make([]float64, len(x))
+	act.ForwardVector(y, x)
+
+	sum := float64(0)
+	for _, v := range y {
+		sum += v
+	}
+	if math.Abs(sum-1) > 1e-12 {
+		t.Error(`outputs don't sum up to 1:`, y)
+	}
+
+	if !(y[0] < y[1] && y[1] < y[2]) {
+		t.Error(`order not preserved:`, y)
+	}
+
+	// Large inputs must not overflow
+	act.ForwardVector(y, []float64{1000, 1000, 1000})
+	for _, v := range y {
+		if math.Abs(v-1.0/3) > 1e-12 {
+			t.Error(`unexpected output for large inputs:`, y)
+		}
+	}
+}
+
+func TestSoftmaxBackwardVector(t *testing.T) {
+	const h = 1e-6
+
+	act := Softmax{}
+
+	x := []float64{0.5, -1, 2}
+	error := []float64{0.3, -0.2, 0.1}
+
+	y := make([]float64, len(x))
+	act.ForwardVector(y, x)
+
+	res := make([]float64, len(x))
+	act.BackwardVector(res, x, y, error)
+
+	// Compare against the numerically computed product of error and Jacobian
+	plus := make([]float64, len(x))
+	minus := make([]float64, len(x))
+	for j := range x {
+		v := x[j]
+
+		x[j] = v + h
+		act.ForwardVector(plus, x)
+		x[j] = v - h
+		act.ForwardVector(minus, x)
+		x[j] = v
+
+		expected := float64(0)
+		for i, e := range error {
+			expected += e * (plus[i] - minus[i]) / (2 * h)
+		}
+
+		if math.Abs(expected-res[j]) > 1e-8 {
+			t.Errorf(`input %d: expected %f, got %f`, j, expected, res[j])
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package activation
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+var (
+	registryMu sync.RWMutex
+	factories  = map[string]func() Activation{}
+	names      = map[reflect.Type]string{}
+)
+
+func init() {
+	Register("ELU", func() Activation { return ELU{} })
+	Register("GELU", func() Activation { return GELU{} })
+	Register("Gaussian", func() Activation { return Gaussian{} })
+	Register("HardSigmoid", func() Activation { return HardSigmoid{} })
+	Register("HardTanh", func() Activation { return HardTanh{} })
+	Register("Identity", func() Activation { return Identity{} })
+	Register("LeakyReLU", func() Activation { return LeakyReLU{} })
+	Register("Mish", func() Activation { return Mish{} })
+	Register("PReLU", func() Activation { return PReLU{} })
+	Register("ParametricELU", func() Activation { return ParametricELU{} })
+	Register("SELU", func() Activation { return SELU{} })
+	Register("Sigmoid", func() Activation { return Sigmoid{} })
+	Register("Softmax", func() Activation { return Softmax{} })
+	Register("Softplus", func() Activation { return Softplus{} })
+	Register("Softsign", func() Activation { return Softsign{} })
+	Register("Swish", func() Activation { return Swish{} })
+	Register("Tanh", func() Activation { return Tanh{} })
+}
+
+// Register makes an activation available for Marshal and Unmarshal under the given name. The factory has to
+// return a new instance of the activation with default parameters, into which encoded parameters are decoded.
+// Every call of the factory must return a value of the same type.
+//
+// Parameters are encoded as JSON, so activations that carry parameters need exported fields or have to
+// implement json.Marshaler and json.Unmarshaler.
+//
+// Register panics if name or the type returned by factory are already registered.
+func Register(name string, factory func() Activation) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("activation %q registered twice", name))
+	}
+
+	typ := reflect.TypeOf(factory())
+	if other, ok := names[typ]; ok {
+		panic(fmt.Sprintf("activation type %v already registered as %q", typ, other))
+	}
+
+	factories[name] = factory
+	names[typ] = name
+}
+
+// Names returns the sorted names of all registered activations.
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	var res []string
+	for name := range factories {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+
+	return res
+}
+
+// Name returns the name a was registered under.
+func Name(a Activation) (string, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	name, ok := names[reflect.TypeOf(a)]
+	if !ok {
+		return "", fmt.Errorf("activation type %T is not registered", a)
+	}
+
+	return name, nil
+}
+
+// New returns a new instance of the activation registered under name, with default parameters.
+func New(name string) (Activation, error) {
+	registryMu.RLock()
+	factory, ok := factories[name]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown activation %q", name)
+	}
+
+	return factory(), nil
+}
+
+// encoded is the JSON representation of an activation.
+type encoded struct {
+	Name   string          `json:"name"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// Marshal encodes a as JSON, along with its parameters. The activation has to be registered.
+func Marshal(a Activation) ([]byte, error) {
+	name, err := Name(a)
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := json.Marshal(a)
+	if err != nil {
+		return nil, fmt.Errorf("encoding parameters of activation %s: %w", name, err)
+	}
+
+	if string(params) == "{}" || string(params) == "null" {
+		params = nil
+	}
+
+	return json.Marshal(encoded{
+		Name:   name,
+		Params: params,
+	})
+}
+
+// Unmarshal decodes an activation that was encoded with Marshal.
+func Unmarshal(data []byte) (Activation, error) {
+	var e encoded
+
+	err := json.Unmarshal(data, &e)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := New(e.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(e.Params) == 0 {
+		return a, nil
+	}
+
+	if reflect.TypeOf(a).Kind() == reflect.Ptr {
+		err = json.Unmarshal(e.Params, a)
+	} else {
+		// Decode into a pointer to a copy of the value returned by the factory
+		v := reflect.New(reflect.TypeOf(a))
+		v.Elem().Set(reflect.ValueOf(a))
+
+		err = json.Unmarshal(e.Params, v.Interface())
+		a = v.Elem().Interface().(Activation)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decoding parameters of activation %s: %w", e.Name, err)
+	}
+
+	return a, nil
+}
+
+// Config wraps an activation so that it can be embedded in JSON documents like configuration files. It is
+// encoded with Marshal and decoded with Unmarshal.
+type Config struct {
+	Activation
+}
+
+func (c Config) MarshalJSON() ([]byte, error) {
+	return Marshal(c.Activation)
+}
+
+func (c *Config) UnmarshalJSON(data []byte) error {
+	a, err := Unmarshal(data)
+	if err != nil {
+		return err
+	}
+
+	c.Activation = a
+
+	return nil
+}
+
+var (
+	_ json.Marshaler   = Config{}
+	_ json.Unmarshaler = &Config{}
+)
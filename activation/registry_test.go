@@ -0,0 +1,158 @@
+package activation
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestRegistryRoundTripBuiltins(t *testing.T) {
+	activations := []Activation{
+		ELU{A: 0.3},
+		GELU{},
+		Gaussian{},
+		HardSigmoid{},
+		HardTanh{},
+		Identity{},
+		LeakyReLU{Leak: 0.01, Cap: 6},
+		Mish{},
+		PReLU{PerUnit: true, Init: 0.1},
+		ParametricELU{Init: 0.5},
+		SELU{},
+		Sigmoid{},
+		Softmax{},
+		Softplus{},
+		Softsign{},
+		Swish{Beta: 1.5},
+		Tanh{},
+	}
+
+	seen := map[string]bool{}
+
+	for _, a := range activations {
+		data, err := Marshal(a)
+		if err != nil {
+			t.Errorf(`%T: can't marshal: %s`, a, err)
+			continue
+		}
+
+		res, err := Unmarshal(data)
+		if err != nil {
+			t.Errorf(`%T: can't unmarshal %s: %s`, a, data, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(a, res) {
+			t.Errorf(`%T: round trip changed activation: expected %#v, got %#v`, a, a, res)
+		}
+
+		name, _ := Name(a)
+		seen[name] = true
+	}
+
+	for _, name := range Names() {
+		if !seen[name] {
+			t.Errorf(`built-in activation %s isn't covered by the round trip test`, name)
+		}
+	}
+}
+
+func TestRegistryDefaults(t *testing.T) {
+	for _, name := range Names() {
+		a, err := New(name)
+		if err != nil {
+			t.Fatalf(`%s: can't create activation: %s`, name, err)
+		}
+
+		n, err := Name(a)
+		if err != nil || n != name {
+			t.Errorf(`%s: activation is registered as %q (%v)`, name, n, err)
+		}
+	}
+}
+
+func TestRegistryUnknown(t *testing.T) {
+	_, err := Unmarshal([]byte(`{"name": "DoesNotExist"}`))
+	if err == nil {
+		t.Error(`expected an error for an unknown activation`)
+	}
+
+	type unregistered struct {
+		Tanh
+	}
+
+	_, err = Marshal(unregistered{})
+	if err == nil {
+		t.Error(`expected an error for an unregistered activation`)
+	}
+}
+
+// scaledTanh is a third-party activation with a parameter. It is registered as a pointer to make sure
+// parameters are decoded into a fresh instance.
+type scaledTanh struct {
+	Scale float64
+}
+
+func (s *scaledTanh) Forward(x float64) float64 {
+	return s.Scale * math.Tanh(x)
+}
+
+func (s *scaledTanh) Backward(y float64) float64 {
+	return s.Scale * (1 - math.Pow(y/s.Scale, 2))
+}
+
+func (s *scaledTanh) Operand() Operand {
+	return Output
+}
+
+// unregister removes the activation registered under name, so that tests can register activations without
+// leaking them into other tests.
+func unregister(name string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	factory, ok := factories[name]
+	if !ok {
+		return
+	}
+
+	delete(names, reflect.TypeOf(factory()))
+	delete(factories, name)
+}
+
+func TestRegistryThirdParty(t *testing.T) {
+	Register("test.ScaledTanh", func() Activation { return &scaledTanh{Scale: 1} })
+	t.Cleanup(func() { unregister("test.ScaledTanh") })
+
+	defer func() {
+		if recover() == nil {
+			t.Error(`expected registering a name twice to panic`)
+		}
+	}()
+
+	var conf struct {
+		Hidden Config `json:"hidden"`
+	}
+
+	err := json.Unmarshal([]byte(`{"hidden": {"name": "test.ScaledTanh", "params": {"Scale": 2}}}`), &conf)
+	if err != nil {
+		t.Fatal(`can't decode config:`, err)
+	}
+
+	s, ok := conf.Hidden.Activation.(*scaledTanh)
+	if !ok || s.Scale != 2 {
+		t.Fatalf(`unexpected activation: %#v`, conf.Hidden.Activation)
+	}
+
+	data, err := json.Marshal(conf)
+	if err != nil {
+		t.Fatal(`can't encode config:`, err)
+	}
+
+	if string(data) != `{"hidden":{"name":"test.ScaledTanh","params":{"Scale":2}}}` {
+		t.Errorf(`unexpected encoding: %s`, data)
+	}
+
+	Register("test.ScaledTanh", func() Activation { return &scaledTanh{} })
+}
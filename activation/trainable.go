@@ -0,0 +1,198 @@
+package activation
+
+import (
+	"math"
+)
+
+// Trainable is implemented by activations with parameters that are learned along with the weights of a layer.
+// Trainable activations are always computed through the Vector methods, so that each unit of a layer can have
+// its own parameters.
+//
+// The value passed in a layer configuration only describes the activation. Every layer works on its own copy
+// created by Instantiate, which holds the parameters for the given number of units.
+//
+// Parameters returns the learned parameters of an instance. The returned slice is updated in place during
+// training. ParameterError computes the error of the parameters for one sample, that is the negative gradient
+// of the loss with respect to them, and adds it to dst. x, y and error are the same as for BackwardVector.
+type Trainable interface {
+	Vector
+	Instantiate(units int) Trainable
+	Parameters() []float64
+	ParameterError(dst, x, y, error []float64)
+}
+
+// parameter returns the parameter of unit idx from params, which holds either one parameter per unit or a
+// single one shared by all units.
+func parameter(params []float64, idx int) float64 {
+	if len(params) == 1 {
+		return params[0]
+	}
+	return params[idx]
+}
+
+// newParameters returns the initial parameters for a layer with the given number of units.
+func newParameters(units int, perUnit bool, init float64) []float64 {
+	if !perUnit {
+		units = 1
+	}
+
+	res := make([]float64, units)
+	for idx := range res {
+		res[idx] = init
+	}
+
+	return res
+}
+
+// PReLU is a Parametric Rectified Linear Unit. Like LeakyReLU, it computes x for x > 0 and a * x otherwise, but
+// the leak a is learned during training. It is a Trainable activation, calling its Forward or Backward methods
+// panics.
+//
+// If PerUnit is set, every unit of a layer learns its own leak, otherwise all units share one. Init is the
+// initial leak, 0 means 0.25.
+type PReLU struct {
+	PerUnit bool
+	Init    float64
+
+	leak []float64
+}
+
+// Operand returns Input. It is only there to satisfy Activation, BackwardVector gets both inputs and outputs.
+func (p PReLU) Operand() Operand {
+	return Input
+}
+
+func (p PReLU) Forward(x float64) float64 {
+	panic("PReLU can't be computed element-wise")
+}
+
+func (p PReLU) Backward(x float64) float64 {
+	panic("PReLU can't be computed element-wise")
+}
+
+func (p PReLU) Instantiate(units int) Trainable {
+	init := p.Init
+	if init == 0 {
+		init = 0.25
+	}
+
+	p.leak = newParameters(units, p.PerUnit, init)
+
+	return p
+}
+
+func (p PReLU) Parameters() []float64 {
+	return p.leak
+}
+
+func (p PReLU) ForwardVector(dst, x []float64) {
+	for idx, v := range x {
+		if v > 0 {
+			dst[idx] = v
+		} else {
+			dst[idx] = parameter(p.leak, idx) * v
+		}
+	}
+}
+
+func (p PReLU) BackwardVector(dst, x, y, error []float64) {
+	for idx, e := range error {
+		if x[idx] > 0 {
+			dst[idx] = e
+		} else {
+			dst[idx] = parameter(p.leak, idx) * e
+		}
+	}
+}
+
+func (p PReLU) ParameterError(dst, x, y, error []float64) {
+	for idx, e := range error {
+		if x[idx] > 0 {
+			continue
+		}
+
+		if len(dst) == 1 {
+			dst[0] += e * x[idx]
+		} else {
+			dst[idx] += e * x[idx]
+		}
+	}
+}
+
+var _ Trainable = PReLU{}
+
+// ParametricELU is an ELU whose factor A is learned during training. It computes x for x > 0 and A * (e^x - 1)
+// otherwise. It is a Trainable activation, calling its Forward or Backward methods panics.
+//
+// If PerUnit is set, every unit of a layer learns its own factor, otherwise all units share one. Init is the
+// initial factor, 0 means 1.
+type ParametricELU struct {
+	PerUnit bool
+	Init    float64
+
+	a []float64
+}
+
+// Operand returns Input. It is only there to satisfy Activation, BackwardVector gets both inputs and outputs.
+func (e ParametricELU) Operand() Operand {
+	return Input
+}
+
+func (e ParametricELU) Forward(x float64) float64 {
+	panic("ParametricELU can't be computed element-wise")
+}
+
+func (e ParametricELU) Backward(x float64) float64 {
+	panic("ParametricELU can't be computed element-wise")
+}
+
+func (e ParametricELU) Instantiate(units int) Trainable {
+	init := e.Init
+	if init == 0 {
+		init = 1
+	}
+
+	e.a = newParameters(units, e.PerUnit, init)
+
+	return e
+}
+
+func (e ParametricELU) Parameters() []float64 {
+	return e.a
+}
+
+func (e ParametricELU) ForwardVector(dst, x []float64) {
+	for idx, v := range x {
+		if v > 0 {
+			dst[idx] = v
+		} else {
+			dst[idx] = parameter(e.a, idx) * math.Expm1(v)
+		}
+	}
+}
+
+func (e ParametricELU) BackwardVector(dst, x, y, error []float64) {
+	for idx, err := range error {
+		if x[idx] > 0 {
+			dst[idx] = err
+		} else {
+			dst[idx] = parameter(e.a, idx) * math.Exp(x[idx]) * err
+		}
+	}
+}
+
+func (e ParametricELU) ParameterError(dst, x, y, error []float64) {
+	for idx, err := range error {
+		if x[idx] > 0 {
+			continue
+		}
+
+		if len(dst) == 1 {
+			dst[0] += err * math.Expm1(x[idx])
+		} else {
+			dst[idx] += err * math.Expm1(x[idx])
+		}
+	}
+}
+
+var _ Trainable = ParametricELU{}
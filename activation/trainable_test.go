@@ -0,0 +1,134 @@
+package activation
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTrainableInstantiate(t *testing.T) {
+	for _, tc := range []struct {
+		act      Trainable
+		expected []float64
+	}{
+		{PReLU{}, []float64{0.25}},
+		{PReLU{PerUnit: true, Init: 0.1}, []float64{0.1, 0.1, 0.1}},
+		{ParametricELU{}, []float64{1}},
+		{ParametricELU{PerUnit: true}, []float64{1, 1, 1}},
+	} {
+		a := tc.act.Instantiate(3)
+		b := tc.act.Instantiate(3)
+
+		params := a.Parameters()
+		if len(params) != len(tc.expected) {
+			t.Errorf(`%T%+v: expected %d parameters, got %d`, tc.act, tc.act, len(tc.expected), len(params))
+			continue
+		}
+
+		for idx, p := range params {
+			if p != tc.expected[idx] {
+				t.Errorf(`%T%+v: parameter %d is %f, expected %f`, tc.act, tc.act, idx, p, tc.expected[idx])
+			}
+		}
+
+		params[0] = 42
+		if b.Parameters()[0] == 42 {
+			t.Errorf(`%T%+v: instances share their parameters`, tc.act, tc.act)
+		}
+	}
+}
+
+func TestTrainableForwardVector(t *testing.T) {
+	x := []float64{-2, -1, 0.5, 3}
+
+	prelu := PReLU{PerUnit: true}.Instantiate(len(x))
+	copy(prelu.Parameters(), []float64{0.1, 0.2, 0.3, 0.4})
+
+	elu := ParametricELU{Init: 0.5}.Instantiate(len(x))
+
+	for _, tc := range []struct {
+		act      Trainable
+		expected []float64
+	}{
+		{prelu, []float64{-0.2, -0.2, 0.5, 3}},
+		{elu, []float64{0.5 * math.Expm1(-2), 0.5 * math.Expm1(-1), 0.5, 3}},
+	} {
+		res := make([]float64, len(x))
+		tc.act.ForwardVector(res, x)
+
+		for idx, r := range res {
+			if math.Abs(r-tc.expected[idx]) > 1e-12 {
+				t.Errorf(`%T: output %d is %f, expected %f`, tc.act, idx, r, tc.expected[idx])
+			}
+		}
+	}
+}
+
+// TestTrainableGradients compares the errors computed by BackwardVector and ParameterError with numerical
+// derivatives of ForwardVector.
+func TestTrainableGradients(t *testing.T) {
+	const h = 1e-6
+
+	x := []float64{-2.1, -0.7, -0.1, 0.4, 1.5}
+	error := []float64{0.3, -1.2, 0.8, 0.5, -0.4}
+
+	// loss returns the dot product of the outputs for x with error, so its derivatives are the errors
+	loss := func(act Trainable) float64 {
+		y := make([]float64, len(x))
+		act.ForwardVector(y, x)
+
+		res := float64(0)
+		for idx, e := range error {
+			res += e * y[idx]
+		}
+		return res
+	}
+
+	for _, conf := range []Trainable{
+		PReLU{},
+		PReLU{PerUnit: true, Init: 0.1},
+		ParametricELU{},
+		ParametricELU{PerUnit: true, Init: 0.5},
+	} {
+		act := conf.Instantiate(len(x))
+
+		y := make([]float64, len(x))
+		act.ForwardVector(y, x)
+
+		deltas := make([]float64, len(x))
+		act.BackwardVector(deltas, x, y, error)
+
+		for idx := range x {
+			v := x[idx]
+
+			x[idx] = v + h
+			plus := loss(act)
+			x[idx] = v - h
+			minus := loss(act)
+			x[idx] = v
+
+			expected := (plus - minus) / (2 * h)
+			if math.Abs(expected-deltas[idx]) > 1e-6 {
+				t.Errorf(`%T%+v: delta %d is %f, expected %f`, conf, conf, idx, deltas[idx], expected)
+			}
+		}
+
+		params := act.Parameters()
+		paramErrors := make([]float64, len(params))
+		act.ParameterError(paramErrors, x, y, error)
+
+		for idx := range params {
+			v := params[idx]
+
+			params[idx] = v + h
+			plus := loss(act)
+			params[idx] = v - h
+			minus := loss(act)
+			params[idx] = v
+
+			expected := (plus - minus) / (2 * h)
+			if math.Abs(expected-paramErrors[idx]) > 1e-6 {
+				t.Errorf(`%T%+v: parameter error %d is %f, expected %f`, conf, conf, idx, paramErrors[idx], expected)
+			}
+		}
+	}
+}
@@ -5,14 +5,11 @@ import (
 	"io"
 	"log"
 	"os"
-)
 
-type mnistSample struct {
-	input  []float64
-	target []float64
-}
+	network "github.com/farhaven/nn-go"
+)
 
-func readMnist(prefix string) []mnistSample {
+func readMnist(prefix string) []network.Sample {
 	logger := log.New(os.Stdout, `[MNIST] `, log.LstdFlags)
 
 	logger.Println(`reading mnist data from`, prefix)
@@ -81,7 +78,7 @@ func readMnist(prefix string) []mnistSample {
 
 	/* Load images and labels and build samples from that */
 	buf := make([]uint8, imgDims[0]*imgDims[1])
-	samples := []mnistSample{}
+	samples := []network.Sample{}
 	for {
 		err = binary.Read(imgfh, binary.BigEndian, buf)
 		if err == io.EOF {
@@ -106,9 +103,9 @@ func readMnist(prefix string) []mnistSample {
 		}
 		onehot[label] = 1.0
 
-		samples = append(samples, mnistSample{
-			input:  img,
-			target: onehot,
+		samples = append(samples, network.Sample{
+			Input:  img,
+			Target: onehot,
 		})
 	}
 
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"math/rand"
 	"os"
@@ -9,6 +10,7 @@ import (
 
 	network "github.com/farhaven/nn-go"
 	"github.com/farhaven/nn-go/activation"
+	"github.com/farhaven/nn-go/optimizer"
 )
 
 func profTask() {
@@ -34,27 +36,117 @@ func profTask() {
 	logger.Println(`profile timer expired`)
 }
 
+// newDenseNetwork creates a network with a single fully connected hidden layer.
+func newDenseNetwork(rng *rand.Rand) (*network.Network, error) {
+	config := []network.LayerConf{
+		{Inputs: 28 * 28},
+		{Inputs: 80, Activation: activation.LeakyReLU{Leak: 0.001}, WeightDecay: 1e-4, Dropout: 0.2},
+		{Inputs: 10, Activation: activation.Softmax{}, WeightDecay: 1e-4},
+	}
+
+	return network.New(config, network.WithSeed(rng.Int63()))
+}
+
+// newLeNet creates a LeNet-5 style convolutional network: two convolutions with max pooling, followed by three
+// fully connected layers.
+func newLeNet(rng *rand.Rand) (*network.Network, error) {
+	act := activation.LeakyReLU{Leak: 0.001}
+
+	conv1, err := network.NewConv2D(network.Conv2DConf{
+		Input:      network.Shape{Channels: 1, Height: 28, Width: 28},
+		Filters:    6,
+		Kernel:     5,
+		Padding:    2,
+		Activation: act,
+		Bias:       true,
+	}, rng)
+	if err != nil {
+		return nil, err
+	}
+
+	pool1, err := network.NewMaxPool2D(network.Pool2DConf{Input: conv1.OutputShape(), Size: 2})
+	if err != nil {
+		return nil, err
+	}
+
+	conv2, err := network.NewConv2D(network.Conv2DConf{
+		Input:      pool1.OutputShape(),
+		Filters:    16,
+		Kernel:     5,
+		Activation: act,
+		Bias:       true,
+	}, rng)
+	if err != nil {
+		return nil, err
+	}
+
+	pool2, err := network.NewMaxPool2D(network.Pool2DConf{Input: conv2.OutputShape(), Size: 2})
+	if err != nil {
+		return nil, err
+	}
+
+	flatten := network.NewFlatten(pool2.OutputShape())
+	_, inputs := flatten.Dims()
+
+	layers := []network.Layer{conv1, pool1, conv2, pool2, flatten}
+
+	for _, conf := range []network.LayerConf{
+		{Inputs: 120, Activation: act, WeightDecay: 1e-4, Bias: true},
+		{Inputs: 84, Activation: act, WeightDecay: 1e-4, Bias: true},
+		{Inputs: 10, Activation: activation.Softmax{}, WeightDecay: 1e-4, Bias: true},
+	} {
+		l, err := network.NewDense(inputs, conf, rng)
+		if err != nil {
+			return nil, err
+		}
+
+		layers = append(layers, l)
+		inputs = conf.Inputs
+	}
+
+	return network.NewFromLayers(layers, network.WithSeed(rng.Int63()))
+}
+
 func main() {
+	seed := flag.Int64("seed", time.Now().UnixNano(), "seed for random numbers")
+	model := flag.String("model", "lenet", "model to train, either lenet or dense")
+
+	flag.Parse()
+
 	logger := log.New(os.Stdout, `[MAIN ] `, log.LstdFlags)
+	logger.Println(`using seed`, *seed)
 
-	rand.Seed(time.Now().Unix())
+	rng := rand.New(rand.NewSource(*seed))
 
-	samples := readMnist(`train`)
-	logger.Println(`training data loaded, starting training`)
+	var (
+		net      *network.Network
+		snapshot string
+		err      error
+	)
 
-	config := []network.LayerConf{
-		{Inputs: 28 * 28},
-		{Inputs: 80, Activation: activation.LeakyReLU{Leak: 0.001, Cap: 1e6}},
-		{Inputs: 10, Activation: activation.Sigmoid{}},
+	switch *model {
+	case "lenet":
+		net, err = newLeNet(rng)
+		snapshot = `mnist-lenet`
+	case "dense":
+		// Keep the path used before there was a choice of models, so that existing snapshots are still picked up
+		net, err = newDenseNetwork(rng)
+		snapshot = `mnist-network`
+	default:
+		log.Fatalln(`unknown model`, *model)
 	}
-	net, err := network.New(config)
 	if err != nil {
 		log.Fatalln(`can't create network:`, err)
 	}
+	net.SetOptimizer(optimizer.Momentum{Mu: 0.9})
+	net.SetClipping(network.Clipping{GlobalNorm: 5})
+
+	samples := readMnist(`train`)
+	logger.Println(`training data loaded, starting training`)
 
 	go profTask()
 
-	err = trainNetwork(net, samples)
+	err = trainNetwork(net, samples, snapshot)
 	if err != nil {
 		log.Fatalln("failed to train network:", err)
 	}
@@ -64,12 +156,9 @@ func main() {
 	errors := 0
 	samples = readMnist(`t10k`)
 	for _, s := range samples {
-		output := net.Forward(s.input)
-		label := maxIdx(output)
-		if label != maxIdx(s.target) {
-			errors += 1
-		}
+		output := net.Forward(s.Input)
+		errors += int(network.ClassificationError(output, s.Target))
 	}
-	errorRate := float64(errors) / float64(len(samples)+1)
+	errorRate := float64(errors) / float64(len(samples))
 	logger.Printf(`errors: %d/%d (%.3f%% error)`, errors, len(samples), errorRate*100)
 }
@@ -2,15 +2,20 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"math"
 	"math/rand"
 	"os"
 	"time"
 
+	"gonum.org/v1/gonum/floats"
+
 	network "github.com/farhaven/nn-go"
 	"github.com/farhaven/nn-go/activation"
+	"github.com/farhaven/nn-go/loss"
 )
 
 type trainAs int
@@ -21,59 +26,131 @@ const (
 	TrainNone
 )
 
-type NGram struct {
-	r io.Reader
-	n int
+const (
+	messageLength = 1024 // Messages are truncated or padded with zeros to this many bytes
+	bitsPerByte   = 8
+	ngramSize     = 3 // Number of consecutive bytes seen by each filter
+	numFilters    = 40
+	numUnits      = 32  // Size of the state of the recurrent model
+	truncate      = 100 // Number of bytes after which backpropagation through time is cut off
+)
 
-	buf  []byte
-	rbuf [1024]byte
-}
+// trainingLoss is the loss the networks are trained with. Their outputs come from Tanh and aren't probabilities,
+// so cross-entropy doesn't apply.
+var trainingLoss = loss.SquaredError{}
+
+// encode turns the first messageLength bytes of msg into inputs for the network. Each bit of a byte is a separate
+// channel, laid out as described by network.Shape, so that the network doesn't have to learn that bytes with
+// similar values aren't necessarily similar.
+func encode(msg []byte) []float64 {
+	res := make([]float64, bitsPerByte*messageLength)
 
-func (n *NGram) Scan() ([]byte, error) {
-	if n.n > len(n.rbuf) || n.n == 0 {
-		panic("ngram length must be between 1 and 1024")
+	if len(msg) > messageLength {
+		msg = msg[:messageLength]
 	}
 
-	if len(n.buf) < n.n {
-		// Shift buffered data to the left, and read more data info the buffer after that.
-		copy(n.rbuf[:], n.buf)
+	for pos, b := range msg {
+		for bit := 0; bit < bitsPerByte; bit++ {
+			if b&(1<<bit) != 0 {
+				res[bit*messageLength+pos] = 1
+			}
+		}
+	}
 
-		// Read in more from the buffer. If we can't, return an error.
-		nn, err := n.r.Read(n.rbuf[len(n.buf):])
-		if err != nil {
-			return nil, err
+	return res
+}
+
+// encodeSequence turns the first messageLength bytes of msg into a sequence of inputs for the recurrent model, one
+// step per byte with one input per bit. Empty messages are encoded as a single zero byte, so that there is always
+// at least one step.
+func encodeSequence(msg []byte) [][]float64 {
+	if len(msg) > messageLength {
+		msg = msg[:messageLength]
+	}
+	if len(msg) == 0 {
+		msg = []byte{0}
+	}
+
+	res := make([][]float64, len(msg))
+	for pos, b := range msg {
+		res[pos] = make([]float64, bitsPerByte)
+		for bit := 0; bit < bitsPerByte; bit++ {
+			if b&(1<<bit) != 0 {
+				res[pos][bit] = 1
+			}
 		}
+	}
 
-		n.buf = n.rbuf[:nn+len(n.buf)]
+	return res
+}
+
+// newNetwork creates a network for the given model, either "lstm" or "conv".
+func newNetwork(model string, seed int64) (*network.Network, error) {
+	switch model {
+	case "lstm":
+		return newRecurrentNetwork(seed)
+	case "conv":
+		return newConvNetwork(seed)
+	default:
+		return nil, fmt.Errorf("unknown model %q", model)
 	}
+}
+
+// newRecurrentNetwork creates a network that reads a message byte by byte and updates the state of an LSTM with
+// each of them. The score after the last byte classifies the whole message.
+func newRecurrentNetwork(seed int64) (*network.Network, error) {
+	rng := rand.New(rand.NewSource(seed))
 
-	if len(n.buf) < n.n {
-		return nil, io.EOF
+	lstm, err := network.NewLSTM(bitsPerByte, network.RecurrentConf{Units: numUnits, Bias: true}, rng)
+	if err != nil {
+		return nil, err
 	}
 
-	res := n.buf[:n.n]
-	n.buf = n.buf[1:]
+	output, err := network.NewDense(numUnits, network.LayerConf{Inputs: 2, Activation: activation.Tanh{}, Bias: true}, rng)
+	if err != nil {
+		return nil, err
+	}
 
-	return res, nil
+	return network.NewFromLayers([]network.Layer{lstm, output}, network.WithSeed(rng.Int63()))
 }
 
-const (
-	ngramSize  = 3
-	memorySize = 2
-)
+// newConvNetwork creates a network that slides filters over windows of ngramSize bytes of a message and passes the
+// strongest response of each filter on to the output layer, regardless of where in the message it occurred.
+func newConvNetwork(seed int64) (*network.Network, error) {
+	rng := rand.New(rand.NewSource(seed))
+
+	conv, err := network.NewConv1D(network.Conv1DConf{
+		Channels:   bitsPerByte,
+		Length:     messageLength,
+		Filters:    numFilters,
+		Kernel:     ngramSize,
+		Activation: activation.Tanh{},
+		Bias:       true,
+	}, rng)
+	if err != nil {
+		return nil, err
+	}
 
-func train(r io.Reader, net *network.Network, t trainAs) error {
-	n := NGram{
-		r: r,
-		n: ngramSize,
+	pool, err := network.NewGlobalMaxPool(conv.OutputShape())
+	if err != nil {
+		return nil, err
 	}
 
-	var (
-		input  [ngramSize + memorySize]float64
-		target []float64
-	)
+	output, err := network.NewDense(numFilters, network.LayerConf{Inputs: 2, Activation: activation.Tanh{}}, rng)
+	if err != nil {
+		return nil, err
+	}
 
-	score := make([]float64, memorySize)
+	return network.NewFromLayers([]network.Layer{conv, pool, output}, network.WithSeed(rng.Int63()))
+}
+
+func train(r io.Reader, net *network.Network, t trainAs) error {
+	msg, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	var target []float64
 
 	switch t {
 	case TrainSpam:
@@ -82,32 +159,24 @@ func train(r io.Reader, net *network.Network, t trainAs) error {
 		target = []float64{0, 1}
 	}
 
-	for {
-		rawInput, err := n.Scan()
-		if err != nil {
-			break
-		}
+	var score []float64
 
-		for i, r := range rawInput {
-			input[i] = float64(r) / 255.0
+	if _, recurrent := net.Layers()[0].(network.Recurrent); recurrent {
+		score, err = trainSequence(net, encodeSequence(msg), target)
+		if err != nil {
+			return err
 		}
+	} else {
+		input := encode(msg)
 
-		// Feedback score of previous n-gram to provide the network with a bit of bias in the form
-		// of memory.
-		input[ngramSize] = score[0]
-		input[ngramSize+1] = score[1]
-
-		p := net.Forward(input[:])
+		score = net.Forward(input)
 
 		if t != TrainNone {
-			net.Backprop(input[:], network.Error(p, target), 0.001)
+			net.Backprop(input, trainingLoss.Error(score, target), 0.001)
 		}
-
-		score[0] = p[0]
-		score[1] = p[1]
 	}
 
-	log.Println("final score:", score)
+	log.Println("score:", score)
 
 	if math.Abs(score[0]-score[1]) > 0.5 {
 		if score[0] > score[1] {
@@ -122,14 +191,59 @@ func train(r io.Reader, net *network.Network, t trainAs) error {
 	return nil
 }
 
+// trainSequence computes the score of a message for a recurrent network and trains it on the target if it isn't
+// nil. The message is processed in chunks of truncate bytes like by network.TrainSequence, and the score is the
+// output for the last byte, computed before the last update. Every byte gets the target of the whole message, so
+// that truncated backpropagation through time still teaches the network something about the beginning of long
+// messages.
+func trainSequence(net *network.Network, inputs [][]float64, target []float64) ([]float64, error) {
+	net.ResetState()
+
+	var score []float64
+
+	for start := 0; start < len(inputs); start += truncate {
+		end := start + truncate
+		if end > len(inputs) {
+			end = len(inputs)
+		}
+
+		outputs, err := net.ForwardSequence(inputs[start:end])
+		if err != nil {
+			return nil, err
+		}
+
+		score = outputs[len(outputs)-1]
+
+		if target == nil {
+			continue
+		}
+
+		// Follow the gradient of the mean loss over the whole message, like network.TrainSequence does
+		errs := make([][]float64, len(outputs))
+		for idx, o := range outputs {
+			errs[idx] = trainingLoss.Error(o, target)
+			floats.Scale(1/float64(len(inputs)), errs[idx])
+		}
+
+		err = net.BackpropSequence(errs, 0.001)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return score, nil
+}
+
 func main() {
 	input := flag.String("input", "-", "input. if -, reads from stdin")
 	class := flag.String("class", "none", "spam or ham")
 	name := flag.String("name", "/tmp/brain", "name for persisting the network")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "seed for random numbers")
+	model := flag.String("model", "lstm", "model to use, lstm or conv")
 
 	flag.Parse()
 
-	rand.Seed(time.Now().UnixNano())
+	log.Println("using seed", *seed)
 
 	var t trainAs
 
@@ -146,13 +260,7 @@ func main() {
 
 	log.Println("here we go", *input)
 
-	config := []network.LayerConf{
-		{Inputs: ngramSize + memorySize},
-		{Inputs: 40, Activation: activation.Tanh{}},
-		{Inputs: 2, Activation: activation.Tanh{}},
-	}
-
-	net, err := network.New(config)
+	net, err := newNetwork(*model, *seed)
 	if err != nil {
 		log.Fatalln("can't create network:", err)
 	}
@@ -166,7 +274,7 @@ func main() {
 			log.Println("restoring network failed:", err)
 
 			// Re-initialize network
-			net, err = network.New(config)
+			net, err = newNetwork(*model, *seed)
 			if err != nil {
 				log.Fatalln("can't create network:", err)
 			}
@@ -5,63 +5,115 @@ import (
 	"testing"
 )
 
-func TestNGram(t *testing.T) {
-	buf := bytes.NewBuffer([]byte("1234567890"))
+func TestEncode(t *testing.T) {
+	input := encode([]byte{0x01, 0x82})
 
-	r := NGram{
-		r: buf,
-		n: 3,
+	if len(input) != bitsPerByte*messageLength {
+		t.Fatal("unexpected input size:", len(input))
 	}
 
-	var prev []byte
-
-	for {
-		g, err := r.Scan()
-		if err != nil {
-			t.Log("err:", err)
-			break
+	for _, idx := range []int{0, 1*messageLength + 1, 7*messageLength + 1} {
+		if input[idx] != 1 {
+			t.Error("bit not set:", idx)
 		}
+	}
 
-		if len(g) != r.n {
-			t.Error("unexpected:", g)
-		}
+	sum := float64(0)
+	for _, v := range input {
+		sum += v
+	}
+
+	if sum != 3 {
+		t.Error("unexpected number of set bits:", sum)
+	}
+
+	// Long messages are truncated
+	long := bytes.Repeat([]byte{0xff}, 2*messageLength)
+
+	sum = 0
+	for _, v := range encode(long) {
+		sum += v
+	}
+
+	if sum != bitsPerByte*messageLength {
+		t.Error("unexpected number of set bits:", sum)
+	}
+}
+
+func TestEncodeSequence(t *testing.T) {
+	inputs := encodeSequence([]byte{0x01, 0x82})
+
+	expected := [][]float64{
+		{1, 0, 0, 0, 0, 0, 0, 0},
+		{0, 1, 0, 0, 0, 0, 0, 1},
+	}
 
-		t.Log("ngram:", string(g))
+	if len(inputs) != len(expected) {
+		t.Fatal("unexpected number of steps:", len(inputs))
+	}
 
-		if prev != nil {
-			if g[0] != prev[1] || g[1] != prev[2] {
-				t.Error("shift error:", string(g), string(prev))
+	for idx := range expected {
+		for bit := range expected[idx] {
+			if inputs[idx][bit] != expected[idx][bit] {
+				t.Errorf("step %d: expected %v, got %v", idx, expected[idx], inputs[idx])
+				break
 			}
 		}
+	}
+
+	// Long messages are truncated, empty ones get a single step
+	if steps := len(encodeSequence(bytes.Repeat([]byte{0xff}, 2*messageLength))); steps != messageLength {
+		t.Error("unexpected number of steps:", steps)
+	}
 
-		prev = g
+	if steps := len(encodeSequence(nil)); steps != 1 {
+		t.Error("unexpected number of steps:", steps)
 	}
 }
 
-func BenchmarkNGram(b *testing.B) {
-	var raw [1024 * 10]byte
+func TestNetworkShape(t *testing.T) {
+	msg := []byte("buy cheap stuff now")
 
-	for i := 0; i < 1024; i++ {
-		copy(raw[i*10:(i+1)*10], []byte("1234567890"))
+	net, err := newNetwork("conv", 1)
+	if err != nil {
+		t.Fatal("can't create network:", err)
 	}
 
-	b.ReportAllocs()
+	score, err := net.Predict(encode(msg))
+	if err != nil {
+		t.Fatal("can't predict:", err)
+	}
 
-	for i := 0; i < b.N; i++ {
-		buf := bytes.NewBuffer(raw[:])
+	if len(score) != 2 {
+		t.Error("unexpected score:", score)
+	}
 
-		r := NGram{
-			r: buf,
-			n: 3,
-		}
+	net, err = newNetwork("lstm", 1)
+	if err != nil {
+		t.Fatal("can't create network:", err)
+	}
 
-		for {
-			g, err := r.Scan()
-			if err != nil {
-				break
-			}
+	score, err = trainSequence(net, encodeSequence(msg), []float64{1, 0})
+	if err != nil {
+		t.Fatal("can't train:", err)
+	}
 
-			_ = g
-		}
+	if len(score) != 2 {
+		t.Error("unexpected score:", score)
+	}
+
+	_, err = newNetwork("markov", 1)
+	if err == nil {
+		t.Error("expected an error for an unknown model")
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	raw := bytes.Repeat([]byte("1234567890"), 1024)
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		_ = encode(raw)
 	}
 }
@@ -0,0 +1,610 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+
+	"github.com/farhaven/nn-go/activation"
+	"github.com/farhaven/nn-go/initializer"
+)
+
+// Shape describes the layout of the inputs or outputs of a spatial layer like Conv2D. Layers exchange flat
+// vectors, so spatial data is stored channel by channel, and each channel row by row. An image with a single
+// channel is just its pixels in row-major order.
+type Shape struct {
+	Channels int
+	Height   int
+	Width    int
+}
+
+// Size returns the length of the vectors with shape s.
+func (s Shape) Size() int {
+	return s.Channels * s.Height * s.Width
+}
+
+// index returns the position of the value in channel c, row y and column x in a vector with shape s.
+func (s Shape) index(c, y, x int) int {
+	return (c*s.Height+y)*s.Width + x
+}
+
+// Conv2DConf configures a Conv2D layer.
+//
+// Input is the shape of the inputs of the layer. The layer has Filters output channels, each of which is computed by
+// sliding a filter of Kernel x Kernel weights for every input channel over the inputs. The filter is moved by Stride
+// rows and columns at a time, 0 means 1. Padding is the number of rows and columns of zeros added on each side of
+// the inputs, so that for a stride of 1, a padding of (Kernel - 1) / 2 keeps the height and width of the inputs.
+//
+// Activation, Initializer and Bias are the same as for LayerConf. The fan-in seen by the initializer is the number
+// of weights of a filter. Each output channel has a single bias. Only element-wise activations are supported, so
+// neither Softmax nor trainable activations can be used.
+type Conv2DConf struct {
+	Input   Shape
+	Filters int
+	Kernel  int
+	Stride  int
+	Padding int
+
+	Activation  activation.Activation
+	Initializer initializer.Initializer
+	Bias        bool
+}
+
+// Conv2D is a two-dimensional convolutional layer. It is configured with a Conv2DConf, and its inputs and outputs
+// are laid out as described by Shape.
+type Conv2D struct {
+	convolution
+}
+
+// NewConv2D creates a convolutional layer configured by conf. The weights are initialized with random values drawn
+// from rng.
+func NewConv2D(conf Conv2DConf, rng *rand.Rand) (*Conv2D, error) {
+	err := validateConv(conf.Activation, conf.Filters, conf.Kernel, conf.Stride, conf.Padding)
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.Input.Channels <= 0 || conf.Input.Height <= 0 || conf.Input.Width <= 0 {
+		return nil, fmt.Errorf("invalid input shape %+v", conf.Input)
+	}
+
+	if conf.Stride == 0 {
+		conf.Stride = 1
+	}
+
+	g, err := newConvGeometry(conf.Input, conf.Filters, conf.Kernel, conf.Kernel, conf.Stride, conf.Padding, conf.Padding)
+	if err != nil {
+		return nil, err
+	}
+
+	weights, bias := newConvWeights(g, conf.Activation, conf.Initializer, conf.Bias, rng)
+
+	l := &Conv2D{}
+	l.init(g, conf.Activation, weights, bias)
+
+	return l, nil
+}
+
+// OutputShape returns the shape of the outputs of l.
+func (l *Conv2D) OutputShape() Shape {
+	return l.geometry.output
+}
+
+// Clone returns a deep copy of l.
+func (l *Conv2D) Clone() Layer {
+	clone := &Conv2D{}
+	l.clone(&clone.convolution)
+
+	return clone
+}
+
+var _ Layer = &Conv2D{}
+
+// Conv1DConf configures a Conv1D layer.
+//
+// The inputs of the layer are a sequence of Length steps with Channels values each. Like for all layers, they are
+// laid out as described by Shape, that is as Shape{Channels, 1, Length}: the value of channel c at step t is at
+// index c * Length + t. The layer has Filters output channels, each of which is computed by sliding a filter of
+// Kernel weights for every input channel over the sequence. The filter is moved by Stride steps at a time, 0 means
+// 1. Padding is the number of zeros added at each end of the sequence.
+//
+// Activation, Initializer and Bias are the same as for Conv2DConf.
+type Conv1DConf struct {
+	Channels int
+	Length   int
+	Filters  int
+	Kernel   int
+	Stride   int
+	Padding  int
+
+	Activation  activation.Activation
+	Initializer initializer.Initializer
+	Bias        bool
+}
+
+// Conv1D is a one-dimensional convolutional layer for sequences, for example of bytes or tokens. It is configured
+// with a Conv1DConf.
+type Conv1D struct {
+	convolution
+}
+
+// NewConv1D creates a convolutional layer configured by conf. The weights are initialized with random values drawn
+// from rng.
+func NewConv1D(conf Conv1DConf, rng *rand.Rand) (*Conv1D, error) {
+	err := validateConv(conf.Activation, conf.Filters, conf.Kernel, conf.Stride, conf.Padding)
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.Channels <= 0 || conf.Length <= 0 {
+		return nil, fmt.Errorf("invalid input size %dx%d", conf.Channels, conf.Length)
+	}
+
+	if conf.Stride == 0 {
+		conf.Stride = 1
+	}
+
+	input := Shape{Channels: conf.Channels, Height: 1, Width: conf.Length}
+
+	g, err := newConvGeometry(input, conf.Filters, 1, conf.Kernel, conf.Stride, 0, conf.Padding)
+	if err != nil {
+		return nil, err
+	}
+
+	weights, bias := newConvWeights(g, conf.Activation, conf.Initializer, conf.Bias, rng)
+
+	l := &Conv1D{}
+	l.init(g, conf.Activation, weights, bias)
+
+	return l, nil
+}
+
+// OutputShape returns the shape of the outputs of l. Its height is 1, and its width is the length of the output
+// sequence.
+func (l *Conv1D) OutputShape() Shape {
+	return l.geometry.output
+}
+
+// Clone returns a deep copy of l.
+func (l *Conv1D) Clone() Layer {
+	clone := &Conv1D{}
+	l.clone(&clone.convolution)
+
+	return clone
+}
+
+var _ Layer = &Conv1D{}
+
+// hasBias returns whether l has biases.
+func (l *convolution) hasBias() bool {
+	return l.bias != nil
+}
+
+// convolution implements what Conv1D and Conv2D have in common. Its inputs and outputs are laid out as described
+// by Shape, and the kernel is moved over them as described by its geometry.
+type convolution struct {
+	geometry   convGeometry
+	activation activation.Activation
+
+	weights    *mat.Dense // One filter per row, with the weights for each input channel in turn
+	bias       []float64  // nil if the layer is unbiased
+	weightStep *mat.Dense
+	biasStep   []float64
+
+	// Position in the inputs of each entry of the unrolled inputs, see unroll. Entries that fall into the padding
+	// are -1.
+	patches []int
+
+	cols  *mat.Dense // Unrolled inputs of the last forward pass
+	sum   *mat.Dense // Weighted inputs before the activation, one output channel per row
+	out   *mat.Dense
+	delta *mat.Dense
+
+	batch convBatch // State of the last call of ForwardBatch
+
+	predictScratch sync.Pool // Unrolled inputs and weighted inputs for Predict
+}
+
+// convGeometry describes how the kernel of a convolution is moved over its inputs.
+type convGeometry struct {
+	input  Shape
+	output Shape
+
+	kernelHeight  int
+	kernelWidth   int
+	stride        int
+	paddingHeight int // Rows of zeros above and below the inputs
+	paddingWidth  int // Columns of zeros left and right of the inputs
+}
+
+// newConvGeometry returns the geometry of a kernel of the given size on inputs of the given shape, producing the
+// given number of output channels. It fails if the kernel doesn't fit into the padded inputs.
+func newConvGeometry(input Shape, filters, kernelHeight, kernelWidth, stride, paddingHeight, paddingWidth int) (convGeometry, error) {
+	g := convGeometry{
+		input: input,
+		output: Shape{
+			Channels: filters,
+			Height:   convOutputSize(input.Height, kernelHeight, stride, paddingHeight),
+			Width:    convOutputSize(input.Width, kernelWidth, stride, paddingWidth),
+		},
+
+		kernelHeight:  kernelHeight,
+		kernelWidth:   kernelWidth,
+		stride:        stride,
+		paddingHeight: paddingHeight,
+		paddingWidth:  paddingWidth,
+	}
+
+	if g.output.Height <= 0 || g.output.Width <= 0 {
+		return convGeometry{}, fmt.Errorf("kernel of size %dx%d doesn't fit into padded inputs of shape %+v", kernelHeight, kernelWidth, input)
+	}
+
+	return g, nil
+}
+
+// convBatch holds the state of a convolution for a batch of samples.
+type convBatch struct {
+	cols    []*mat.Dense // Unrolled inputs of each sample
+	sums    *mat.Dense   // Weighted inputs of each sample, one per row
+	outputs *mat.Dense
+}
+
+// convScratch holds the buffers used by convolution.Predict.
+type convScratch struct {
+	cols *mat.Dense
+	sum  *mat.Dense
+}
+
+// validateConv checks the settings shared by the configurations of Conv1D and Conv2D.
+func validateConv(act activation.Activation, filters, kernel, stride, padding int) error {
+	if act == nil {
+		return errors.New("no activation")
+	}
+
+	if _, ok := act.(activation.Trainable); ok {
+		return errors.New("trainable activations are not supported")
+	}
+
+	if _, ok := act.(activation.Vector); ok {
+		return errors.New("activations that aren't element-wise are not supported")
+	}
+
+	if filters <= 0 || kernel <= 0 {
+		return errors.New("number of filters and kernel size must be positive")
+	}
+
+	if stride < 0 || padding < 0 {
+		return errors.New("negative stride or padding")
+	}
+
+	return nil
+}
+
+// newConvWeights returns the weights for a convolution with the given geometry, initialized by init or by the
+// default initializer for act if init is nil, and its biases if bias is set.
+func newConvWeights(g convGeometry, act activation.Activation, init initializer.Initializer, bias bool, rng *rand.Rand) (*mat.Dense, []float64) {
+	if init == nil {
+		init = defaultInitializer(act)
+	}
+
+	weights := mat.NewDense(g.output.Channels, g.input.Channels*g.kernelHeight*g.kernelWidth, nil)
+	init.Initialize(weights, rng)
+
+	// Biases start out at zero
+	if !bias {
+		return weights, nil
+	}
+
+	return weights, make([]float64, g.output.Channels)
+}
+
+// init sets up l with the given geometry, activation and parameters, and allocates the buffers it works on.
+func (l *convolution) init(g convGeometry, act activation.Activation, weights *mat.Dense, bias []float64) {
+	rows, cols := weights.Dims()
+	positions := g.output.Height * g.output.Width
+
+	l.geometry = g
+	l.activation = act
+	l.weights = weights
+	l.bias = bias
+	l.weightStep = mat.NewDense(rows, cols, nil)
+	l.patches = make([]int, cols*positions)
+	l.cols = mat.NewDense(cols, positions, nil)
+	l.sum = mat.NewDense(rows, positions, nil)
+	l.out = mat.NewDense(rows, positions, nil)
+	l.delta = mat.NewDense(rows, positions, nil)
+
+	if bias != nil {
+		l.biasStep = make([]float64, len(bias))
+	}
+
+	kh, kw := g.kernelHeight, g.kernelWidth
+	for c := 0; c < g.input.Channels; c++ {
+		for ky := 0; ky < kh; ky++ {
+			for kx := 0; kx < kw; kx++ {
+				row := (c*kh+ky)*kw + kx
+
+				for oy := 0; oy < g.output.Height; oy++ {
+					for ox := 0; ox < g.output.Width; ox++ {
+						y := oy*g.stride + ky - g.paddingHeight
+						x := ox*g.stride + kx - g.paddingWidth
+
+						idx := -1
+						if y >= 0 && y < g.input.Height && x >= 0 && x < g.input.Width {
+							idx = g.input.index(c, y, x)
+						}
+
+						l.patches[row*positions+oy*g.output.Width+ox] = idx
+					}
+				}
+			}
+		}
+	}
+}
+
+// clone initializes dst as a deep copy of l.
+func (l *convolution) clone(dst *convolution) {
+	var bias []float64
+	if l.bias != nil {
+		bias = append([]float64{}, l.bias...)
+	}
+
+	dst.init(l.geometry, l.activation, mat.DenseCopyOf(l.weights), bias)
+	dst.weightStep.Copy(l.weightStep)
+	copy(dst.biasStep, l.biasStep)
+
+	// ForwardBatch replaces the batch state instead of modifying it, so it can be shared
+	dst.batch = l.batch
+}
+
+// convOutputSize returns the number of positions of a kernel of the given size on inputs of the given size.
+func convOutputSize(inputs, kernel, stride, padding int) int {
+	if inputs+2*padding < kernel {
+		return 0
+	}
+	return (inputs+2*padding-kernel)/stride + 1
+}
+
+// Dims returns the number of inputs and outputs of l.
+func (l *convolution) Dims() (int, int) {
+	return l.geometry.input.Size(), l.geometry.output.Size()
+}
+
+// unroll writes the inputs covered by the kernel at each position to cols, one position per column. This turns the
+// convolution into a single matrix multiplication with the weights.
+func (l *convolution) unroll(cols *mat.Dense, inputs []float64) {
+	data := cols.RawMatrix().Data
+	for i, idx := range l.patches {
+		if idx < 0 {
+			data[i] = 0
+		} else {
+			data[i] = inputs[idx]
+		}
+	}
+}
+
+// predict computes the weighted inputs and outputs of l for the unrolled inputs in cols and stores them in sum and
+// output. sample is the index of the inputs within a batch, it is only used to report non-finite outputs.
+func (l *convolution) predict(sum, output, cols *mat.Dense, sample int) error {
+	sum.Mul(l.weights, cols)
+
+	if l.bias != nil {
+		for idx, b := range l.bias {
+			row := sum.RawRowView(idx)
+			for i := range row {
+				row[i] += b
+			}
+		}
+	}
+
+	activate(l.activation, output.RawMatrix().Data, sum.RawMatrix().Data)
+
+	return checkOutputs(sum.RawMatrix().Data, output.RawMatrix().Data, sample)
+}
+
+// Forward computes the weighted inputs and outputs of l for the given inputs and stores them in l. It returns the
+// outputs that are passed on to the next layer.
+func (l *convolution) Forward(inputs []float64, training bool, rng *rand.Rand) ([]float64, error) {
+	l.unroll(l.cols, inputs)
+
+	err := l.predict(l.sum, l.out, l.cols, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.out.RawMatrix().Data, nil
+}
+
+// Predict computes the outputs of l for the given inputs like Forward, and writes them to dst. Since it doesn't
+// modify l, it is safe for concurrent use.
+func (l *convolution) Predict(dst, inputs []float64) error {
+	s, ok := l.predictScratch.Get().(*convScratch)
+	if !ok {
+		rows, cols := l.cols.Dims()
+		s = &convScratch{
+			cols: mat.NewDense(rows, cols, nil),
+			sum:  mat.NewDense(l.geometry.output.Channels, cols, nil),
+		}
+	}
+	defer l.predictScratch.Put(s)
+
+	l.unroll(s.cols, inputs)
+
+	return l.predict(s.sum, mat.NewDense(l.geometry.output.Channels, l.geometry.output.Height*l.geometry.output.Width, dst), s.cols, 0)
+}
+
+// Backward computes the steps for the weights and biases of l from the error at its outputs, and returns the error
+// at its inputs.
+func (l *convolution) Backward(error []float64) []float64 {
+	computeDeltas(l.activation, l.delta.RawMatrix().Data, l.sum.RawMatrix().Data, l.out.RawMatrix().Data, error)
+
+	// Compute: Step = Delta * Cols^T
+	l.weightStep.Mul(l.delta, l.cols.T())
+
+	for idx := range l.biasStep {
+		l.biasStep[idx] = floats.Sum(l.delta.RawRowView(idx))
+	}
+
+	return l.propagate(make([]float64, l.geometry.input.Size()), l.delta)
+}
+
+// propagate computes the error at the inputs of l from the given deltas and adds it to dst, which it returns.
+func (l *convolution) propagate(dst []float64, delta *mat.Dense) []float64 {
+	var cols mat.Dense
+	cols.Mul(l.weights.T(), delta)
+
+	// Inputs that are covered by several positions of the kernel collect the errors of all of them
+	data := cols.RawMatrix().Data
+	for i, idx := range l.patches {
+		if idx >= 0 {
+			dst[idx] += data[i]
+		}
+	}
+
+	return dst
+}
+
+// ForwardBatch computes the weighted inputs and outputs of l for a batch of inputs with one sample per row. The
+// state used by Backward is left untouched.
+func (l *convolution) ForwardBatch(inputs *mat.Dense, training bool, rng *rand.Rand) (*mat.Dense, error) {
+	samples, _ := inputs.Dims()
+	rows, cols := l.cols.Dims()
+	filters, positions := l.sum.Dims()
+
+	l.batch = convBatch{
+		cols:    make([]*mat.Dense, samples),
+		sums:    mat.NewDense(samples, l.geometry.output.Size(), nil),
+		outputs: mat.NewDense(samples, l.geometry.output.Size(), nil),
+	}
+
+	for i := 0; i < samples; i++ {
+		l.batch.cols[i] = mat.NewDense(rows, cols, nil)
+		l.unroll(l.batch.cols[i], inputs.RawRowView(i))
+
+		sum := mat.NewDense(filters, positions, l.batch.sums.RawRowView(i))
+		output := mat.NewDense(filters, positions, l.batch.outputs.RawRowView(i))
+
+		err := l.predict(sum, output, l.batch.cols[i], i)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return l.batch.outputs, nil
+}
+
+// BackwardBatch computes the averaged steps for the weights and biases of l from the errors at its outputs for the
+// batch passed to the last call of ForwardBatch. It returns the errors at its inputs.
+func (l *convolution) BackwardBatch(error *mat.Dense) *mat.Dense {
+	samples, _ := error.Dims()
+	filters, positions := l.sum.Dims()
+
+	l.weightStep.Zero()
+	for idx := range l.biasStep {
+		l.biasStep[idx] = 0
+	}
+
+	res := mat.NewDense(samples, l.geometry.input.Size(), nil)
+
+	var step mat.Dense
+
+	for i := 0; i < samples; i++ {
+		delta := mat.NewDense(filters, positions, nil)
+		computeDeltas(l.activation, delta.RawMatrix().Data, l.batch.sums.RawRowView(i),
+			l.batch.outputs.RawRowView(i), error.RawRowView(i))
+
+		step.Mul(delta, l.batch.cols[i].T())
+		l.weightStep.Add(l.weightStep, &step)
+
+		for idx := range l.biasStep {
+			l.biasStep[idx] += floats.Sum(delta.RawRowView(idx))
+		}
+
+		l.propagate(res.RawRowView(i), delta)
+	}
+
+	scale := 1 / float64(samples)
+	l.weightStep.Scale(scale, l.weightStep)
+	floats.Scale(scale, l.biasStep)
+
+	return res
+}
+
+// Parameters returns the weights of l in row-major order, one filter per row, followed by the biases if l has
+// any.
+func (l *convolution) Parameters() [][]float64 {
+	res := [][]float64{l.weights.RawMatrix().Data}
+
+	if l.bias != nil {
+		res = append(res, l.bias)
+	}
+
+	return res
+}
+
+// Steps returns the steps for the parameters of l, in the layout returned by Parameters.
+func (l *convolution) Steps() [][]float64 {
+	res := [][]float64{l.weightStep.RawMatrix().Data}
+
+	if l.bias != nil {
+		res = append(res, l.biasStep)
+	}
+
+	return res
+}
+
+// WriteTo writes the weights of l to w, followed by the biases if l has any.
+func (l *convolution) WriteTo(w io.Writer) (int64, error) {
+	sz, err := l.weights.MarshalBinaryTo(w)
+	if err != nil || l.bias == nil {
+		return int64(sz), err
+	}
+
+	bsz, err := mat.NewVecDense(len(l.bias), l.bias).MarshalBinaryTo(w)
+	return int64(sz + bsz), err
+}
+
+// ReadFrom restores the weights and biases of l from r. Snapshots of biased layers can only be restored into biased
+// layers and vice versa.
+func (l *convolution) ReadFrom(r io.Reader) (int64, error) {
+	var weights mat.Dense
+
+	sz, err := weights.UnmarshalBinaryFrom(r)
+	if err != nil {
+		return 0, err
+	}
+
+	r1, c1 := weights.Dims()
+	r2, c2 := l.weights.Dims()
+	if r1 != r2 || c1 != c2 {
+		return int64(sz), fmt.Errorf("%w: weights have dimensions %dx%d, expected %dx%d", ErrArchitectureMismatch, r1, c1, r2, c2)
+	}
+
+	var bias mat.VecDense
+
+	bsz, err := bias.UnmarshalBinaryFrom(r)
+	if l.bias == nil && bsz == 0 && (errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)) {
+		l.weights.Copy(&weights)
+		return int64(sz), nil
+	}
+	if err != nil {
+		return int64(sz + bsz), fmt.Errorf("restoring biases: %w", err)
+	}
+
+	if l.bias == nil {
+		return int64(sz + bsz), fmt.Errorf("%w: snapshot has biases, but the layer is unbiased", ErrArchitectureMismatch)
+	}
+	if bias.Len() != len(l.bias) {
+		return int64(sz + bsz), fmt.Errorf("%w: %d biases, expected %d", ErrArchitectureMismatch, bias.Len(), len(l.bias))
+	}
+
+	l.weights.Copy(&weights)
+	copy(l.bias, bias.RawVector().Data)
+
+	return int64(sz + bsz), nil
+}
@@ -0,0 +1,493 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+
+	"github.com/farhaven/nn-go/activation"
+	"github.com/farhaven/nn-go/initializer"
+	"github.com/farhaven/nn-go/loss"
+	"github.com/farhaven/nn-go/optimizer"
+)
+
+func TestConv2DOutputShape(t *testing.T) {
+	for _, tc := range []struct {
+		conf     Conv2DConf
+		expected Shape
+	}{
+		{Conv2DConf{Input: Shape{1, 28, 28}, Filters: 6, Kernel: 5, Padding: 2}, Shape{6, 28, 28}},
+		{Conv2DConf{Input: Shape{6, 14, 14}, Filters: 16, Kernel: 5}, Shape{16, 10, 10}},
+		{Conv2DConf{Input: Shape{3, 7, 5}, Filters: 2, Kernel: 3, Stride: 2}, Shape{2, 3, 2}},
+		{Conv2DConf{Input: Shape{1, 4, 4}, Filters: 1, Kernel: 2, Stride: 2, Padding: 1}, Shape{1, 3, 3}},
+	} {
+		tc.conf.Activation = activation.LeakyReLU{Leak: 0.01}
+
+		l, err := NewConv2D(tc.conf, testRand())
+		if err != nil {
+			t.Errorf(`%+v: can't create layer: %v`, tc.conf, err)
+			continue
+		}
+
+		if l.OutputShape() != tc.expected {
+			t.Errorf(`%+v: expected output shape %+v, got %+v`, tc.conf, tc.expected, l.OutputShape())
+		}
+
+		inputs, outputs := l.Dims()
+		if inputs != tc.conf.Input.Size() || outputs != tc.expected.Size() {
+			t.Errorf(`%+v: unexpected dimensions %d -> %d`, tc.conf, inputs, outputs)
+		}
+	}
+}
+
+func TestNewConv2DInvalid(t *testing.T) {
+	for _, conf := range []Conv2DConf{
+		{Input: Shape{1, 5, 5}, Filters: 1, Kernel: 3},
+		{Input: Shape{1, 5, 5}, Filters: 1, Kernel: 3, Activation: activation.PReLU{}},
+		{Input: Shape{1, 5, 5}, Filters: 1, Kernel: 3, Activation: activation.Softmax{}},
+		{Input: Shape{0, 5, 5}, Filters: 1, Kernel: 3, Activation: activation.LeakyReLU{Leak: 0.01}},
+		{Input: Shape{1, 5, 5}, Filters: 0, Kernel: 3, Activation: activation.LeakyReLU{Leak: 0.01}},
+		{Input: Shape{1, 5, 5}, Filters: 1, Kernel: 3, Stride: -1, Activation: activation.LeakyReLU{Leak: 0.01}},
+		{Input: Shape{1, 5, 5}, Filters: 1, Kernel: 6, Activation: activation.LeakyReLU{Leak: 0.01}},
+	} {
+		_, err := NewConv2D(conf, testRand())
+		if err == nil {
+			t.Errorf(`%+v: expected an error`, conf)
+		}
+	}
+}
+
+func TestConv2DForward(t *testing.T) {
+	l, err := NewConv2D(Conv2DConf{
+		Input:       Shape{2, 3, 3},
+		Filters:     2,
+		Kernel:      2,
+		Padding:     1,
+		Stride:      2,
+		Activation:  activation.Identity{},
+		Initializer: initializer.Constant{Value: 1},
+		Bias:        true,
+	}, testRand())
+	if err != nil {
+		t.Fatal(`can't create layer:`, err)
+	}
+
+	// Each filter only looks at one channel
+	for idx := 0; idx < 4; idx++ {
+		l.weights.Set(0, 4+idx, 0)
+		l.weights.Set(1, idx, 0)
+	}
+	l.bias[1] = 0.5
+
+	inputs := []float64{
+		1, 2, 3,
+		4, 5, 6,
+		7, 8, 9,
+
+		1, 0, 1,
+		0, 1, 0,
+		1, 0, 1,
+	}
+
+	expected := []float64{
+		1, 5,
+		11, 28,
+
+		1.5, 1.5,
+		1.5, 2.5,
+	}
+
+	outputs, err := l.Forward(inputs, false, nil)
+	if err != nil {
+		t.Fatal(`forward pass failed:`, err)
+	}
+
+	if !floats.Equal(outputs, expected) {
+		t.Errorf(`expected outputs %v, got %v`, expected, outputs)
+	}
+
+	predicted := make([]float64, len(expected))
+
+	err = l.Predict(predicted, inputs)
+	if err != nil {
+		t.Fatal(`can't predict:`, err)
+	}
+
+	if !floats.Equal(predicted, expected) {
+		t.Errorf(`expected predictions %v, got %v`, expected, predicted)
+	}
+}
+
+func TestConv2DGradients(t *testing.T) {
+	rng := testRand()
+
+	for _, conf := range []Conv2DConf{
+		{Input: Shape{1, 5, 5}, Filters: 2, Kernel: 3},
+		{Input: Shape{2, 5, 4}, Filters: 3, Kernel: 3, Padding: 1, Bias: true},
+		{Input: Shape{3, 6, 6}, Filters: 2, Kernel: 2, Stride: 2, Bias: true},
+		{Input: Shape{2, 7, 5}, Filters: 2, Kernel: 3, Stride: 2, Padding: 1, Bias: true},
+	} {
+		conf.Activation = activation.Tanh{}
+
+		l, err := NewConv2D(conf, rng)
+		if err != nil {
+			t.Fatal(`can't create layer:`, err)
+		}
+
+		checkLayerGradients(t, l, randomInputs(3, conf.Input.Size(), rng), rng)
+	}
+}
+
+func TestConv2DSnapshot(t *testing.T) {
+	conf := Conv2DConf{Input: Shape{2, 5, 5}, Filters: 3, Kernel: 3, Activation: activation.LeakyReLU{Leak: 0.01}, Bias: true}
+
+	l, err := NewConv2D(conf, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(`can't create layer:`, err)
+	}
+	l.bias[2] = 1
+
+	var buf bytes.Buffer
+
+	_, err = l.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(`can't write layer:`, err)
+	}
+
+	restored, err := NewConv2D(conf, rand.New(rand.NewSource(2)))
+	if err != nil {
+		t.Fatal(`can't create layer:`, err)
+	}
+
+	_, err = restored.ReadFrom(&buf)
+	if err != nil {
+		t.Fatal(`can't restore layer:`, err)
+	}
+
+	clone := l.Clone()
+
+	for _, other := range []Layer{restored, clone} {
+		for idx, p := range l.Parameters() {
+			if !floats.Equal(p, other.Parameters()[idx]) {
+				t.Errorf(`%T: parameters %d differ`, other, idx)
+			}
+		}
+	}
+
+	clone.Parameters()[0][0]++
+	if l.weights.At(0, 0) == clone.Parameters()[0][0] {
+		t.Error(`clone shares its weights`)
+	}
+
+	conf.Filters = 2
+
+	other, err := NewConv2D(conf, testRand())
+	if err != nil {
+		t.Fatal(`can't create layer:`, err)
+	}
+
+	_, err = l.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(`can't write layer:`, err)
+	}
+
+	_, err = other.ReadFrom(&buf)
+	if err == nil {
+		t.Error(`expected an error when restoring a layer with a different number of filters`)
+	}
+
+	conf.Filters = 3
+	conf.Bias = false
+
+	unbiased, err := NewConv2D(conf, testRand())
+	if err != nil {
+		t.Fatal(`can't create layer:`, err)
+	}
+	weights := mat.DenseCopyOf(unbiased.weights)
+
+	buf.Reset()
+
+	_, err = l.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(`can't write layer:`, err)
+	}
+
+	_, err = unbiased.ReadFrom(&buf)
+	if !errors.Is(err, ErrArchitectureMismatch) {
+		t.Errorf(`expected an architecture mismatch when restoring biases into an unbiased layer, got %v`, err)
+	}
+	if !mat.Equal(weights, unbiased.weights) {
+		t.Error(`unbiased layer was modified`)
+	}
+}
+
+// barImages returns images of the given size with a single vertical or horizontal bar at a random position. The
+// targets are one-hot encoded, with vertical bars in the first class.
+func barImages(samples, size int, rng *rand.Rand) ([][]float64, [][]float64) {
+	var inputs, targets [][]float64
+
+	for i := 0; i < samples; i++ {
+		img := make([]float64, size*size)
+		pos := rng.Intn(size)
+
+		vertical := i%2 == 0
+		for j := 0; j < size; j++ {
+			if vertical {
+				img[j*size+pos] = 1
+			} else {
+				img[pos*size+j] = 1
+			}
+		}
+
+		target := []float64{0, 1}
+		if vertical {
+			target = []float64{1, 0}
+		}
+
+		inputs = append(inputs, img)
+		targets = append(targets, target)
+	}
+
+	return inputs, targets
+}
+
+func TestNetworkConvolution(t *testing.T) {
+	rng := testRand()
+
+	// A small convolutional network that classifies images created by barImages
+	conv, err := NewConv2D(Conv2DConf{
+		Input:      Shape{1, 6, 6},
+		Filters:    4,
+		Kernel:     3,
+		Padding:    1,
+		Activation: activation.LeakyReLU{Leak: 0.01},
+		Bias:       true,
+	}, rng)
+	if err != nil {
+		t.Fatal(`can't create convolution:`, err)
+	}
+
+	pool, err := NewMaxPool2D(Pool2DConf{Input: conv.OutputShape(), Size: 2})
+	if err != nil {
+		t.Fatal(`can't create pooling layer:`, err)
+	}
+
+	flatten := NewFlatten(pool.OutputShape())
+	_, size := flatten.Dims()
+
+	output := newDense(t, size, LayerConf{Inputs: 2, Activation: activation.Softmax{}, Bias: true}, rng)
+
+	net, err := NewFromLayers([]Layer{conv, pool, flatten, output}, WithSeed(rng.Int63()))
+	if err != nil {
+		t.Fatal(`can't create network:`, err)
+	}
+
+	net.SetOptimizer(optimizer.Adam{})
+
+	// Snapshots of the trained network are restored into a copy of the untrained one
+	restored := net.Clone()
+
+	inputs, targets := barImages(64, 6, rng)
+
+	for epoch := 0; epoch < 50; epoch++ {
+		_, err := net.TrainBatch(inputs, targets, loss.CategoricalCrossEntropy{}, 0.01)
+		if err != nil {
+			t.Fatal(`training failed:`, err)
+		}
+	}
+
+	inputs, targets = barImages(20, 6, rng)
+
+	for idx, x := range inputs {
+		output, err := net.Predict(x)
+		if err != nil {
+			t.Fatal(`can't predict:`, err)
+		}
+
+		if floats.MaxIdx(output) != floats.MaxIdx(targets[idx]) {
+			t.Errorf(`sample %d: misclassified as %v`, idx, output)
+		}
+	}
+
+	var buf bytes.Buffer
+
+	_, err = net.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(`can't snapshot network:`, err)
+	}
+
+	_, err = restored.ReadFrom(&buf)
+	if err != nil {
+		t.Fatal(`can't restore network:`, err)
+	}
+
+	for idx, p := range net.Parameters() {
+		if !floats.Equal(p, restored.Parameters()[idx]) {
+			t.Errorf(`layer %d: parameters differ after restoring`, idx)
+		}
+	}
+}
+
+func TestConv1D(t *testing.T) {
+	l, err := NewConv1D(Conv1DConf{
+		Channels:    2,
+		Length:      5,
+		Filters:     2,
+		Kernel:      3,
+		Padding:     1,
+		Activation:  activation.Identity{},
+		Initializer: initializer.Constant{Value: 1},
+		Bias:        true,
+	}, testRand())
+	if err != nil {
+		t.Fatal(`can't create layer:`, err)
+	}
+
+	if l.OutputShape() != (Shape{2, 1, 5}) {
+		t.Errorf(`unexpected output shape %+v`, l.OutputShape())
+	}
+
+	// The first filter sums up the first channel, the second one the difference of both channels
+	for idx := 0; idx < 3; idx++ {
+		l.weights.Set(0, 3+idx, 0)
+		l.weights.Set(1, idx, -1)
+	}
+	l.bias[1] = 1
+
+	inputs := []float64{
+		1, 2, 3, 4, 5,
+		1, 1, 1, 1, 1,
+	}
+
+	expected := []float64{
+		3, 6, 9, 12, 9,
+		0, -2, -5, -8, -6,
+	}
+
+	outputs, err := l.Forward(inputs, false, nil)
+	if err != nil {
+		t.Fatal(`forward pass failed:`, err)
+	}
+
+	if !floats.Equal(outputs, expected) {
+		t.Errorf(`expected outputs %v, got %v`, expected, outputs)
+	}
+
+	_, err = NewConv1D(Conv1DConf{Channels: 1, Length: 2, Filters: 1, Kernel: 3, Activation: activation.Tanh{}}, testRand())
+	if err == nil {
+		t.Error(`expected an error for a kernel that is longer than the inputs`)
+	}
+}
+
+func TestConv1DGradients(t *testing.T) {
+	rng := testRand()
+
+	for _, conf := range []Conv1DConf{
+		{Channels: 1, Length: 7, Filters: 2, Kernel: 3},
+		{Channels: 3, Length: 8, Filters: 2, Kernel: 3, Padding: 2, Bias: true},
+		{Channels: 2, Length: 9, Filters: 3, Kernel: 2, Stride: 3, Bias: true},
+	} {
+		conf.Activation = activation.Tanh{}
+
+		l, err := NewConv1D(conf, rng)
+		if err != nil {
+			t.Fatal(`can't create layer:`, err)
+		}
+
+		checkLayerGradients(t, l, randomInputs(3, conf.Channels*conf.Length, rng), rng)
+
+		clone := l.Clone()
+		if !floats.Equal(clone.Parameters()[0], l.Parameters()[0]) {
+			t.Error(`clone has different weights`)
+		}
+	}
+}
+
+// TestNetworkConvolution1D trains a network to detect whether a sequence contains the pattern 1, -1 anywhere.
+// The sequences of the other class contain both values as well, but never in that order next to each other.
+func TestNetworkConvolution1D(t *testing.T) {
+	const length = 12
+
+	rng := testRand()
+
+	conv, err := NewConv1D(Conv1DConf{
+		Channels:   1,
+		Length:     length,
+		Filters:    4,
+		Kernel:     2,
+		Padding:    1, // Values at the ends of the sequence have neighbours like all others
+		Activation: activation.Tanh{},
+		Bias:       true,
+	}, rng)
+	if err != nil {
+		t.Fatal(`can't create convolution:`, err)
+	}
+
+	pool, err := NewGlobalMaxPool(conv.OutputShape())
+	if err != nil {
+		t.Fatal(`can't create pooling layer:`, err)
+	}
+
+	output := newDense(t, 4, LayerConf{Inputs: 1, Activation: activation.Sigmoid{}, Bias: true}, rng)
+
+	net, err := NewFromLayers([]Layer{conv, pool, output}, WithSeed(rng.Int63()))
+	if err != nil {
+		t.Fatal(`can't create network:`, err)
+	}
+
+	net.SetOptimizer(optimizer.Adam{})
+
+	sequences := func(samples int) ([][]float64, [][]float64) {
+		var inputs, targets [][]float64
+
+		for i := 0; i < samples; i++ {
+			seq := make([]float64, length)
+			target := []float64{0}
+
+			if i%2 == 0 {
+				pos := rng.Intn(length - 1)
+				seq[pos], seq[pos+1] = 1, -1
+				target[0] = 1
+			} else {
+				// Both values, but never next to each other in the right order
+				a, b := rng.Intn(length), rng.Intn(length)
+				for a == b || b == a+1 {
+					a, b = rng.Intn(length), rng.Intn(length)
+				}
+				seq[a], seq[b] = 1, -1
+			}
+
+			inputs = append(inputs, seq)
+			targets = append(targets, target)
+		}
+
+		return inputs, targets
+	}
+
+	inputs, targets := sequences(64)
+
+	for epoch := 0; epoch < 200; epoch++ {
+		_, err := net.TrainBatch(inputs, targets, loss.BinaryCrossEntropy{}, 0.01)
+		if err != nil {
+			t.Fatal(`training failed:`, err)
+		}
+	}
+
+	inputs, targets = sequences(20)
+
+	for idx, x := range inputs {
+		output, err := net.Predict(x)
+		if err != nil {
+			t.Fatal(`can't predict:`, err)
+		}
+
+		if math.Round(output[0]) != targets[idx][0] {
+			t.Errorf(`sample %d: misclassified as %v`, idx, output)
+		}
+	}
+}
@@ -0,0 +1,615 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+
+	"github.com/farhaven/nn-go/activation"
+	"github.com/farhaven/nn-go/initializer"
+)
+
+// Dense is a fully connected layer. Each of its outputs is computed by applying the activation to the weighted sum
+// of all inputs, plus an optional bias. It is configured with a LayerConf.
+type Dense struct {
+	weights    *mat.Dense
+	bias       *mat.VecDense // nil if the layer is unbiased
+	input      *mat.VecDense // Inputs of the last forward pass
+	delta      *mat.VecDense
+	sum        *mat.VecDense // Weighted inputs before the activation
+	output     *mat.VecDense
+	scratch    *mat.Dense // Step for the weights computed by computeStep
+	biasStep   []float64  // Step for the biases computed by computeStep
+	activation activation.Activation
+
+	// Step for the parameters of a trainable activation, computed by Backward and BackwardBatch
+	activationStep []float64
+
+	// Regularization of the weights, see LayerConf
+	l1          float64
+	l2          float64
+	weightDecay float64
+
+	dropout float64       // Probability of dropping each output in training mode
+	mask    *mat.VecDense // Dropout mask of the last forward pass, nil if the layer has no dropout
+	dropped *mat.VecDense // Outputs of the last forward pass with the mask applied
+	masked  bool          // Set if the mask was applied during the last forward pass
+
+	batch denseBatch // State of the last call of ForwardBatch
+
+	predictScratch sync.Pool // Weighted inputs for Predict
+}
+
+// denseBatch holds the state of a Dense layer for a batch of samples, one per row.
+type denseBatch struct {
+	inputs  *mat.Dense
+	sums    *mat.Dense // Weighted inputs before the activation
+	outputs *mat.Dense // Outputs before dropout
+	mask    *mat.Dense // Dropout mask, nil if dropout wasn't applied
+}
+
+// NewDense creates a fully connected layer with the given number of inputs, configured by conf. The weights are
+// initialized with random values drawn from rng.
+func NewDense(inputs int, conf LayerConf, rng *rand.Rand) (*Dense, error) {
+	if conf.Activation == nil {
+		return nil, errors.New("no activation")
+	}
+
+	if conf.L1 < 0 || conf.L2 < 0 || conf.WeightDecay < 0 {
+		return nil, errors.New("negative regularization")
+	}
+
+	if conf.Dropout < 0 || conf.Dropout >= 1 {
+		return nil, fmt.Errorf("dropout %f not in [0, 1)", conf.Dropout)
+	}
+
+	outputs := conf.Inputs
+
+	init := conf.Initializer
+	if init == nil {
+		init = defaultInitializer(conf.Activation)
+	}
+
+	weights := mat.NewDense(outputs, inputs, nil)
+	init.Initialize(weights, rng)
+
+	// Biases start out at zero
+	var bias *mat.VecDense
+	if conf.Bias {
+		bias = mat.NewVecDense(outputs, nil)
+	}
+
+	// Trainable activations get their own parameters for each layer
+	act := conf.Activation
+	if t, ok := act.(activation.Trainable); ok {
+		act = t.Instantiate(outputs)
+	}
+
+	l := &Dense{
+		weights:    weights,
+		bias:       bias,
+		input:      mat.NewVecDense(inputs, nil),
+		delta:      mat.NewVecDense(outputs, nil),
+		sum:        mat.NewVecDense(outputs, nil),
+		output:     mat.NewVecDense(outputs, nil),
+		scratch:    mat.NewDense(outputs, inputs, nil),
+		activation: act,
+
+		l1:          conf.L1,
+		l2:          conf.L2,
+		weightDecay: conf.WeightDecay,
+
+		dropout: conf.Dropout,
+	}
+	l.activationStep = make([]float64, len(l.activationParameters()))
+
+	if l.dropout > 0 {
+		l.mask = mat.NewVecDense(outputs, nil)
+		l.dropped = mat.NewVecDense(outputs, nil)
+	}
+
+	return l, nil
+}
+
+// defaultInitializer returns the initializer used for layers with the given activation if none is configured.
+func defaultInitializer(a activation.Activation) initializer.Initializer {
+	switch a.(type) {
+	case activation.LeakyReLU, activation.ELU, activation.GELU, activation.Swish, activation.Mish, activation.PReLU,
+		activation.ParametricELU:
+		return initializer.He{}
+	case activation.SELU:
+		return initializer.LeCun{}
+	default:
+		return initializer.XavierUniform{}
+	}
+}
+
+// Dims returns the number of inputs and outputs of l.
+func (l *Dense) Dims() (int, int) {
+	outputs, inputs := l.weights.Dims()
+	return inputs, outputs
+}
+
+// activationParameters returns the learned parameters of the activation of l, or nil if it has none. The
+// returned slice is owned by the activation.
+func (l *Dense) activationParameters() []float64 {
+	if t, ok := l.activation.(activation.Trainable); ok {
+		return t.Parameters()
+	}
+	return nil
+}
+
+// Clone returns a deep copy of l.
+func (l *Dense) Clone() Layer {
+	clone := Dense{
+		activation: l.activation,
+		weights:    mat.DenseCopyOf(l.weights),
+		input:      mat.VecDenseCopyOf(l.input),
+		delta:      mat.VecDenseCopyOf(l.delta),
+		sum:        mat.VecDenseCopyOf(l.sum),
+		output:     mat.VecDenseCopyOf(l.output),
+		scratch:    mat.DenseCopyOf(l.scratch),
+		biasStep:   append([]float64{}, l.biasStep...),
+
+		l1:          l.l1,
+		l2:          l.l2,
+		weightDecay: l.weightDecay,
+
+		dropout: l.dropout,
+		masked:  l.masked,
+
+		// ForwardBatch replaces the batch state instead of modifying it, so it can be shared
+		batch: l.batch,
+	}
+
+	if l.mask != nil {
+		clone.mask = mat.VecDenseCopyOf(l.mask)
+		clone.dropped = mat.VecDenseCopyOf(l.dropped)
+	}
+
+	if l.bias != nil {
+		clone.bias = mat.VecDenseCopyOf(l.bias)
+	}
+
+	if t, ok := l.activation.(activation.Trainable); ok {
+		outputs, _ := l.weights.Dims()
+
+		act := t.Instantiate(outputs)
+		copy(act.Parameters(), t.Parameters())
+
+		clone.activation = act
+		clone.activationStep = append([]float64{}, l.activationStep...)
+	}
+
+	return &clone
+}
+
+// WriteTo writes the weights of l to w, followed by the biases if l has any.
+func (l *Dense) WriteTo(w io.Writer) (int64, error) {
+	sz, err := l.weights.MarshalBinaryTo(w)
+	if err != nil || l.bias == nil {
+		return int64(sz), err
+	}
+
+	bsz, err := l.bias.MarshalBinaryTo(w)
+	return int64(sz + bsz), err
+}
+
+// ReadFrom restores the weights of l from r. If r contains biases after the weights, they are restored as
+// well. Snapshots taken from unbiased layers leave l with zero biases, while snapshots of biased layers can only
+// be restored into biased layers.
+func (l *Dense) ReadFrom(r io.Reader) (int64, error) {
+	var weights mat.Dense
+
+	sz, err := weights.UnmarshalBinaryFrom(r)
+	if err != nil {
+		return 0, err
+	}
+
+	r1, c1 := weights.Dims()
+	r2, c2 := l.weights.Dims()
+	if r1 != r2 || c1 != c2 {
+		return int64(sz), fmt.Errorf("%w: weights have dimensions %dx%d, expected %dx%d", ErrArchitectureMismatch, r1, c1, r2, c2)
+	}
+
+	var bias mat.VecDense
+
+	bsz, err := bias.UnmarshalBinaryFrom(r)
+	if bsz == 0 && (errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)) {
+		// Snapshot of an unbiased layer
+		l.weights = &weights
+		if l.bias != nil {
+			l.bias.Zero()
+		}
+
+		return int64(sz), nil
+	}
+	if err != nil {
+		return int64(sz + bsz), fmt.Errorf("restoring biases: %w", err)
+	}
+
+	if l.bias == nil {
+		return int64(sz + bsz), fmt.Errorf("%w: snapshot has biases, but the layer is unbiased", ErrArchitectureMismatch)
+	}
+	if bias.Len() != r2 {
+		return int64(sz + bsz), fmt.Errorf("%w: %d biases, expected %d", ErrArchitectureMismatch, bias.Len(), r2)
+	}
+
+	l.weights = &weights
+	l.bias = &bias
+
+	return int64(sz + bsz), nil
+}
+
+var _ Layer = &Dense{}
+
+// writeActivation writes the parameters of the activation of l to w. It must only be called if the activation
+// has parameters.
+func (l *Dense) writeActivation(w io.Writer) (int, error) {
+	return mat.NewVecDense(len(l.activationParameters()), l.activationParameters()).MarshalBinaryTo(w)
+}
+
+// readActivation restores the parameters of the activation of l from r.
+func (l *Dense) readActivation(r io.Reader) error {
+	params := l.activationParameters()
+	if params == nil {
+		return fmt.Errorf("%w: activation has no parameters", ErrArchitectureMismatch)
+	}
+
+	var v mat.VecDense
+
+	_, err := v.UnmarshalBinaryFrom(r)
+	if err != nil {
+		return err
+	}
+
+	if v.Len() != len(params) {
+		return fmt.Errorf("%w: activation has %d parameters, expected %d", ErrArchitectureMismatch, v.Len(), len(params))
+	}
+
+	copy(params, v.RawVector().Data)
+
+	return nil
+}
+
+// activate applies a to the weighted inputs in sum and writes the result to dst.
+func activate(a activation.Activation, dst, sum []float64) {
+	if v, ok := a.(activation.Vector); ok {
+		v.ForwardVector(dst, sum)
+		return
+	}
+
+	for idx, s := range sum {
+		dst[idx] = a.Forward(s)
+	}
+}
+
+// computeDeltas computes the deltas of a layer with activation a from the error at its outputs and writes them to
+// dst. The weighted inputs and outputs are the ones from the forward pass the error belongs to. Depending on the
+// operand of the activation, its derivative is computed from either of them.
+func computeDeltas(a activation.Activation, dst, sum, output, error []float64) {
+	if v, ok := a.(activation.Vector); ok {
+		v.BackwardVector(dst, sum, output, error)
+		return
+	}
+
+	operand := output
+	if a.Operand() == activation.Input {
+		operand = sum
+	}
+
+	for idx, e := range error {
+		dst[idx] = e * a.Backward(operand[idx])
+	}
+}
+
+// Backward computes the steps for the weights, biases and activation parameters of l from the error at its
+// outputs, and returns the error at its inputs.
+func (l *Dense) Backward(error []float64) []float64 {
+	errVec := mat.NewVecDense(len(error), error)
+	if l.masked {
+		// Dropped outputs don't contribute to the error
+		var masked mat.VecDense
+		masked.MulElemVec(errVec, l.mask)
+		errVec = &masked
+	}
+
+	sum, output := l.sum.RawVector().Data, l.output.RawVector().Data
+
+	computeDeltas(l.activation, l.delta.RawVector().Data, sum, output, errVec.RawVector().Data)
+
+	if t, ok := l.activation.(activation.Trainable); ok {
+		for idx := range l.activationStep {
+			l.activationStep[idx] = 0
+		}
+		t.ParameterError(l.activationStep, sum, output, errVec.RawVector().Data)
+	}
+
+	l.computeStep()
+
+	return l.propagate().RawVector().Data
+}
+
+// backwardDeltas is like Backward, but takes the deltas of l instead of the error at its outputs, see
+// Network.outputDeltas.
+func (l *Dense) backwardDeltas(deltas []float64) []float64 {
+	l.delta.CopyVec(mat.NewVecDense(len(deltas), deltas))
+	l.computeStep()
+
+	return l.propagate().RawVector().Data
+}
+
+// propagate computes the error at the inputs of l from the current deltas.
+func (l *Dense) propagate() *mat.VecDense {
+	var res mat.Dense
+	res.Mul(mat.Matrix(l.delta).T(), l.weights)
+
+	_, c := l.weights.Dims()
+
+	resVec := mat.NewVecDense(c, nil)
+	resVec.CopyVec(res.RowView(0))
+
+	return resVec
+}
+
+// Forward computes the weighted inputs and outputs of l for the given inputs and stores them in l. If training
+// is set, dropout is applied to the outputs with random numbers from rng. It returns the outputs that are passed
+// on to the next layer.
+func (l *Dense) Forward(inputs []float64, training bool, rng *rand.Rand) ([]float64, error) {
+	l.masked = false
+
+	copy(l.input.RawVector().Data, inputs)
+
+	err := l.predict(l.sum, l.output, l.input)
+	if err != nil {
+		return nil, err
+	}
+
+	if !training || l.dropout == 0 {
+		return l.output.RawVector().Data, nil
+	}
+
+	fillDropoutMask(l.mask.RawVector().Data, l.dropout, rng)
+	l.dropped.MulElemVec(l.output, l.mask)
+	l.masked = true
+
+	return l.dropped.RawVector().Data, nil
+}
+
+// fillDropoutMask fills mask for inverted dropout with the given rate: each entry is 0 with probability rate,
+// and 1/(1-rate) otherwise, so that the expected value of the masked outputs is the same as without dropout.
+func fillDropoutMask(mask []float64, rate float64, rng *rand.Rand) {
+	scale := 1 / (1 - rate)
+
+	for idx := range mask {
+		if rng.Float64() < rate {
+			mask[idx] = 0
+		} else {
+			mask[idx] = scale
+		}
+	}
+}
+
+// Predict computes the outputs of l for the given inputs like Forward without dropout, and writes them to dst.
+// Since it doesn't modify l, it is safe for concurrent use.
+func (l *Dense) Predict(dst, inputs []float64) error {
+	sum, ok := l.predictScratch.Get().(*mat.VecDense)
+	if !ok {
+		sum = mat.NewVecDense(len(dst), nil)
+	}
+	defer l.predictScratch.Put(sum)
+
+	return l.predict(sum, mat.NewVecDense(len(dst), dst), mat.NewVecDense(len(inputs), inputs))
+}
+
+// predict computes the weighted inputs and outputs of l for the given inputs and stores them in the given vectors.
+func (l *Dense) predict(sum, output, inputs *mat.VecDense) error {
+	sum.MulVec(l.weights, inputs)
+	if l.bias != nil {
+		sum.AddVec(sum, l.bias)
+	}
+
+	activate(l.activation, output.RawVector().Data, sum.RawVector().Data)
+
+	return checkOutputs(sum.RawVector().Data, output.RawVector().Data, 0)
+}
+
+// computeStep computes the steps for the weights and biases of l from its last inputs and its current deltas.
+func (l *Dense) computeStep() {
+	// Compute: Step = Input^T * Delta
+	l.scratch.Outer(1, l.delta, l.input)
+
+	if l.bias != nil {
+		copy(l.resetBiasStep(), l.delta.RawVector().Data)
+	}
+}
+
+// resetBiasStep makes sure l.biasStep matches the size of the biases and returns it.
+func (l *Dense) resetBiasStep() []float64 {
+	if len(l.biasStep) != l.bias.Len() {
+		l.biasStep = make([]float64, l.bias.Len())
+	}
+	return l.biasStep
+}
+
+// Parameters returns the weights of l in row-major order, followed by the biases and the parameters of the
+// activation if l has any.
+func (l *Dense) Parameters() [][]float64 {
+	res := [][]float64{l.weights.RawMatrix().Data}
+
+	if l.bias != nil {
+		res = append(res, l.bias.RawVector().Data)
+	}
+
+	if params := l.activationParameters(); params != nil {
+		res = append(res, params)
+	}
+
+	return res
+}
+
+// Steps returns the steps for the parameters of l, in the layout returned by Parameters.
+func (l *Dense) Steps() [][]float64 {
+	res := [][]float64{l.scratch.RawMatrix().Data}
+
+	if l.bias != nil {
+		res = append(res, l.resetBiasStep())
+	}
+
+	if l.activationParameters() != nil {
+		res = append(res, l.activationStep)
+	}
+
+	return res
+}
+
+// regularize adds the gradients of the L1 and L2 penalties to the weight step and applies weight decay.
+func (l *Dense) regularize(learningRate float64) {
+	weights := l.weights.RawMatrix().Data
+	step := l.scratch.RawMatrix().Data
+
+	if l.l1 != 0 || l.l2 != 0 {
+		for idx, w := range weights {
+			step[idx] -= l.l1*sign(w) + 2*l.l2*w
+		}
+	}
+
+	if l.weightDecay != 0 {
+		floats.Scale(1-learningRate*l.weightDecay, weights)
+	}
+}
+
+// penalty returns the L1 and L2 penalty of the weights of l.
+func (l *Dense) penalty() float64 {
+	if l.l1 == 0 && l.l2 == 0 {
+		return 0
+	}
+
+	weights := l.weights.RawMatrix().Data
+
+	return l.l1*floats.Norm(weights, 1) + l.l2*floats.Dot(weights, weights)
+}
+
+var _ regularizer = &Dense{}
+
+func sign(v float64) float64 {
+	switch {
+	case v > 0:
+		return 1
+	case v < 0:
+		return -1
+	default:
+		return 0
+	}
+}
+
+// ForwardBatch computes the weighted inputs and outputs of l for a batch of inputs with one sample per row, and
+// applies dropout like Forward if training is set. The state used by Backward is left untouched.
+func (l *Dense) ForwardBatch(inputs *mat.Dense, training bool, rng *rand.Rand) (*mat.Dense, error) {
+	samples, _ := inputs.Dims()
+	outputs, _ := l.weights.Dims()
+
+	sums := mat.NewDense(samples, outputs, nil)
+	sums.Mul(inputs, l.weights.T())
+
+	res := mat.NewDense(samples, outputs, nil)
+
+	for i := 0; i < samples; i++ {
+		sum := sums.RawRowView(i)
+		if l.bias != nil {
+			floats.Add(sum, l.bias.RawVector().Data)
+		}
+
+		output := res.RawRowView(i)
+		activate(l.activation, output, sum)
+
+		err := checkOutputs(sum, output, i)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	l.batch = denseBatch{
+		inputs:  inputs,
+		sums:    sums,
+		outputs: res,
+	}
+
+	if !training || l.dropout == 0 {
+		return res, nil
+	}
+
+	mask := mat.NewDense(samples, outputs, nil)
+
+	// Draw the mask row by row, so that the random numbers are used in the same order as by Forward
+	for i := 0; i < samples; i++ {
+		fillDropoutMask(mask.RawRowView(i), l.dropout, rng)
+	}
+
+	l.batch.mask = mask
+
+	var dropped mat.Dense
+	dropped.MulElem(res, mask)
+
+	return &dropped, nil
+}
+
+// BackwardBatch computes the averaged steps for the weights, biases and activation parameters of l from the
+// errors at its outputs for the batch passed to the last call of ForwardBatch. It returns the errors at its
+// inputs.
+func (l *Dense) BackwardBatch(error *mat.Dense) *mat.Dense {
+	if l.batch.mask != nil {
+		var masked mat.Dense
+		masked.MulElem(error, l.batch.mask)
+		error = &masked
+	}
+
+	samples, numOutputs := error.Dims()
+	sums, outputs := l.batch.sums, l.batch.outputs
+
+	t, trainable := l.activation.(activation.Trainable)
+	for idx := range l.activationStep {
+		l.activationStep[idx] = 0
+	}
+
+	delta := mat.NewDense(samples, numOutputs, nil)
+	for i := 0; i < samples; i++ {
+		computeDeltas(l.activation, delta.RawRowView(i), sums.RawRowView(i), outputs.RawRowView(i), error.RawRowView(i))
+
+		if trainable {
+			t.ParameterError(l.activationStep, sums.RawRowView(i), outputs.RawRowView(i), error.RawRowView(i))
+		}
+	}
+
+	floats.Scale(1/float64(samples), l.activationStep)
+
+	return l.backwardBatchDeltas(delta)
+}
+
+// backwardBatchDeltas is like BackwardBatch, but takes the deltas of l instead of the errors at its outputs,
+// see Network.outputDeltas.
+func (l *Dense) backwardBatchDeltas(delta *mat.Dense) *mat.Dense {
+	samples, _ := delta.Dims()
+	scale := 1 / float64(samples)
+
+	// Compute: Step = Delta^T * Inputs / samples
+	l.scratch.Mul(delta.T(), l.batch.inputs)
+	l.scratch.Scale(scale, l.scratch)
+
+	if l.bias != nil {
+		biasStep := l.resetBiasStep()
+		for idx := range biasStep {
+			biasStep[idx] = scale * mat.Sum(delta.ColView(idx))
+		}
+	}
+
+	var res mat.Dense
+	res.Mul(delta, l.weights)
+
+	return &res
+}
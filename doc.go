@@ -1,8 +1,11 @@
 /*
-Package network is a simple implementation of a nonbiased neural network.
+Package network is a simple implementation of feed-forward and recurrent neural networks.
 
 The networks created by this package can be trained with backpropagation and use a variety of activation
-functions.
+functions. Layers can optionally have bias terms, see LayerConf. Besides fully connected layers, there are
+convolutional and pooling layers for images and other spatial data, and RNN, LSTM and GRU layers that keep a
+state between the steps of a sequence. Recurrent networks are trained with backpropagation through time, see
+TrainSequence.
 
 For example, the following code trains a simple 2x3x1 neural network the XOR function:
 
@@ -0,0 +1,66 @@
+package network
+
+import (
+	"math/rand"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Flatten marks the transition from spatial layers like Conv2D to Dense layers. Since all layers exchange flat
+// vectors laid out as described by Shape, it passes its inputs on unchanged. It has no parameters.
+type Flatten struct {
+	noParameters
+
+	size int
+	out  []float64
+}
+
+// NewFlatten creates a layer that flattens inputs of the given shape.
+func NewFlatten(input Shape) *Flatten {
+	return &Flatten{
+		size: input.Size(),
+		out:  make([]float64, input.Size()),
+	}
+}
+
+// Dims returns the number of inputs and outputs of l, which are the same.
+func (l *Flatten) Dims() (int, int) {
+	return l.size, l.size
+}
+
+// Forward returns a copy of inputs.
+func (l *Flatten) Forward(inputs []float64, training bool, rng *rand.Rand) ([]float64, error) {
+	copy(l.out, inputs)
+	return l.out, nil
+}
+
+// Predict copies inputs to dst.
+func (l *Flatten) Predict(dst, inputs []float64) error {
+	copy(dst, inputs)
+	return nil
+}
+
+// Backward returns a copy of error.
+func (l *Flatten) Backward(error []float64) []float64 {
+	return append([]float64{}, error...)
+}
+
+// ForwardBatch returns a copy of inputs.
+func (l *Flatten) ForwardBatch(inputs *mat.Dense, training bool, rng *rand.Rand) (*mat.Dense, error) {
+	return mat.DenseCopyOf(inputs), nil
+}
+
+// BackwardBatch returns a copy of error.
+func (l *Flatten) BackwardBatch(error *mat.Dense) *mat.Dense {
+	return mat.DenseCopyOf(error)
+}
+
+// Clone returns a copy of l.
+func (l *Flatten) Clone() Layer {
+	return &Flatten{
+		size: l.size,
+		out:  make([]float64, l.size),
+	}
+}
+
+var _ Layer = &Flatten{}
@@ -0,0 +1,127 @@
+// Package gradcheck verifies the gradients computed by networks by comparing them to numerical estimates.
+//
+// For every parameter p of a network, the derivative of the loss is estimated with the central difference
+// (loss(p+epsilon) - loss(p-epsilon)) / (2*epsilon) and compared to the gradient computed by back propagation.
+// Mismatches point to bugs in the derivatives of activations or losses.
+package gradcheck
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	network "github.com/farhaven/nn-go"
+	"github.com/farhaven/nn-go/loss"
+)
+
+// minScale keeps the relative error of parameters whose gradients are both practically zero from exploding.
+const minScale = 1e-8
+
+// Result describes the outcome of a gradient check for one layer.
+type Result struct {
+	Layer int
+
+	// Largest relative error over all parameters of the layer, along with the index of the parameter it was
+	// found at in the layout of Network.Parameters, and both gradients of that parameter.
+	MaxRelativeError float64
+	Parameter        int
+	Analytic         float64
+	Numeric          float64
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("layer %d: max relative error %g at parameter %d (analytic %g, numeric %g)", r.Layer, r.MaxRelativeError, r.Parameter, r.Analytic, r.Numeric)
+}
+
+// RelativeError returns |a - b| / max(|a| + |b|, 1e-8).
+func RelativeError(a, b float64) float64 {
+	return math.Abs(a-b) / math.Max(math.Abs(a)+math.Abs(b), minScale)
+}
+
+// Check compares the gradient of the loss l computed by n for a single sample to central differences with the
+// given step size epsilon, and returns one result per layer. The parameters of n and the state of its recurrent
+// layers are restored before Check returns. Dropout and regularization are not taken into account.
+//
+// Since it computes two forward passes per parameter, Check is only suitable for small networks.
+func Check(n *network.Network, l loss.Loss, inputs, targets []float64, epsilon float64) ([]Result, error) {
+	if epsilon <= 0 {
+		return nil, errors.New("epsilon has to be positive")
+	}
+
+	// Gradient advances the state of recurrent layers, so the numerical gradients have to start from the state
+	// before it
+	state := n.State()
+	defer n.SetState(state)
+
+	analytic, err := n.Gradient(inputs, targets, l)
+	if err != nil {
+		return nil, err
+	}
+
+	params := n.Parameters()
+	defer n.SetParameters(params)
+
+	lossAt := func() (float64, error) {
+		err := n.SetParameters(params)
+		if err != nil {
+			return 0, err
+		}
+
+		err = n.SetState(state)
+		if err != nil {
+			return 0, err
+		}
+
+		output, err := n.Predict(inputs)
+		if err != nil {
+			return 0, err
+		}
+
+		return l.Value(output, targets), nil
+	}
+
+	var res []Result
+
+	for layer := range params {
+		r := Result{Layer: layer}
+
+		for idx, p := range params[layer] {
+			params[layer][idx] = p + epsilon
+			plus, err := lossAt()
+			if err != nil {
+				return nil, fmt.Errorf("layer %d, parameter %d: %w", layer, idx, err)
+			}
+
+			params[layer][idx] = p - epsilon
+			minus, err := lossAt()
+			if err != nil {
+				return nil, fmt.Errorf("layer %d, parameter %d: %w", layer, idx, err)
+			}
+
+			params[layer][idx] = p
+
+			numeric := (plus - minus) / (2 * epsilon)
+
+			e := RelativeError(analytic[layer][idx], numeric)
+			if e > r.MaxRelativeError || idx == 0 {
+				r.MaxRelativeError = e
+				r.Parameter = idx
+				r.Analytic = analytic[layer][idx]
+				r.Numeric = numeric
+			}
+		}
+
+		res = append(res, r)
+	}
+
+	return res, nil
+}
+
+// MaxRelativeError returns the largest relative error over all results.
+func MaxRelativeError(results []Result) float64 {
+	res := float64(0)
+	for _, r := range results {
+		res = math.Max(res, r.MaxRelativeError)
+	}
+	return res
+}
@@ -0,0 +1,244 @@
+package gradcheck
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	network "github.com/farhaven/nn-go"
+	"github.com/farhaven/nn-go/activation"
+	"github.com/farhaven/nn-go/loss"
+)
+
+const (
+	epsilon   = 1e-6
+	tolerance = 1e-5
+)
+
+// configured holds parameters for built-in activations whose zero value isn't a useful test case.
+var configured = map[string]activation.Activation{
+	"ELU":       activation.ELU{A: 1},
+	"LeakyReLU": activation.LeakyReLU{Leak: 0.1},
+}
+
+// variants holds additional configurations of built-in activations that take different code paths.
+var variants = map[string]activation.Activation{
+	"ELU/zero":              activation.ELU{},
+	"LeakyReLU/zero":        activation.LeakyReLU{},
+	"LeakyReLU/capped":      activation.LeakyReLU{Leak: 0.5, Cap: 0.2},
+	"PReLU/perUnit":         activation.PReLU{PerUnit: true},
+	"ParametricELU/perUnit": activation.ParametricELU{PerUnit: true, Init: 0.5},
+}
+
+func sample(rng *rand.Rand, size int) []float64 {
+	res := make([]float64, size)
+	for idx := range res {
+		res[idx] = rng.Float64()*2 - 1
+	}
+	return res
+}
+
+func check(t *testing.T, net *network.Network, l loss.Loss, inputs, targets []float64) {
+	t.Helper()
+
+	results, err := Check(net, l, inputs, targets, epsilon)
+	if err != nil {
+		t.Fatal(`gradient check failed:`, err)
+	}
+
+	for _, r := range results {
+		if r.MaxRelativeError > tolerance {
+			t.Error(r)
+		}
+	}
+}
+
+func TestCheckActivations(t *testing.T) {
+	activations := map[string]activation.Activation{}
+	for name, act := range variants {
+		activations[name] = act
+	}
+
+	for _, name := range activation.Names() {
+		act, ok := configured[name]
+		if !ok {
+			var err error
+			act, err = activation.New(name)
+			if err != nil {
+				t.Fatal(`can't create activation:`, err)
+			}
+		}
+
+		activations[name] = act
+	}
+
+	for name, act := range activations {
+		t.Run(name, func(t *testing.T) {
+			net, err := network.New([]network.LayerConf{
+				{Inputs: 3},
+				{Inputs: 4, Activation: act, Bias: true},
+				{Inputs: 2, Activation: act, Bias: true},
+			}, network.WithSeed(1))
+			if err != nil {
+				t.Fatal(`can't create network:`, err)
+			}
+
+			rng := rand.New(rand.NewSource(1))
+			for i := 0; i < 5; i++ {
+				check(t, net, loss.MSE{}, sample(rng, 3), sample(rng, 2))
+			}
+		})
+	}
+}
+
+func TestCheckRecurrent(t *testing.T) {
+	for name, newLayer := range map[string]func(rng *rand.Rand) (network.Layer, error){
+		"RNN": func(rng *rand.Rand) (network.Layer, error) {
+			return network.NewRNN(2, network.RecurrentConf{Units: 3, Bias: true}, rng)
+		},
+		"LSTM": func(rng *rand.Rand) (network.Layer, error) {
+			return network.NewLSTM(2, network.RecurrentConf{Units: 3, Bias: true}, rng)
+		},
+		"GRU": func(rng *rand.Rand) (network.Layer, error) {
+			return network.NewGRU(2, network.RecurrentConf{Units: 3, Bias: true}, rng)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+
+			recurrent, err := newLayer(rng)
+			if err != nil {
+				t.Fatal(`can't create layer:`, err)
+			}
+
+			output, err := network.NewDense(3, network.LayerConf{Inputs: 1, Activation: activation.Tanh{}, Bias: true}, rng)
+			if err != nil {
+				t.Fatal(`can't create layer:`, err)
+			}
+
+			net, err := network.NewFromLayers([]network.Layer{recurrent, output}, network.WithSeed(1))
+			if err != nil {
+				t.Fatal(`can't create network:`, err)
+			}
+
+			// Move away from the zero state, so that the recurrent weights matter
+			_, err = net.ForwardSequence([][]float64{sample(rng, 2), sample(rng, 2)})
+			if err != nil {
+				t.Fatal(`forward pass failed:`, err)
+			}
+
+			for i := 0; i < 5; i++ {
+				state := net.State()
+
+				check(t, net, loss.MSE{}, sample(rng, 2), sample(rng, 1))
+
+				if !reflect.DeepEqual(net.State(), state) {
+					t.Fatal(`state wasn't restored`)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckLosses(t *testing.T) {
+	for name, tc := range map[string]struct {
+		output activation.Activation
+		loss   loss.Loss
+	}{
+		"squared error":             {output: activation.Tanh{}, loss: loss.SquaredError{}},
+		"mae":                       {output: activation.Tanh{}, loss: loss.MAE{}},
+		"huber":                     {output: activation.Tanh{}, loss: loss.Huber{Delta: 0.1}},
+		"binary cross-entropy":      {output: activation.Softmax{}, loss: loss.BinaryCrossEntropy{}},
+		"categorical cross-entropy": {output: activation.Softmax{}, loss: loss.CategoricalCrossEntropy{}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			net, err := network.New([]network.LayerConf{
+				{Inputs: 3},
+				{Inputs: 4, Activation: activation.Tanh{}, Bias: true},
+				{Inputs: 3, Activation: tc.output, Bias: true},
+			}, network.WithSeed(1))
+			if err != nil {
+				t.Fatal(`can't create network:`, err)
+			}
+
+			rng := rand.New(rand.NewSource(1))
+			for i := 0; i < 5; i++ {
+				check(t, net, tc.loss, sample(rng, 3), []float64{0, 1, 0})
+			}
+		})
+	}
+}
+
+// wrongTanh is a tanh activation with a broken derivative.
+type wrongTanh struct {
+	activation.Tanh
+}
+
+func (w wrongTanh) Backward(x float64) float64 {
+	return 2 * w.Tanh.Backward(x)
+}
+
+func TestCheckDetectsWrongGradient(t *testing.T) {
+	net, err := network.New([]network.LayerConf{
+		{Inputs: 3},
+		{Inputs: 4, Activation: wrongTanh{}},
+		{Inputs: 2, Activation: activation.Tanh{}},
+	}, network.WithSeed(1))
+	if err != nil {
+		t.Fatal(`can't create network:`, err)
+	}
+
+	params := net.Parameters()
+
+	results, err := Check(net, loss.MSE{}, []float64{0.5, -0.5, 1}, []float64{1, -1}, epsilon)
+	if err != nil {
+		t.Fatal(`gradient check failed:`, err)
+	}
+
+	if len(results) != 2 {
+		t.Fatal(`expected 2 results, got`, len(results))
+	}
+
+	// The error of the first layer is off by a factor of 2, the second one is fine.
+	if math.Abs(results[0].MaxRelativeError-1.0/3) > tolerance {
+		t.Error(`wrong gradient not detected:`, results[0])
+	}
+	if results[1].MaxRelativeError > tolerance {
+		t.Error(`correct gradient reported as wrong:`, results[1])
+	}
+	if MaxRelativeError(results) != results[0].MaxRelativeError {
+		t.Error(`unexpected max relative error`, MaxRelativeError(results))
+	}
+
+	for layer, p := range net.Parameters() {
+		for idx, v := range p {
+			if v != params[layer][idx] {
+				t.Fatalf(`layer %d: parameter %d wasn't restored`, layer, idx)
+			}
+		}
+	}
+
+	_, err = Check(net, loss.MSE{}, []float64{0.5, -0.5, 1}, []float64{1, -1}, 0)
+	if err == nil {
+		t.Error(`expected error for epsilon 0`)
+	}
+}
+
+func TestRelativeError(t *testing.T) {
+	for _, tc := range []struct {
+		a, b     float64
+		expected float64
+	}{
+		{a: 1, b: 1, expected: 0},
+		{a: 1, b: -1, expected: 1},
+		{a: 2, b: 1, expected: 1.0 / 3},
+		{a: 0, b: 0, expected: 0},
+		{a: 1e-10, b: 0, expected: 1e-2},
+	} {
+		actual := RelativeError(tc.a, tc.b)
+		if math.Abs(actual-tc.expected) > 1e-12 {
+			t.Errorf(`RelativeError(%g, %g): expected %g, got %g`, tc.a, tc.b, tc.expected, actual)
+		}
+	}
+}
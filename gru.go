@@ -0,0 +1,97 @@
+package network
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+
+	"github.com/farhaven/nn-go/activation"
+)
+
+// GRU is a gated recurrent unit layer as proposed by Cho et al. It is a simpler alternative to LSTM that keeps no
+// separate cell state.
+//
+// At each step, the update gate z and the reset gate r are computed by applying Sigmoid to the weighted inputs and
+// previous outputs of each gate. The candidate is n = tanh(a + r*u) for the weighted inputs a and weighted
+// previous outputs u of the candidate, and the outputs are h = (1-z)*n + z*h' for the previous outputs h'.
+// The reset gate is applied after weighting the previous outputs, like in the implementation of cuDNN. The state
+// of the layer consists of its outputs. It is configured with a RecurrentConf.
+type GRU struct {
+	recurrent
+}
+
+// NewGRU creates a GRU layer with the given number of inputs, configured by conf. The weights are initialized with
+// random values drawn from rng.
+func NewGRU(inputs int, conf RecurrentConf, rng *rand.Rand) (*GRU, error) {
+	if conf.Activation != nil {
+		return nil, errors.New("GRU layers have fixed activations")
+	}
+
+	l := &GRU{}
+
+	err := l.init(gruCell{}, inputs, conf, activation.Tanh{}, rng)
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Clone returns a deep copy of l, including its state.
+func (l *GRU) Clone() Layer {
+	clone := &GRU{}
+	l.clone(&clone.recurrent)
+
+	return clone
+}
+
+var _ Recurrent = &GRU{}
+
+// gruCell computes the steps of a GRU. Its gates are the update gate, the reset gate and the candidate, and its
+// cache holds their values.
+type gruCell struct{}
+
+func (gruCell) gates() int {
+	return 3
+}
+
+func (gruCell) stateSize(units int) int {
+	return units
+}
+
+func (gruCell) cacheSize(units int) int {
+	return 3 * units
+}
+
+func (gruCell) forward(t *timeStep, units int) {
+	sigmoid := activation.Sigmoid{}
+
+	for j := 0; j < units; j++ {
+		z := sigmoid.Forward(t.a[j] + t.u[j])
+		r := sigmoid.Forward(t.a[units+j] + t.u[units+j])
+		n := math.Tanh(t.a[2*units+j] + r*t.u[2*units+j])
+
+		t.cache[j], t.cache[units+j], t.cache[2*units+j] = z, r, n
+
+		t.state[j] = (1-z)*n + z*t.prev[j]
+	}
+}
+
+func (gruCell) backward(t *timeStep, units int, error, inputDelta, outputDelta, prev []float64) {
+	for j := 0; j < units; j++ {
+		z, r, n := t.cache[j], t.cache[units+j], t.cache[2*units+j]
+
+		dh := error[j]
+		dn := dh * (1 - z) * (1 - n*n)
+
+		inputDelta[j] = dh * (t.prev[j] - n) * z * (1 - z)
+		inputDelta[units+j] = dn * t.u[2*units+j] * r * (1 - r)
+		inputDelta[2*units+j] = dn
+
+		outputDelta[j] = inputDelta[j]
+		outputDelta[units+j] = inputDelta[units+j]
+		outputDelta[2*units+j] = dn * r
+
+		prev[j] += dh * z
+	}
+}
@@ -0,0 +1,157 @@
+// Package initializer contains strategies for choosing the initial weights of a layer.
+package initializer
+
+import (
+	"math"
+	"math/rand"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Initializer fills a weight matrix with initial values drawn from rng.
+//
+// The matrix has one row per neuron and one column per input of the layer, so its dimensions are the fan-out
+// and fan-in of the layer.
+type Initializer interface {
+	Initialize(weights *mat.Dense, rng *rand.Rand)
+}
+
+// Func is an Initializer implemented by a plain function.
+type Func func(weights *mat.Dense, rng *rand.Rand)
+
+func (f Func) Initialize(weights *mat.Dense, rng *rand.Rand) {
+	f(weights, rng)
+}
+
+// Constant sets all weights to Value. Constant{} initializes all weights to zero.
+type Constant struct {
+	Value float64
+}
+
+func (c Constant) Initialize(weights *mat.Dense, rng *rand.Rand) {
+	weights.Apply(func(i, j int, v float64) float64 {
+		return c.Value
+	}, weights)
+}
+
+// Normal draws weights from a normal distribution with mean 0 and standard deviation StdDev.
+type Normal struct {
+	StdDev float64
+}
+
+func (n Normal) Initialize(weights *mat.Dense, rng *rand.Rand) {
+	normal(weights, rng, n.StdDev)
+}
+
+// Uniform draws weights uniformly from [-Limit, Limit).
+type Uniform struct {
+	Limit float64
+}
+
+func (u Uniform) Initialize(weights *mat.Dense, rng *rand.Rand) {
+	uniform(weights, rng, u.Limit)
+}
+
+// XavierUniform draws weights uniformly from [-l, l) with l = sqrt(6 / (fanIn + fanOut)), as proposed by
+// Glorot and Bengio. It is well suited for Tanh and Sigmoid activations.
+type XavierUniform struct{}
+
+func (XavierUniform) Initialize(weights *mat.Dense, rng *rand.Rand) {
+	fanOut, fanIn := weights.Dims()
+	uniform(weights, rng, math.Sqrt(6/float64(fanIn+fanOut)))
+}
+
+// XavierNormal draws weights from a normal distribution with standard deviation sqrt(2 / (fanIn + fanOut)).
+type XavierNormal struct{}
+
+func (XavierNormal) Initialize(weights *mat.Dense, rng *rand.Rand) {
+	fanOut, fanIn := weights.Dims()
+	normal(weights, rng, math.Sqrt(2/float64(fanIn+fanOut)))
+}
+
+// He draws weights from a normal distribution with standard deviation sqrt(2 / fanIn), as proposed by He et
+// al. It is well suited for rectifying activations like LeakyReLU and ELU.
+type He struct{}
+
+func (He) Initialize(weights *mat.Dense, rng *rand.Rand) {
+	_, fanIn := weights.Dims()
+	normal(weights, rng, math.Sqrt(2/float64(fanIn)))
+}
+
+// HeUniform draws weights uniformly from [-l, l) with l = sqrt(6 / fanIn).
+type HeUniform struct{}
+
+func (HeUniform) Initialize(weights *mat.Dense, rng *rand.Rand) {
+	_, fanIn := weights.Dims()
+	uniform(weights, rng, math.Sqrt(6/float64(fanIn)))
+}
+
+// LeCun draws weights from a normal distribution with standard deviation sqrt(1 / fanIn).
+type LeCun struct{}
+
+func (LeCun) Initialize(weights *mat.Dense, rng *rand.Rand) {
+	_, fanIn := weights.Dims()
+	normal(weights, rng, math.Sqrt(1/float64(fanIn)))
+}
+
+// Orthogonal initializes the weights to a random orthogonal matrix scaled by Gain. If the matrix isn't
+// square, its rows or columns, whichever are fewer, are orthonormal. A Gain of 0 is treated as 1.
+type Orthogonal struct {
+	Gain float64
+}
+
+func (o Orthogonal) Initialize(weights *mat.Dense, rng *rand.Rand) {
+	gain := o.Gain
+	if gain == 0 {
+		gain = 1
+	}
+
+	rows, cols := weights.Dims()
+
+	long, short := rows, cols
+	if rows < cols {
+		long, short = cols, rows
+	}
+
+	a := mat.NewDense(long, short, nil)
+	normal(a, rng, 1)
+
+	var qr mat.QR
+	qr.Factorize(a)
+
+	var q, r mat.Dense
+	qr.QTo(&q)
+	qr.RTo(&r)
+
+	// Make the decomposition unique by flipping columns of Q so that the diagonal of R is positive. Otherwise
+	// the result wouldn't be uniformly distributed.
+	res := mat.NewDense(long, short, nil)
+	for j := 0; j < short; j++ {
+		sign := gain
+		if r.At(j, j) < 0 {
+			sign = -gain
+		}
+
+		for i := 0; i < long; i++ {
+			res.Set(i, j, sign*q.At(i, j))
+		}
+	}
+
+	if rows < cols {
+		weights.Copy(res.T())
+	} else {
+		weights.Copy(res)
+	}
+}
+
+func normal(weights *mat.Dense, rng *rand.Rand, stdDev float64) {
+	weights.Apply(func(i, j int, v float64) float64 {
+		return rng.NormFloat64() * stdDev
+	}, weights)
+}
+
+func uniform(weights *mat.Dense, rng *rand.Rand, limit float64) {
+	weights.Apply(func(i, j int, v float64) float64 {
+		return (2*rng.Float64() - 1) * limit
+	}, weights)
+}
@@ -0,0 +1,103 @@
+package initializer
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/stat"
+)
+
+func TestDistributions(t *testing.T) {
+	const fanOut, fanIn = 200, 300
+
+	tests := map[string]struct {
+		init   Initializer
+		stdDev float64
+		limit  float64 // 0 for unbounded distributions
+	}{
+		"normal":         {Normal{StdDev: 0.5}, 0.5, 0},
+		"uniform":        {Uniform{Limit: 0.5}, 0.5 / math.Sqrt(3), 0.5},
+		"xavier uniform": {XavierUniform{}, math.Sqrt(2.0 / (fanIn + fanOut)), math.Sqrt(6.0 / (fanIn + fanOut))},
+		"xavier normal":  {XavierNormal{}, math.Sqrt(2.0 / (fanIn + fanOut)), 0},
+		"he":             {He{}, math.Sqrt(2.0 / fanIn), 0},
+		"he uniform":     {HeUniform{}, math.Sqrt(2.0 / fanIn), math.Sqrt(6.0 / fanIn)},
+		"lecun":          {LeCun{}, math.Sqrt(1.0 / fanIn), 0},
+	}
+
+	for name, tc := range tests {
+		weights := mat.NewDense(fanOut, fanIn, nil)
+		tc.init.Initialize(weights, rand.New(rand.NewSource(1)))
+
+		data := weights.RawMatrix().Data
+
+		mean, stdDev := stat.MeanStdDev(data, nil)
+		if math.Abs(mean) > 0.05*tc.stdDev {
+			t.Errorf(`%s: mean %f too far from 0`, name, mean)
+		}
+		if math.Abs(stdDev-tc.stdDev) > 0.02*tc.stdDev {
+			t.Errorf(`%s: expected standard deviation %f, got %f`, name, tc.stdDev, stdDev)
+		}
+
+		if tc.limit == 0 {
+			continue
+		}
+
+		for _, v := range data {
+			if math.Abs(v) > tc.limit {
+				t.Errorf(`%s: weight %f exceeds limit %f`, name, v, tc.limit)
+				break
+			}
+		}
+	}
+}
+
+func TestConstant(t *testing.T) {
+	weights := mat.NewDense(2, 3, nil)
+	Constant{Value: 0.25}.Initialize(weights, rand.New(rand.NewSource(1)))
+
+	for _, v := range weights.RawMatrix().Data {
+		if v != 0.25 {
+			t.Fatal(`unexpected weights:`, mat.Formatted(weights))
+		}
+	}
+}
+
+func TestFunc(t *testing.T) {
+	weights := mat.NewDense(2, 3, nil)
+
+	init := Func(func(weights *mat.Dense, rng *rand.Rand) {
+		weights.Set(1, 2, 42)
+	})
+	init.Initialize(weights, rand.New(rand.NewSource(1)))
+
+	if weights.At(1, 2) != 42 {
+		t.Error(`function wasn't called:`, mat.Formatted(weights))
+	}
+}
+
+func TestOrthogonal(t *testing.T) {
+	for _, dims := range [][2]int{{4, 4}, {3, 5}, {5, 3}} {
+		weights := mat.NewDense(dims[0], dims[1], nil)
+		Orthogonal{Gain: 2}.Initialize(weights, rand.New(rand.NewSource(1)))
+
+		// The shorter side has to be orthogonal, with each vector having a norm equal to the gain
+		var product mat.Dense
+		if dims[0] <= dims[1] {
+			product.Mul(weights, weights.T())
+		} else {
+			product.Mul(weights.T(), weights)
+		}
+
+		n, _ := product.Dims()
+		expected := mat.NewDiagDense(n, nil)
+		for i := 0; i < n; i++ {
+			expected.SetDiag(i, 4)
+		}
+
+		if !mat.EqualApprox(&product, expected, 1e-12) {
+			t.Errorf(`%v: weights aren't orthogonal: %v`, dims, mat.Formatted(&product))
+		}
+	}
+}
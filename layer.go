@@ -0,0 +1,90 @@
+package network
+
+import (
+	"io"
+	"math/rand"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Layer is a single layer of a network. It transforms a vector of inputs into a vector of outputs, and propagates
+// errors at its outputs back to its inputs while computing the steps for its parameters.
+//
+// Dense is the layer created by New. Conv1D, Conv2D, MaxPool2D, AvgPool2D and Flatten process sequences, images
+// and other spatial data, see Shape. RNN, LSTM and GRU keep a state between the steps of a sequence, see Recurrent.
+// Networks made of other layers, including user-defined ones, are created with NewFromLayers.
+//
+// Forward computes the outputs of the layer for the given inputs and keeps whatever it needs for a following call
+// of Backward. If training is set, features that are only used during training like dropout are enabled, drawing
+// random numbers from rng. The returned slice may be owned by the layer and is only valid until the next call of
+// Forward. A layer should report NaN or infinite outputs with a *NonFiniteError, the network fills in the index of
+// the layer.
+//
+// Predict computes the outputs of the layer like Forward in inference mode and writes them to dst. Unlike Forward,
+// it must not modify the layer, so that it is safe for concurrent use.
+//
+// Backward computes the steps for the parameters of the layer from the error at the outputs of the last call of
+// Forward, and returns the error at its inputs. Errors and steps point in the direction of the negative gradient.
+//
+// ForwardBatch and BackwardBatch are like Forward and Backward for a batch of samples with one sample per row. The
+// steps computed by BackwardBatch are averaged over the batch. The state kept by ForwardBatch for BackwardBatch has
+// to be separate from the one kept by Forward, so that Backprop and TrainBatch can be mixed freely.
+//
+// Parameters returns the trainable parameters of the layer, and Steps the steps computed for them by the last call
+// of Backward or BackwardBatch, in the same layout. Both return the slices the layer works on, so that optimizers
+// can update the parameters in place. The number and lengths of the slices must only change when the layer is
+// restored with ReadFrom.
+//
+// WriteTo and ReadFrom save and restore the parameters of the layer as part of a network snapshot.
+type Layer interface {
+	Dims() (inputs, outputs int)
+
+	Forward(inputs []float64, training bool, rng *rand.Rand) ([]float64, error)
+	Predict(dst, inputs []float64) error
+	Backward(error []float64) []float64
+
+	ForwardBatch(inputs *mat.Dense, training bool, rng *rand.Rand) (*mat.Dense, error)
+	BackwardBatch(error *mat.Dense) *mat.Dense
+
+	Parameters() [][]float64
+	Steps() [][]float64
+
+	Clone() Layer
+
+	io.WriterTo
+	io.ReaderFrom
+}
+
+// regularizer is implemented by layers that regularize their parameters, see LayerConf.
+//
+// penalty returns the penalty that is added to the loss. regularize adds the gradient of the penalty to the steps
+// and applies weight decay for the given learning rate. It is called after gradient clipping, right before the
+// steps are passed to the optimizer.
+type regularizer interface {
+	penalty() float64
+	regularize(learningRate float64)
+}
+
+// noParameters implements the parameter handling of Layer for layers without trainable parameters. Their
+// snapshots are empty.
+type noParameters struct{}
+
+// Parameters returns nil, the layer has no parameters.
+func (noParameters) Parameters() [][]float64 {
+	return nil
+}
+
+// Steps returns nil, the layer has no parameters.
+func (noParameters) Steps() [][]float64 {
+	return nil
+}
+
+// WriteTo writes nothing, the layer has no parameters.
+func (noParameters) WriteTo(w io.Writer) (int64, error) {
+	return 0, nil
+}
+
+// ReadFrom reads nothing, the layer has no parameters.
+func (noParameters) ReadFrom(r io.Reader) (int64, error) {
+	return 0, nil
+}
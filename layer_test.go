@@ -0,0 +1,426 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"testing"
+
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+
+	"github.com/farhaven/nn-go/activation"
+	"github.com/farhaven/nn-go/loss"
+	"github.com/farhaven/nn-go/optimizer"
+)
+
+// scaleLayer is a user-defined layer that multiplies each input by a learned factor.
+type scaleLayer struct {
+	factors []float64
+	step    []float64
+	input   []float64
+	output  []float64
+
+	batchInputs *mat.Dense
+}
+
+func newScaleLayer(factors ...float64) *scaleLayer {
+	return &scaleLayer{
+		factors: factors,
+		step:    make([]float64, len(factors)),
+		input:   make([]float64, len(factors)),
+		output:  make([]float64, len(factors)),
+	}
+}
+
+func (s *scaleLayer) Dims() (int, int) {
+	return len(s.factors), len(s.factors)
+}
+
+func (s *scaleLayer) Forward(inputs []float64, training bool, rng *rand.Rand) ([]float64, error) {
+	copy(s.input, inputs)
+	return s.output, s.Predict(s.output, inputs)
+}
+
+func (s *scaleLayer) Predict(dst, inputs []float64) error {
+	floats.MulTo(dst, s.factors, inputs)
+	return nil
+}
+
+func (s *scaleLayer) Backward(error []float64) []float64 {
+	floats.MulTo(s.step, error, s.input)
+	return floats.MulTo(make([]float64, len(error)), error, s.factors)
+}
+
+func (s *scaleLayer) ForwardBatch(inputs *mat.Dense, training bool, rng *rand.Rand) (*mat.Dense, error) {
+	s.batchInputs = inputs
+
+	rows, cols := inputs.Dims()
+
+	res := mat.NewDense(rows, cols, nil)
+	for i := 0; i < rows; i++ {
+		floats.MulTo(res.RawRowView(i), s.factors, inputs.RawRowView(i))
+	}
+
+	return res, nil
+}
+
+func (s *scaleLayer) BackwardBatch(error *mat.Dense) *mat.Dense {
+	rows, cols := error.Dims()
+
+	for idx := range s.step {
+		s.step[idx] = mat.Dot(error.ColView(idx), s.batchInputs.ColView(idx)) / float64(rows)
+	}
+
+	res := mat.NewDense(rows, cols, nil)
+	for i := 0; i < rows; i++ {
+		floats.MulTo(res.RawRowView(i), s.factors, error.RawRowView(i))
+	}
+
+	return res
+}
+
+func (s *scaleLayer) Parameters() [][]float64 {
+	return [][]float64{s.factors}
+}
+
+func (s *scaleLayer) Steps() [][]float64 {
+	return [][]float64{s.step}
+}
+
+func (s *scaleLayer) Clone() Layer {
+	return newScaleLayer(append([]float64{}, s.factors...)...)
+}
+
+func (s *scaleLayer) WriteTo(w io.Writer) (int64, error) {
+	err := binary.Write(w, binary.LittleEndian, s.factors)
+	return int64(8 * len(s.factors)), err
+}
+
+func (s *scaleLayer) ReadFrom(r io.Reader) (int64, error) {
+	err := binary.Read(r, binary.LittleEndian, s.factors)
+	return int64(8 * len(s.factors)), err
+}
+
+var _ Layer = &scaleLayer{}
+
+// checkLayerGradients compares the errors and steps computed by l for the given inputs with numerical derivatives
+// of Predict, and checks that ForwardBatch and BackwardBatch agree with Forward and Backward.
+func checkLayerGradients(t *testing.T, l Layer, inputs [][]float64, rng *rand.Rand) {
+	t.Helper()
+
+	const h = 1e-6
+
+	_, numOutputs := l.Dims()
+
+	outputErrors := make([][]float64, len(inputs))
+	for idx := range outputErrors {
+		outputErrors[idx] = make([]float64, numOutputs)
+		for i := range outputErrors[idx] {
+			outputErrors[idx][i] = rng.NormFloat64()
+		}
+	}
+
+	// loss returns the dot product of the outputs for x with error, so its derivatives are the errors
+	loss := func(x, error []float64) float64 {
+		output := make([]float64, numOutputs)
+
+		err := l.Predict(output, x)
+		if err != nil {
+			t.Fatal(`can't predict:`, err)
+		}
+
+		return floats.Dot(output, error)
+	}
+
+	var (
+		inputErrors [][]float64
+		avgSteps    [][]float64
+	)
+
+	// Predict starts from the current state of recurrent layers, so they have to return to the state each call of
+	// Forward started from
+	r, isRecurrent := l.(Recurrent)
+
+	var state []float64
+	if isRecurrent {
+		state = r.State()
+	}
+
+	for s, x := range inputs {
+		_, err := l.Forward(x, true, rng)
+		if err != nil {
+			t.Fatal(`forward pass failed:`, err)
+		}
+
+		if isRecurrent {
+			err = r.SetState(state)
+			if err != nil {
+				t.Fatal(`can't restore state:`, err)
+			}
+		}
+
+		inputError := l.Backward(outputErrors[s])
+		inputErrors = append(inputErrors, inputError)
+
+		for idx := range x {
+			v := x[idx]
+
+			x[idx] = v + h
+			plus := loss(x, outputErrors[s])
+			x[idx] = v - h
+			minus := loss(x, outputErrors[s])
+			x[idx] = v
+
+			expected := (plus - minus) / (2 * h)
+			if math.Abs(expected-inputError[idx]) > 1e-5 {
+				t.Errorf(`sample %d: error at input %d is %f, expected %f`, s, idx, inputError[idx], expected)
+			}
+		}
+
+		steps := l.Steps()
+		if avgSteps == nil {
+			for _, step := range steps {
+				avgSteps = append(avgSteps, make([]float64, len(step)))
+			}
+		}
+
+		for i, params := range l.Parameters() {
+			floats.AddScaled(avgSteps[i], 1/float64(len(inputs)), steps[i])
+
+			for idx := range params {
+				v := params[idx]
+
+				params[idx] = v + h
+				plus := loss(x, outputErrors[s])
+				params[idx] = v - h
+				minus := loss(x, outputErrors[s])
+				params[idx] = v
+
+				expected := (plus - minus) / (2 * h)
+				if math.Abs(expected-steps[i][idx]) > 1e-5 {
+					t.Errorf(`sample %d: step %d for parameter %d is %f, expected %f`, s, i, idx, steps[i][idx], expected)
+				}
+			}
+		}
+	}
+
+	batch := mat.NewDense(len(inputs), len(inputs[0]), nil)
+	batchErrors := mat.NewDense(len(inputs), numOutputs, nil)
+	for idx := range inputs {
+		batch.SetRow(idx, inputs[idx])
+		batchErrors.SetRow(idx, outputErrors[idx])
+	}
+
+	outputs, err := l.ForwardBatch(batch, false, rng)
+	if err != nil {
+		t.Fatal(`batch forward pass failed:`, err)
+	}
+
+	res := l.BackwardBatch(batchErrors)
+
+	for idx, x := range inputs {
+		output := make([]float64, numOutputs)
+
+		err := l.Predict(output, x)
+		if err != nil {
+			t.Fatal(`can't predict:`, err)
+		}
+
+		if !floats.EqualApprox(output, outputs.RawRowView(idx), 1e-12) {
+			t.Errorf(`sample %d: batch outputs differ`, idx)
+		}
+
+		if !floats.EqualApprox(inputErrors[idx], res.RawRowView(idx), 1e-12) {
+			t.Errorf(`sample %d: batch errors differ`, idx)
+		}
+	}
+
+	for idx, step := range l.Steps() {
+		if !floats.EqualApprox(avgSteps[idx], step, 1e-12) {
+			t.Errorf(`batch step %d isn't the average of the steps of the samples`, idx)
+		}
+	}
+}
+
+// randomInputs returns the given number of random input vectors of the given size.
+func randomInputs(samples, size int, rng *rand.Rand) [][]float64 {
+	res := make([][]float64, samples)
+	for idx := range res {
+		res[idx] = make([]float64, size)
+		for i := range res[idx] {
+			res[idx][i] = rng.NormFloat64()
+		}
+	}
+
+	return res
+}
+
+func TestNewFromLayersMismatchedDims(t *testing.T) {
+	hidden := newDense(t, 2, LayerConf{Inputs: 3, Activation: activation.Tanh{}}, testRand())
+
+	_, err := NewFromLayers([]Layer{hidden, newScaleLayer(1, 2)})
+	if err == nil {
+		t.Error(`expected an error for mismatched layers`)
+	}
+
+	_, err = NewFromLayers(nil)
+	if err == nil {
+		t.Error(`expected an error for a network without layers`)
+	}
+}
+
+func TestNetworkCustomLayer(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	net, err := NewFromLayers([]Layer{
+		newDense(t, 2, LayerConf{Inputs: 3, Activation: activation.Tanh{}, Bias: true}, rng),
+		newScaleLayer(1, 2, 3),
+		newDense(t, 3, LayerConf{Inputs: 1, Activation: activation.Identity{}}, rng),
+	}, WithRand(rng))
+	if err != nil {
+		t.Fatal(`can't create network:`, err)
+	}
+
+	inputs := [][]float64{{0, 1}, {1, 0}, {-1, 1}, {0.5, -0.5}}
+	targets := [][]float64{{1}, {-1}, {0.5}, {0}}
+
+	// The gradient of the custom layer has to match the numerical one
+	grad, err := net.Gradient(inputs[0], targets[0], loss.SquaredError{})
+	if err != nil {
+		t.Fatal(`can't compute gradient:`, err)
+	}
+
+	const h = 1e-6
+
+	scale := net.layers[1].(*scaleLayer)
+	for idx, f := range scale.factors {
+		value := func(v float64) float64 {
+			scale.factors[idx] = v
+			defer func() { scale.factors[idx] = f }()
+
+			output, err := net.Predict(inputs[0])
+			if err != nil {
+				t.Fatal(`can't predict:`, err)
+			}
+
+			return loss.SquaredError{}.Value(output, targets[0])
+		}
+
+		numeric := (value(f+h) - value(f-h)) / (2 * h)
+		if math.Abs(numeric-grad[1][idx]) > 1e-6 {
+			t.Errorf(`factor %d: expected gradient %f, got %f`, idx, numeric, grad[1][idx])
+		}
+	}
+
+	net.SetOptimizer(optimizer.Adam{})
+
+	before := append([]float64{}, scale.factors...)
+
+	first := float64(0)
+	last := float64(0)
+	for i := 0; i < 200; i++ {
+		last, err = net.TrainBatch(inputs, targets, loss.MSE{}, 0.01)
+		if err != nil {
+			t.Fatal(`training failed:`, err)
+		}
+
+		if i == 0 {
+			first = last
+		}
+	}
+
+	if last >= first {
+		t.Errorf(`loss didn't decrease: %f -> %f`, first, last)
+	}
+
+	if floats.Equal(before, scale.factors) {
+		t.Error(`parameters of the custom layer weren't updated`)
+	}
+
+	clone := net.Clone()
+	if !floats.Equal(clone.layers[1].(*scaleLayer).factors, scale.factors) {
+		t.Error(`clone has different parameters`)
+	}
+
+	var buf bytes.Buffer
+
+	_, err = net.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(`can't snapshot network:`, err)
+	}
+
+	_, err = Load(bytes.NewReader(buf.Bytes()))
+	if err == nil {
+		t.Error(`expected an error when loading a network with a custom layer`)
+	}
+
+	rng = rand.New(rand.NewSource(2))
+
+	restored, err := NewFromLayers([]Layer{
+		newDense(t, 2, LayerConf{Inputs: 3, Activation: activation.Tanh{}, Bias: true}, rng),
+		newScaleLayer(1, 2, 3),
+		newDense(t, 3, LayerConf{Inputs: 1, Activation: activation.Identity{}}, rng),
+	}, WithRand(rng))
+	if err != nil {
+		t.Fatal(`can't create network:`, err)
+	}
+	restored.SetOptimizer(optimizer.Adam{})
+
+	_, err = restored.ReadFrom(&buf)
+	if err != nil {
+		t.Fatal(`can't restore network:`, err)
+	}
+
+	// Resumed training has to continue exactly where the first network is
+	for _, n := range []*Network{net, restored} {
+		_, err = n.TrainBatch(inputs, targets, loss.MSE{}, 0.01)
+		if err != nil {
+			t.Fatal(`training failed:`, err)
+		}
+	}
+
+	for idx, p := range net.Parameters() {
+		if !floats.Equal(p, restored.Parameters()[idx]) {
+			t.Errorf(`layer %d: parameters differ after resuming`, idx)
+		}
+	}
+}
+
+func TestNetworkReadFromMismatchedLayerType(t *testing.T) {
+	rng := testRand()
+
+	net, err := NewFromLayers([]Layer{
+		newDense(t, 2, LayerConf{Inputs: 3, Activation: activation.Tanh{}, Bias: true}, rng),
+		newScaleLayer(1, 2, 3),
+		newDense(t, 3, LayerConf{Inputs: 1, Activation: activation.Identity{}}, rng),
+	})
+	if err != nil {
+		t.Fatal(`can't create network:`, err)
+	}
+
+	var buf bytes.Buffer
+
+	_, err = net.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(`can't snapshot network:`, err)
+	}
+
+	dense, err := New([]LayerConf{
+		{Inputs: 2},
+		{Inputs: 3, Activation: activation.Tanh{}, Bias: true},
+		{Inputs: 3, Activation: activation.Identity{}},
+		{Inputs: 1, Activation: activation.Identity{}},
+	})
+	if err != nil {
+		t.Fatal(`can't create network:`, err)
+	}
+
+	_, err = dense.ReadFrom(&buf)
+	if !errors.Is(err, ErrArchitectureMismatch) {
+		t.Errorf(`expected an architecture mismatch, got %v`, err)
+	}
+}
@@ -0,0 +1,168 @@
+// Package loss contains loss functions that measure how far the outputs of a network are from their targets.
+package loss
+
+import "math"
+
+// epsilon bounds probabilities away from 0 and 1 in the cross-entropy losses to keep logarithms and their
+// derivatives finite.
+const epsilon = 1e-12
+
+// Loss represents a loss function.
+//
+// Value computes the loss of the outputs of a network for a single sample. Error computes the negative gradient
+// of Value with respect to the outputs. Its result can be passed to Network.Backprop.
+type Loss interface {
+	Value(outputs, targets []float64) float64
+	Error(outputs, targets []float64) []float64
+}
+
+// SquaredError computes half the sum of squared differences. Its Error is the plain difference between targets
+// and outputs, which makes it equivalent to network.Error.
+type SquaredError struct{}
+
+func (SquaredError) Value(outputs, targets []float64) float64 {
+	res := float64(0)
+	for idx, t := range targets {
+		res += math.Pow(t-outputs[idx], 2)
+	}
+	return res / 2
+}
+
+func (SquaredError) Error(outputs, targets []float64) []float64 {
+	res := make([]float64, len(targets))
+	for idx, t := range targets {
+		res[idx] = t - outputs[idx]
+	}
+	return res
+}
+
+// MSE computes the mean squared error.
+type MSE struct{}
+
+func (MSE) Value(outputs, targets []float64) float64 {
+	res := float64(0)
+	for idx, t := range targets {
+		res += math.Pow(t-outputs[idx], 2)
+	}
+	return res / float64(len(targets))
+}
+
+func (MSE) Error(outputs, targets []float64) []float64 {
+	res := make([]float64, len(targets))
+	for idx, t := range targets {
+		res[idx] = 2 * (t - outputs[idx]) / float64(len(targets))
+	}
+	return res
+}
+
+// MAE computes the mean absolute error.
+type MAE struct{}
+
+func (MAE) Value(outputs, targets []float64) float64 {
+	res := float64(0)
+	for idx, t := range targets {
+		res += math.Abs(t - outputs[idx])
+	}
+	return res / float64(len(targets))
+}
+
+func (MAE) Error(outputs, targets []float64) []float64 {
+	res := make([]float64, len(targets))
+	for idx, t := range targets {
+		switch d := t - outputs[idx]; {
+		case d > 0:
+			res[idx] = 1 / float64(len(targets))
+		case d < 0:
+			res[idx] = -1 / float64(len(targets))
+		}
+	}
+	return res
+}
+
+// Huber computes the mean Huber loss, which is quadratic for differences up to Delta and linear beyond that.
+// This makes it less sensitive to outliers than MSE. If Delta is 0, it defaults to 1.
+type Huber struct {
+	Delta float64
+}
+
+func (h Huber) delta() float64 {
+	if h.Delta == 0 {
+		return 1
+	}
+	return h.Delta
+}
+
+func (h Huber) Value(outputs, targets []float64) float64 {
+	delta := h.delta()
+
+	res := float64(0)
+	for idx, t := range targets {
+		d := math.Abs(t - outputs[idx])
+		if d <= delta {
+			res += d * d / 2
+		} else {
+			res += delta * (d - delta/2)
+		}
+	}
+	return res / float64(len(targets))
+}
+
+func (h Huber) Error(outputs, targets []float64) []float64 {
+	delta := h.delta()
+
+	res := make([]float64, len(targets))
+	for idx, t := range targets {
+		d := math.Max(-delta, math.Min(delta, t-outputs[idx]))
+		res[idx] = d / float64(len(targets))
+	}
+	return res
+}
+
+// BinaryCrossEntropy computes the mean cross-entropy of independent binary outputs. Outputs are expected to be
+// probabilities, for example from a Sigmoid activation, and targets to be 0 or 1.
+type BinaryCrossEntropy struct{}
+
+func (BinaryCrossEntropy) Value(outputs, targets []float64) float64 {
+	res := float64(0)
+	for idx, t := range targets {
+		o := clamp(outputs[idx])
+		res -= t*math.Log(o) + (1-t)*math.Log(1-o)
+	}
+	return res / float64(len(targets))
+}
+
+func (BinaryCrossEntropy) Error(outputs, targets []float64) []float64 {
+	res := make([]float64, len(targets))
+	for idx, t := range targets {
+		o := clamp(outputs[idx])
+		res[idx] = (t/o - (1-t)/(1-o)) / float64(len(targets))
+	}
+	return res
+}
+
+// CategoricalCrossEntropy computes the cross-entropy between a probability distribution over classes and a
+// target distribution, usually a one-hot encoded class label. Outputs are expected to sum up to 1, for example
+// by using a Softmax activation.
+type CategoricalCrossEntropy struct{}
+
+func (CategoricalCrossEntropy) Value(outputs, targets []float64) float64 {
+	res := float64(0)
+	for idx, t := range targets {
+		if t != 0 {
+			res -= t * math.Log(clamp(outputs[idx]))
+		}
+	}
+	return res
+}
+
+func (CategoricalCrossEntropy) Error(outputs, targets []float64) []float64 {
+	res := make([]float64, len(targets))
+	for idx, t := range targets {
+		res[idx] = t / clamp(outputs[idx])
+	}
+	return res
+}
+
+func clamp(p float64) float64 {
+	return math.Max(epsilon, math.Min(1-epsilon, p))
+}
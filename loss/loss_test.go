@@ -0,0 +1,91 @@
+package loss
+
+import (
+	"math"
+	"testing"
+)
+
+var losses = map[string]Loss{
+	"squared error":             SquaredError{},
+	"mse":                       MSE{},
+	"mae":                       MAE{},
+	"huber":                     Huber{Delta: 0.5},
+	"binary cross-entropy":      BinaryCrossEntropy{},
+	"categorical cross-entropy": CategoricalCrossEntropy{},
+}
+
+func TestLossValues(t *testing.T) {
+	outputs := []float64{0.8, 0.1, 0.1}
+	targets := []float64{1, 0, 0}
+
+	expected := map[string]float64{
+		"squared error":             (0.04 + 0.01 + 0.01) / 2,
+		"mse":                       (0.04 + 0.01 + 0.01) / 3,
+		"mae":                       (0.2 + 0.1 + 0.1) / 3,
+		"huber":                     (0.02 + 0.005 + 0.005) / 3,
+		"binary cross-entropy":      -(math.Log(0.8) + 2*math.Log(0.9)) / 3,
+		"categorical cross-entropy": -math.Log(0.8),
+	}
+
+	for name, l := range losses {
+		if v := l.Value(outputs, targets); math.Abs(v-expected[name]) > 1e-12 {
+			t.Errorf(`%s: expected %f, got %f`, name, expected[name], v)
+		}
+	}
+}
+
+func TestHuberIsLinearForOutliers(t *testing.T) {
+	l := Huber{}
+
+	if v := l.Value([]float64{3}, []float64{0}); v != 2.5 {
+		t.Errorf(`expected 2.5, got %f`, v)
+	}
+
+	if e := l.Error([]float64{3}, []float64{0}); e[0] != -1 {
+		t.Errorf(`expected error to be clipped to -1, got %f`, e[0])
+	}
+}
+
+func TestLossErrorIsNegativeGradient(t *testing.T) {
+	const h = 1e-6
+
+	outputs := []float64{0.7, 0.2, 0.4}
+	targets := []float64{1, 0, 0.3}
+
+	for name, l := range losses {
+		error := l.Error(outputs, targets)
+
+		for idx := range outputs {
+			o := outputs[idx]
+
+			outputs[idx] = o + h
+			plus := l.Value(outputs, targets)
+			outputs[idx] = o - h
+			minus := l.Value(outputs, targets)
+			outputs[idx] = o
+
+			numeric := -(plus - minus) / (2 * h)
+
+			if math.Abs(numeric-error[idx]) > 1e-6 {
+				t.Errorf(`%s: output %d: numeric gradient %f, got %f`, name, idx, numeric, error[idx])
+			}
+		}
+	}
+}
+
+func TestCrossEntropyIsFinite(t *testing.T) {
+	outputs := []float64{0, 1}
+	targets := []float64{1, 0}
+
+	for _, l := range []Loss{BinaryCrossEntropy{}, CategoricalCrossEntropy{}} {
+		if v := l.Value(outputs, targets); math.IsInf(v, 0) || math.IsNaN(v) {
+			t.Errorf(`%T: non-finite loss %f`, l, v)
+		}
+
+		for _, e := range l.Error(outputs, targets) {
+			if math.IsInf(e, 0) || math.IsNaN(e) {
+				t.Errorf(`%T: non-finite error %f`, l, e)
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package network
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+
+	"github.com/farhaven/nn-go/activation"
+)
+
+// LSTM is a long short-term memory layer as proposed by Hochreiter and Schmidhuber. Besides its outputs, it keeps
+// a cell state that is only changed through gates, which lets errors flow over many steps without vanishing.
+//
+// At each step, the input gate i, the forget gate f and the output gate o are computed by applying Sigmoid to the
+// weighted inputs and previous outputs of each gate, and the candidate g by applying Tanh. The new cell state is
+// c = f*c' + i*g for the previous cell state c', and the outputs are h = o*tanh(c). The state of the layer
+// consists of its outputs followed by its cell state. It is configured with a RecurrentConf.
+type LSTM struct {
+	recurrent
+}
+
+// NewLSTM creates an LSTM layer with the given number of inputs, configured by conf. The weights are initialized
+// with random values drawn from rng.
+func NewLSTM(inputs int, conf RecurrentConf, rng *rand.Rand) (*LSTM, error) {
+	if conf.Activation != nil {
+		return nil, errors.New("LSTM layers have fixed activations")
+	}
+
+	l := &LSTM{}
+
+	err := l.init(lstmCell{}, inputs, conf, activation.Tanh{}, rng)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start out remembering everything
+	if l.bias != nil {
+		for idx := conf.Units; idx < 2*conf.Units; idx++ {
+			l.bias[idx] = 1
+		}
+	}
+
+	return l, nil
+}
+
+// Clone returns a deep copy of l, including its state.
+func (l *LSTM) Clone() Layer {
+	clone := &LSTM{}
+	l.clone(&clone.recurrent)
+
+	return clone
+}
+
+var _ Recurrent = &LSTM{}
+
+// lstmCell computes the steps of an LSTM. Its gates are the input gate, the forget gate, the candidate and the
+// output gate, and its cache holds their values followed by tanh of the new cell state.
+type lstmCell struct{}
+
+func (lstmCell) gates() int {
+	return 4
+}
+
+func (lstmCell) stateSize(units int) int {
+	return 2 * units
+}
+
+func (lstmCell) cacheSize(units int) int {
+	return 5 * units
+}
+
+func (lstmCell) forward(t *timeStep, units int) {
+	sigmoid := activation.Sigmoid{}
+
+	for j := 0; j < units; j++ {
+		i := sigmoid.Forward(t.a[j] + t.u[j])
+		f := sigmoid.Forward(t.a[units+j] + t.u[units+j])
+		g := math.Tanh(t.a[2*units+j] + t.u[2*units+j])
+		o := sigmoid.Forward(t.a[3*units+j] + t.u[3*units+j])
+
+		c := f*t.prev[units+j] + i*g
+		tc := math.Tanh(c)
+
+		t.cache[j], t.cache[units+j], t.cache[2*units+j], t.cache[3*units+j], t.cache[4*units+j] = i, f, g, o, tc
+
+		t.state[j] = o * tc
+		t.state[units+j] = c
+	}
+}
+
+func (lstmCell) backward(t *timeStep, units int, error, inputDelta, outputDelta, prev []float64) {
+	for j := 0; j < units; j++ {
+		i, f, g, o, tc := t.cache[j], t.cache[units+j], t.cache[2*units+j], t.cache[3*units+j], t.cache[4*units+j]
+
+		dh := error[j]
+		dc := error[units+j] + dh*o*(1-tc*tc)
+
+		inputDelta[j] = dc * g * i * (1 - i)
+		inputDelta[units+j] = dc * t.prev[units+j] * f * (1 - f)
+		inputDelta[2*units+j] = dc * i * (1 - g*g)
+		inputDelta[3*units+j] = dh * tc * o * (1 - o)
+
+		prev[units+j] += dc * f
+	}
+
+	copy(outputDelta, inputDelta)
+}
@@ -0,0 +1,183 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/farhaven/nn-go/activation"
+)
+
+// manifestVersion is the version of the snapshot format written by Network.WriteTo.
+const manifestVersion = 1
+
+// ErrArchitectureMismatch is returned when restoring a snapshot into a network with a different architecture.
+var ErrArchitectureMismatch = errors.New("network architecture mismatch")
+
+// manifest describes the architecture of a network. It is stored as the first entry of every snapshot.
+//
+// The layers mirror the LayerConf slice the network was created from, so the first entry only holds the
+// number of inputs. Layers other than Dense are only described by their type, number of outputs and whether they
+// are biased, and can't be rebuilt by Load.
+type manifest struct {
+	Version int             `json:"version"`
+	Layers  []layerManifest `json:"layers"`
+}
+
+type layerManifest struct {
+	Type       string             `json:"type,omitempty"` // Go type of the layer, empty for Dense layers
+	Inputs     int                `json:"inputs"`
+	Activation *activation.Config `json:"activation,omitempty"`
+	Bias       bool               `json:"bias,omitempty"`
+
+	L1          float64 `json:"l1,omitempty"`
+	L2          float64 `json:"l2,omitempty"`
+	WeightDecay float64 `json:"weightDecay,omitempty"`
+	Dropout     float64 `json:"dropout,omitempty"`
+}
+
+// biased is implemented by layers other than Dense that may have biases, so that the manifest can record them.
+type biased interface {
+	hasBias() bool
+}
+
+// manifest returns the manifest describing the architecture of n.
+func (n *Network) manifest() (manifest, error) {
+	inputs, _ := n.layers[0].Dims()
+
+	m := manifest{
+		Version: manifestVersion,
+		Layers:  []layerManifest{{Inputs: inputs}},
+	}
+
+	for idx, layer := range n.layers {
+		_, outputs := layer.Dims()
+
+		l, ok := layer.(*Dense)
+		if !ok {
+			lm := layerManifest{
+				Type:   fmt.Sprintf("%T", layer),
+				Inputs: outputs,
+			}
+			if b, ok := layer.(biased); ok {
+				lm.Bias = b.hasBias()
+			}
+
+			m.Layers = append(m.Layers, lm)
+			continue
+		}
+
+		_, err := activation.Name(l.activation)
+		if err != nil {
+			return manifest{}, fmt.Errorf("layer %d: %w", idx, err)
+		}
+
+		m.Layers = append(m.Layers, layerManifest{
+			Inputs:     outputs,
+			Activation: &activation.Config{Activation: l.activation},
+			Bias:       l.bias != nil,
+
+			L1:          l.l1,
+			L2:          l.l2,
+			WeightDecay: l.weightDecay,
+			Dropout:     l.dropout,
+		})
+	}
+
+	return m, nil
+}
+
+// layerConfs returns the layer configurations for creating a network with the architecture described by m.
+func (m manifest) layerConfs() ([]LayerConf, error) {
+	if m.Version != manifestVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", m.Version)
+	}
+
+	if len(m.Layers) < 2 {
+		return nil, fmt.Errorf("snapshot has %d layers, need at least 2", len(m.Layers))
+	}
+
+	var res []LayerConf
+
+	for idx, l := range m.Layers {
+		if l.Type != "" {
+			return nil, fmt.Errorf("layer %d of type %s can't be rebuilt from a snapshot", idx, l.Type)
+		}
+
+		conf := LayerConf{
+			Inputs: l.Inputs,
+			Bias:   l.Bias,
+
+			L1:          l.L1,
+			L2:          l.L2,
+			WeightDecay: l.WeightDecay,
+			Dropout:     l.Dropout,
+		}
+
+		if idx > 0 {
+			if l.Activation == nil {
+				return nil, fmt.Errorf("layer %d has no activation", idx)
+			}
+
+			conf.Activation = l.Activation.Activation
+		}
+
+		res = append(res, conf)
+	}
+
+	return res, nil
+}
+
+// validate checks whether a network described by m can be restored into one described by expected. Regularization
+// and dropout are training parameters rather than part of the architecture, so they may differ.
+func (m manifest) validate(expected manifest) error {
+	if m.Version != manifestVersion {
+		return fmt.Errorf("unsupported snapshot version %d", m.Version)
+	}
+
+	if len(m.Layers) != len(expected.Layers) {
+		return fmt.Errorf("%w: snapshot has %d layers, expected %d", ErrArchitectureMismatch, len(m.Layers), len(expected.Layers))
+	}
+
+	for idx, l := range m.Layers {
+		e := expected.Layers[idx]
+
+		if l.Type != e.Type {
+			return fmt.Errorf("%w: layer %d has type %s in snapshot, expected %s", ErrArchitectureMismatch, idx, describeType(l.Type), describeType(e.Type))
+		}
+
+		if l.Inputs != e.Inputs {
+			return fmt.Errorf("%w: layer %d has %d neurons in snapshot, expected %d", ErrArchitectureMismatch, idx, l.Inputs, e.Inputs)
+		}
+
+		if l.Bias != e.Bias {
+			return fmt.Errorf("%w: layer %d is biased in snapshot: %t, expected %t", ErrArchitectureMismatch, idx, l.Bias, e.Bias)
+		}
+
+		// Activations are compared by their encoding, which leaves out the learned parameters of trainable ones
+		if describeActivation(l.Activation) != describeActivation(e.Activation) {
+			return fmt.Errorf("%w: layer %d has activation %s in snapshot, expected %s", ErrArchitectureMismatch, idx, describeActivation(l.Activation), describeActivation(e.Activation))
+		}
+	}
+
+	return nil
+}
+
+func describeType(t string) string {
+	if t == "" {
+		return "Dense"
+	}
+	return t
+}
+
+func describeActivation(c *activation.Config) string {
+	if c == nil {
+		return "none"
+	}
+
+	data, err := c.MarshalJSON()
+	if err != nil {
+		return fmt.Sprintf("%T", c.Activation)
+	}
+
+	return string(data)
+}
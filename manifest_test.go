@@ -0,0 +1,343 @@
+package network
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+
+	"github.com/farhaven/nn-go/activation"
+)
+
+// stripManifest turns the snapshot in r into one as written before manifests were introduced.
+func stripManifest(t *testing.T, r io.Reader) *bytes.Buffer {
+	t.Helper()
+
+	var res bytes.Buffer
+
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(&res)
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(`can't read snapshot:`, err)
+		}
+
+		if hdr.Name == "manifest" {
+			continue
+		}
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(`can't read snapshot entry:`, err)
+		}
+
+		err = tw.WriteHeader(hdr)
+		if err != nil {
+			t.Fatal(`can't write snapshot entry:`, err)
+		}
+
+		_, err = tw.Write(data)
+		if err != nil {
+			t.Fatal(`can't write snapshot entry:`, err)
+		}
+	}
+
+	err := tw.Close()
+	if err != nil {
+		t.Fatal(`can't write snapshot:`, err)
+	}
+
+	return &res
+}
+
+func TestLoad(t *testing.T) {
+	config := []LayerConf{
+		{Inputs: 2},
+		{Inputs: 3, Activation: activation.LeakyReLU{Leak: 0.01, Cap: 5}, Bias: true},
+		{Inputs: 2, Activation: activation.ELU{A: 0.5}},
+		{Inputs: 2, Activation: activation.Softmax{}, Bias: true},
+	}
+	net1, err := New(config)
+	if err != nil {
+		t.Fatal(`can't create network`, err)
+	}
+
+	var buf bytes.Buffer
+
+	_, err = net1.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(`can't snapshot network:`, err)
+	}
+
+	net2, err := Load(&buf)
+	if err != nil {
+		t.Fatal(`can't load network:`, err)
+	}
+
+	if len(net2.layers) != len(net1.layers) {
+		t.Fatalf(`expected %d layers, got %d`, len(net1.layers), len(net2.layers))
+	}
+
+	for idx := range net1.layers {
+		if denseLayer(net1, idx).activation != denseLayer(net2, idx).activation {
+			t.Errorf(`activation of layer %d changed: expected %v, got %v`, idx, denseLayer(net1, idx).activation, denseLayer(net2, idx).activation)
+		}
+	}
+
+	output1 := net1.Forward([]float64{1, -1})
+	output2 := net2.Forward([]float64{1, -1})
+
+	for idx := range output1 {
+		if output1[idx] != output2[idx] {
+			t.Errorf(`output changed: expected %v, got %v`, output1, output2)
+		}
+	}
+}
+
+func TestLoadWithoutManifest(t *testing.T) {
+	config := []LayerConf{
+		{Inputs: 2},
+		{Inputs: 1, Activation: activation.Sigmoid{}},
+	}
+	net, err := New(config)
+	if err != nil {
+		t.Fatal(`can't create network`, err)
+	}
+
+	var buf bytes.Buffer
+
+	_, err = net.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(`can't snapshot network:`, err)
+	}
+
+	_, err = Load(stripManifest(t, &buf))
+	if err == nil {
+		t.Error(`expected an error when loading a snapshot without manifest`)
+	}
+}
+
+func TestNetworkReadFromMismatchedArchitecture(t *testing.T) {
+	base := []LayerConf{
+		{Inputs: 2},
+		{Inputs: 3, Activation: activation.Sigmoid{}},
+		{Inputs: 1, Activation: activation.Sigmoid{}},
+	}
+
+	tests := map[string][]LayerConf{
+		"layer count": {
+			{Inputs: 2},
+			{Inputs: 1, Activation: activation.Sigmoid{}},
+		},
+		"layer size": {
+			{Inputs: 2},
+			{Inputs: 4, Activation: activation.Sigmoid{}},
+			{Inputs: 1, Activation: activation.Sigmoid{}},
+		},
+		"activation": {
+			{Inputs: 2},
+			{Inputs: 3, Activation: activation.Tanh{}},
+			{Inputs: 1, Activation: activation.Sigmoid{}},
+		},
+		"activation parameters": {
+			{Inputs: 2},
+			{Inputs: 3, Activation: activation.Sigmoid{}},
+			{Inputs: 1, Activation: activation.ELU{A: 1}},
+		},
+		"bias": {
+			{Inputs: 2},
+			{Inputs: 3, Activation: activation.Sigmoid{}, Bias: true},
+			{Inputs: 1, Activation: activation.Sigmoid{}},
+		},
+	}
+
+	net1, err := New(base)
+	if err != nil {
+		t.Fatal(`can't create network`, err)
+	}
+
+	var buf bytes.Buffer
+
+	_, err = net1.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(`can't snapshot network:`, err)
+	}
+
+	for name, config := range tests {
+		net2, err := New(config)
+		if err != nil {
+			t.Fatal(`can't create network`, err)
+		}
+
+		weights := mat.DenseCopyOf(denseLayer(net2, 0).weights)
+
+		_, err = net2.ReadFrom(bytes.NewReader(buf.Bytes()))
+		if !errors.Is(err, ErrArchitectureMismatch) {
+			t.Errorf(`%s: expected architecture mismatch, got %v`, name, err)
+		}
+
+		if !mat.Equal(weights, denseLayer(net2, 0).weights) {
+			t.Errorf(`%s: network was modified`, name)
+		}
+	}
+}
+
+func TestNetworkReadFromMismatchedConvolutionBias(t *testing.T) {
+	newNet := func(bias bool) *Network {
+		conv, err := NewConv2D(Conv2DConf{Input: Shape{1, 4, 4}, Filters: 2, Kernel: 3, Activation: activation.Tanh{}, Bias: bias}, testRand())
+		if err != nil {
+			t.Fatal(`can't create layer:`, err)
+		}
+
+		output, err := NewDense(8, LayerConf{Inputs: 1, Activation: activation.Sigmoid{}}, testRand())
+		if err != nil {
+			t.Fatal(`can't create layer:`, err)
+		}
+
+		net, err := NewFromLayers([]Layer{conv, output})
+		if err != nil {
+			t.Fatal(`can't create network:`, err)
+		}
+
+		return net
+	}
+
+	net1, net2 := newNet(true), newNet(false)
+
+	m, err := net1.manifest()
+	if err != nil {
+		t.Fatal(`can't create manifest:`, err)
+	}
+	if !m.Layers[1].Bias {
+		t.Error(`manifest doesn't record the biases of the convolution`)
+	}
+
+	var buf bytes.Buffer
+
+	_, err = net1.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(`can't snapshot network:`, err)
+	}
+
+	_, err = net2.ReadFrom(&buf)
+	if !errors.Is(err, ErrArchitectureMismatch) {
+		t.Errorf(`expected architecture mismatch, got %v`, err)
+	}
+}
+
+func TestNetworkReadFromMismatchedLegacySnapshot(t *testing.T) {
+	net1, err := New([]LayerConf{
+		{Inputs: 2},
+		{Inputs: 3, Activation: activation.Sigmoid{}},
+		{Inputs: 1, Activation: activation.Sigmoid{}},
+	})
+	if err != nil {
+		t.Fatal(`can't create network`, err)
+	}
+
+	// Only the last layer differs, so the first one is restored before the mismatch is found
+	net2, err := New([]LayerConf{
+		{Inputs: 2},
+		{Inputs: 3, Activation: activation.Sigmoid{}},
+		{Inputs: 2, Activation: activation.Sigmoid{}},
+	})
+	if err != nil {
+		t.Fatal(`can't create network`, err)
+	}
+
+	var buf bytes.Buffer
+
+	_, err = net1.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(`can't snapshot network:`, err)
+	}
+
+	weights := mat.DenseCopyOf(denseLayer(net2, 0).weights)
+
+	_, err = net2.ReadFrom(stripManifest(t, &buf))
+	if !errors.Is(err, ErrArchitectureMismatch) {
+		t.Errorf(`expected architecture mismatch, got %v`, err)
+	}
+
+	if !mat.Equal(weights, denseLayer(net2, 0).weights) {
+		t.Error(`network was partially restored`)
+	}
+}
+
+// unregistered is an activation that isn't known to the activation registry.
+type unregistered struct {
+	activation.Tanh
+}
+
+func TestNetworkWriteToUnregisteredActivation(t *testing.T) {
+	net, err := New([]LayerConf{
+		{Inputs: 2},
+		{Inputs: 1, Activation: unregistered{}},
+	})
+	if err != nil {
+		t.Fatal(`can't create network`, err)
+	}
+
+	var buf bytes.Buffer
+
+	_, err = net.WriteTo(&buf)
+	if err == nil {
+		t.Error(`expected an error when persisting an unregistered activation`)
+	}
+}
+
+func TestLoadRegularization(t *testing.T) {
+	net, err := New([]LayerConf{
+		{Inputs: 2},
+		{Inputs: 3, Activation: activation.Tanh{}, L1: 0.1, L2: 0.2, WeightDecay: 0.3},
+	})
+	if err != nil {
+		t.Fatal(`can't create network:`, err)
+	}
+
+	var buf bytes.Buffer
+
+	_, err = net.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(`can't snapshot network:`, err)
+	}
+
+	restored, err := Load(&buf)
+	if err != nil {
+		t.Fatal(`can't load network:`, err)
+	}
+
+	l := denseLayer(restored, 0)
+	if l.l1 != 0.1 || l.l2 != 0.2 || l.weightDecay != 0.3 {
+		t.Errorf(`regularization not restored: l1 %f, l2 %f, weight decay %f`, l.l1, l.l2, l.weightDecay)
+	}
+
+	// Regularization isn't part of the architecture
+	other, err := New([]LayerConf{
+		{Inputs: 2},
+		{Inputs: 3, Activation: activation.Tanh{}},
+	})
+	if err != nil {
+		t.Fatal(`can't create network:`, err)
+	}
+
+	buf.Reset()
+	_, err = net.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(`can't snapshot network:`, err)
+	}
+
+	_, err = other.ReadFrom(&buf)
+	if err != nil {
+		t.Error(`can't restore snapshot into network with different regularization:`, err)
+	}
+}
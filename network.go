@@ -2,184 +2,440 @@ package network
 
 import (
 	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math"
 	"math/rand"
 	"strconv"
-	"errors"
+	"strings"
+	"sync"
 
+	"gonum.org/v1/gonum/floats"
 	"gonum.org/v1/gonum/mat"
 
 	"github.com/farhaven/nn-go/activation"
+	"github.com/farhaven/nn-go/initializer"
+	"github.com/farhaven/nn-go/loss"
+	"github.com/farhaven/nn-go/optimizer"
 )
 
-type layer struct {
-	weights    *mat.Dense
-	delta      *mat.VecDense
-	output     *mat.VecDense
-	scratch    *mat.Dense // Scratch buffer for weight updates
-	activation activation.Activation
+// NonFiniteError reports a NaN or infinite value that showed up during a forward pass.
+type NonFiniteError struct {
+	Layer  int     // Index of the layer the value belongs to
+	Unit   int     // Index of the neuron, or of the input if Input is set
+	Sample int     // Index of the sample within a batch, 0 outside of TrainBatch
+	Input  bool    // Set if the value was passed into the network rather than computed by the layer
+	Value  float64 // The non-finite value
+	Sum    float64 // Weighted input of the neuron before the activation, unset if Input is set
+}
+
+func (e *NonFiniteError) Error() string {
+	if e.Input {
+		return fmt.Sprintf("non-finite input %v at %d for sample %d", e.Value, e.Unit, e.Sample)
+	}
+
+	return fmt.Sprintf("non-finite output %v at unit %d of layer %d for sample %d, was %v before activation", e.Value, e.Unit, e.Layer, e.Sample, e.Sum)
 }
 
-func newLayer(inputs, outputs int, activation activation.Activation) layer {
-	// Initialize layer with random weights
-	weights := mat.NewDense(outputs, inputs, nil)
-	weights.Apply(func(i, j int, v float64) float64 {
-		return rand.NormFloat64()
-	}, weights)
+func isFinite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
 
-	return layer{
-		weights:    weights,
-		delta:      mat.NewVecDense(outputs, nil),
-		output:     mat.NewVecDense(outputs, nil),
-		scratch:    mat.NewDense(outputs, inputs, nil),
-		activation: activation,
+// checkInputs returns a *NonFiniteError if any of the inputs of the network is NaN or infinite.
+func checkInputs(inputs []float64, sample int) error {
+	for idx, v := range inputs {
+		if !isFinite(v) {
+			return &NonFiniteError{
+				Unit:   idx,
+				Sample: sample,
+				Input:  true,
+				Value:  v,
+			}
+		}
 	}
+
+	return nil
 }
 
-func (l *layer) clone() *layer {
-	clone := layer{
-		activation: l.activation,
-		weights:    mat.DenseCopyOf(l.weights),
-		delta:      mat.VecDenseCopyOf(l.delta),
-		output:     mat.VecDenseCopyOf(l.output),
-		scratch:    mat.DenseCopyOf(l.scratch),
+// checkOutputs returns a *NonFiniteError if any of the outputs of a layer is NaN or infinite. The layer index
+// has to be filled in by the caller.
+func checkOutputs(sum, output []float64, sample int) error {
+	for idx, v := range output {
+		if !isFinite(v) {
+			return &NonFiniteError{
+				Unit:   idx,
+				Sample: sample,
+				Value:  v,
+				Sum:    sum[idx],
+			}
+		}
 	}
 
-	return &clone
+	return nil
 }
 
-func (l *layer) WriteTo(w io.Writer) (int64, error) {
-	sz, err := l.weights.MarshalBinaryTo(w)
-	return int64(sz), err
+// Network is structure that represents a neural network
+type Network struct {
+	layers    []Layer
+	optimizer optimizer.Optimizer
+	states    [][]optimizer.State // Optimizer state for the parameters of each layer, see Layer.Parameters
+	rng       *rand.Rand
+	mode      Mode
+
+	clipping     Clipping
+	gradientNorm float64 // Norm of the gradient of the last training step before clipping
+
+	sequenceLength int // Number of steps of the last call of ForwardSequence, see BackpropSequence
+
+	scratch sync.Pool // Output buffers of all layers for Predict
 }
 
-var _ io.WriterTo = &layer{}
+// Mode selects whether a network is being trained or used for inference.
+type Mode int
+
+const (
+	// Inference disables features that are only used during training, like dropout. New networks start out in
+	// this mode.
+	Inference Mode = iota
+
+	// Training enables dropout in Train, TrainBatch and TrainSequence. Forward, ForwardE and ForwardSequence
+	// never apply dropout, regardless of the mode.
+	Training
+)
+
+// LayerConf represents a configuration for one single layer in the network. Apart from the first one, which only
+// describes the inputs of the network, each configuration describes a Dense layer.
+//
+// If Bias is set, every neuron of the layer gets a trainable bias term that is added to its weighted inputs
+// before the activation is applied. Bias is ignored for the first layer.
+//
+// Initializer chooses the initial weights of the layer. If it is nil, He initialization is used for rectifying
+// activations like LeakyReLU, ELU and GELU, LeCun initialization for SELU, and Xavier initialization for
+// everything else. Initializer is ignored for the first layer.
+//
+// L1 and L2 add the penalty L1*sum(|w|) + L2*sum(w^2) over the weights w of the layer to the loss. The penalty
+// is included in the loss reported by Train and TrainBatch, and its gradient is passed to the optimizer along
+// with the gradient of the loss. WeightDecay shrinks the weights by a factor of 1 - learningRate*WeightDecay
+// before every update, independently of the optimizer. Biases are never regularized. All three are ignored for
+// the first layer.
+//
+// If Activation is trainable, see activation.Trainable, each layer learns its own activation parameters. They
+// are updated by the optimizer along with the weights and included in snapshots.
+//
+// Dropout is the probability with which each output of the layer is set to zero by Train, TrainBatch and
+// TrainSequence while the network is in Training mode. The remaining outputs are scaled by 1/(1-Dropout), so no rescaling is needed for inference.
+// Dropout is ignored for the first layer and not allowed for the output layer.
+type LayerConf struct {
+	Inputs      int
+	Activation  activation.Activation
+	Bias        bool
+	Initializer initializer.Initializer
+
+	L1          float64
+	L2          float64
+	WeightDecay float64
+
+	Dropout float64
+}
+
+// NewNetwork creates a new neural network with the desired layer configurations.
+// The activation is ignored for the first layer and has to be set to nil.
+//
+// The following creates a fully connected 2x3x1 network with sigmoid activation between all layers:
+//
+//	config := []LayerConf{
+//		LayerConf{Inputs: 2, Activation: nil},
+//		LayerConf{Inputs: 3, Activation: SigmoidActivation{}},
+//		LayerConf{Inputs: 1, Activation: SigmoidActivation{}},
+//	}
+//	net := network.NewNetwork(config)
+//
+// By default, the network draws its random numbers from a source seeded by the global math/rand source. Pass
+// WithSeed or WithRand to make it reproducible.
+func New(layerConfigs []LayerConf, opts ...Option) (*Network, error) {
+	if layerConfigs[0].Activation != nil {
+		return nil, errors.New(`First activation has to be nil!`)
+	}
+
+	n := newNetwork(opts)
+
+	var layers []Layer
+
+	for idx, conf := range layerConfigs[1:] {
+		numInputs := layerConfigs[idx].Inputs
 
-func (l *layer) encodedSize() (int, error) {
-	buf, err := l.weights.MarshalBinary()
+		if conf.Dropout > 0 && idx == len(layerConfigs)-2 {
+			return nil, errors.New("dropout is not allowed for the output layer")
+		}
+
+		layer, err := NewDense(numInputs, conf, n.rng)
+		if err != nil {
+			return nil, fmt.Errorf("layer %d: %w", idx+1, err)
+		}
+
+		layers = append(layers, layer)
+	}
+
+	err := n.setLayers(layers)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	return len(buf), nil
+	return n, nil
 }
 
-func (l *layer) ReadFrom(r io.Reader) (int64, error) {
-	var weights mat.Dense
+// NewFromLayers creates a network from the given layers, which may be of any type implementing Layer. The
+// outputs of each layer are passed as inputs to the next one, so their dimensions have to match. The network
+// takes ownership of the layers.
+//
+// The options are the same as for New. Since the layers are already initialized, the random source is only used
+// for training, for example for dropout and shuffling.
+func NewFromLayers(layers []Layer, opts ...Option) (*Network, error) {
+	n := newNetwork(opts)
 
-	sz, err := weights.UnmarshalBinaryFrom(r)
+	err := n.setLayers(layers)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	l.weights = &weights
-
-	return int64(sz), nil
+	return n, nil
 }
 
-var _ io.ReaderFrom = &layer{}
+// newNetwork creates an empty network with the given options applied.
+func newNetwork(opts []Option) *Network {
+	n := &Network{
+		optimizer: optimizer.SGD{},
+	}
 
-func (l *layer) computeGradient(error *mat.VecDense) *mat.VecDense {
-	for idx := 0; idx < error.Len(); idx++ {
-		// TODO: See if this can be unified
-		e := error.AtVec(idx)
-		l.delta.SetVec(idx, e*l.activation.Backward(l.output.AtVec(idx)))
+	for _, opt := range opts {
+		opt(n)
 	}
 
-	var res mat.Dense
-	res.Mul(mat.Matrix(l.delta).T(), l.weights)
+	if n.rng == nil {
+		n.rng = rand.New(rand.NewSource(rand.Int63()))
+	}
 
-	_, c := l.weights.Dims()
+	return n
+}
 
-	resVec := mat.NewVecDense(c, nil)
-	resVec.CopyVec(res.RowView(0))
+// setLayers makes n use the given layers after checking that their dimensions match.
+func (n *Network) setLayers(layers []Layer) error {
+	if len(layers) == 0 {
+		return errors.New("network has no layers")
+	}
 
-	return resVec
-}
+	for idx := 1; idx < len(layers); idx++ {
+		_, outputs := layers[idx-1].Dims()
+		inputs, _ := layers[idx].Dims()
 
-func (l *layer) forward(inputs *mat.VecDense) *mat.VecDense {
-	for idx, val := range inputs.RawVector().Data {
-		if math.IsNaN(val) {
-			panic(fmt.Sprintf("NaN layer input at %v", idx))
+		if inputs != outputs {
+			return fmt.Errorf("layer %d has %d inputs, but layer %d has %d outputs", idx, inputs, idx-1, outputs)
 		}
 	}
 
-	l.output.MulVec(l.weights, inputs)
+	n.layers = layers
+	n.SetOptimizer(n.optimizer)
 
-	for idx := 0; idx < l.output.Len(); idx++ {
-		f := l.activation.Forward(l.output.AtVec(idx))
-		if math.IsNaN(f) {
-			panic(fmt.Sprintf("NaN layer output, was %v before activation", l.output.AtVec(idx)))
-		}
+	return nil
+}
+
+// Layers returns the layers of n, starting with the one that gets the inputs of the network.
+func (n *Network) Layers() []Layer {
+	return append([]Layer{}, n.layers...)
+}
+
+// Option configures a network created by New or Load.
+type Option func(*Network)
+
+// WithRand makes the network draw all its random numbers from rng, for example for initializing weights. The
+// network takes ownership of rng, it must not be used elsewhere afterwards.
+func WithRand(rng *rand.Rand) Option {
+	return func(n *Network) {
+		n.rng = rng
+	}
+}
+
+// WithSeed makes the network draw all its random numbers from a source with the given seed. Two networks
+// created with the same configuration and seed are identical. Clones don't inherit the seed, see Network.Clone.
+func WithSeed(seed int64) Option {
+	return WithRand(rand.New(rand.NewSource(seed)))
+}
 
-		l.output.SetVec(idx, f)
+// SetOptimizer selects the optimizer used for training n. New networks use plain stochastic gradient
+// descent. Setting an optimizer discards the state of the previous one.
+//
+// The optimizer state is included in snapshots created with WriteTo. To resume training, set the optimizer
+// before restoring the snapshot with ReadFrom.
+func (n *Network) SetOptimizer(o optimizer.Optimizer) {
+	n.optimizer = o
+	n.states = make([][]optimizer.State, len(n.layers))
+
+	for idx := range n.layers {
+		n.resetOptimizerState(idx)
 	}
+}
 
-	return l.output
+// resetOptimizerState discards the optimizer state of layer idx.
+func (n *Network) resetOptimizerState(idx int) {
+	n.states[idx] = nil
+	for _, p := range n.layers[idx].Parameters() {
+		n.states[idx] = append(n.states[idx], n.optimizer.NewState(len(p)))
+	}
 }
 
-func (l *layer) updateWeights(inputs *mat.VecDense, learningRate float64) {
-	alpha := learningRate
+// SetMode switches n to the given mode.
+func (n *Network) SetMode(m Mode) {
+	n.mode = m
+}
 
-	// Compute: Weights = alpha * Input^T * Delta + 1 * Weights
-	l.scratch.Outer(alpha, l.delta, inputs)
-	l.weights.Add(l.weights, l.scratch)
+// Mode returns the current mode of n.
+func (n *Network) Mode() Mode {
+	return n.mode
 }
 
-// Network is structure that represents an unbiased neural network
-type Network struct {
-	layers []*layer
+// Clipping limits the gradients that are passed to the optimizer to keep single training steps from
+// destroying the network. A limit of zero disables the respective kind of clipping. If several limits are set,
+// they are applied in the order of the fields. Clipping doesn't affect the gradients of the L1 and L2 penalties.
+type Clipping struct {
+	Value      float64 // Limit each element of the gradient to [-Value, Value]
+	LayerNorm  float64 // Scale down the gradient of each layer whose L2 norm exceeds LayerNorm
+	GlobalNorm float64 // Scale down the gradients of all layers if their joint L2 norm exceeds GlobalNorm
 }
 
-// LayerConf represents a configuration for one single layer in the network
-type LayerConf struct {
-	Inputs     int
-	Activation activation.Activation
+// SetClipping sets the gradient clipping for all following training steps of n. New networks don't clip their
+// gradients.
+func (n *Network) SetClipping(c Clipping) {
+	n.clipping = c
 }
 
-// NewNetwork creates a new neural network with the desired layer configurations.
-// The activation is ignored for the first layer and has to be set to nil.
-//
-// The following creates a fully connected 2x3x1 network with sigmoid activation between all layers:
-//
-//  config := []LayerConf{
-//    LayerConf{Inputs: 2, Activation: nil},
-//    LayerConf{Inputs: 3, Activation: SigmoidActivation{}},
-//    LayerConf{Inputs: 1, Activation: SigmoidActivation{}},
-//  }
-//  net := network.NewNetwork(config)
-func New(layerConfigs []LayerConf) (*Network, error) {
-	if layerConfigs[0].Activation != nil {
-		return nil, errors.New(`First activation has to be nil!`)
+// GradientNorm returns the L2 norm of the gradients of all trainable parameters computed by the last call of
+// Backprop, BackpropSequence, Train, TrainBatch or TrainSequence, before clipping.
+func (n *Network) GradientNorm() float64 {
+	return n.gradientNorm
+}
+
+// applyUpdates clips the steps computed for all layers of n and lets the optimizer apply them. Regularization is
+// applied after clipping.
+func (n *Network) applyUpdates(learningRate float64) {
+	n.gradientNorm = math.Sqrt(n.squaredStepNorm())
+
+	c := n.clipping
+
+	if c.Value > 0 {
+		for _, l := range n.layers {
+			for _, step := range l.Steps() {
+				for idx, s := range step {
+					step[idx] = math.Max(-c.Value, math.Min(c.Value, s))
+				}
+			}
+		}
 	}
 
-	layers := []*layer{}
+	if c.LayerNorm > 0 {
+		for _, l := range n.layers {
+			norm := math.Sqrt(squaredNorm(l.Steps()))
+			if norm > c.LayerNorm {
+				scaleSteps(l.Steps(), c.LayerNorm/norm)
+			}
+		}
+	}
 
-	for idx, conf := range layerConfigs[1:] {
-		numOutputs := conf.Inputs
-		numInputs := layerConfigs[idx].Inputs
+	if c.GlobalNorm > 0 {
+		norm := math.Sqrt(n.squaredStepNorm())
+		if norm > c.GlobalNorm {
+			for _, l := range n.layers {
+				scaleSteps(l.Steps(), c.GlobalNorm/norm)
+			}
+		}
+	}
 
-		layer := newLayer(numInputs, numOutputs, conf.Activation)
-		layers = append(layers, &layer)
+	for idx, l := range n.layers {
+		if r, ok := l.(regularizer); ok {
+			r.regularize(learningRate)
+		}
+
+		steps := l.Steps()
+		for i, params := range l.Parameters() {
+			n.states[idx][i].Update(params, steps[i], learningRate)
+		}
 	}
+}
+
+// squaredStepNorm returns the squared L2 norm of the steps computed for all layers of n.
+func (n *Network) squaredStepNorm() float64 {
+	res := float64(0)
+	for _, l := range n.layers {
+		res += squaredNorm(l.Steps())
+	}
+	return res
+}
 
-	return &Network{
-		layers: layers,
-	}, nil
+// squaredNorm returns the squared L2 norm of all given vectors.
+func squaredNorm(vs [][]float64) float64 {
+	res := float64(0)
+	for _, v := range vs {
+		res += floats.Dot(v, v)
+	}
+	return res
+}
+
+// scaleSteps multiplies all given steps by f.
+func scaleSteps(steps [][]float64, f float64) {
+	for _, s := range steps {
+		floats.Scale(f, s)
+	}
 }
 
+// Clone returns a deep copy of n. The clone isn't seeded: it gets its own random source, seeded from the global
+// one, so that cloning doesn't change the random numbers drawn by n. Dropout and shuffling in a clone are
+// therefore not reproducible, even if n was created with WithSeed.
 func (n *Network) Clone() *Network {
-	clone := Network{}
+	clone := Network{
+		optimizer: n.optimizer,
+		rng:       rand.New(rand.NewSource(rand.Int63())),
+		mode:      n.mode,
 
-	for _, l := range n.layers {
-		clone.layers = append(clone.layers, l.clone())
+		clipping:     n.clipping,
+		gradientNorm: n.gradientNorm,
+
+		sequenceLength: n.sequenceLength,
+	}
+
+	for idx, l := range n.layers {
+		clone.layers = append(clone.layers, l.Clone())
+
+		var states []optimizer.State
+		for _, s := range n.states[idx] {
+			states = append(states, s.Clone())
+		}
+		clone.states = append(clone.states, states)
 	}
 
 	return &clone
 }
 
+// penalty returns the sum of the L1 and L2 penalties of all layers of n.
+func (n *Network) penalty() float64 {
+	res := float64(0)
+	for _, l := range n.layers {
+		if r, ok := l.(regularizer); ok {
+			res += r.penalty()
+		}
+	}
+	return res
+}
+
+// restoreClone makes n take over the layers and optimizer of c, which must be a clone of n that isn't used
+// anymore. The random source of n is kept.
+func (n *Network) restoreClone(c *Network) {
+	n.layers = c.layers
+	n.optimizer = c.optimizer
+	n.states = c.states
+}
+
 type writeCounter struct {
 	w io.Writer
 	c int64
@@ -192,36 +448,84 @@ func (w *writeCounter) Write(data []byte) (int, error) {
 }
 
 // WriteTo writes a snapshot of n to w.
+//
+// The snapshot is a tar archive. Its first entry is a manifest that describes the architecture of n, followed
+// by the weights of each layer, the parameters of trainable activations and the state of the optimizer.
 func (n *Network) WriteTo(w io.Writer) (int64, error) {
 	wc := writeCounter{w: w}
 	tw := tar.NewWriter(&wc)
 
+	m, err := n.manifest()
+	if err != nil {
+		return wc.c, fmt.Errorf("creating manifest: %w", err)
+	}
+
+	buf, err := json.Marshal(m)
+	if err != nil {
+		return wc.c, fmt.Errorf("encoding manifest: %w", err)
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name: "manifest",
+		Size: int64(len(buf)),
+	})
+	if err != nil {
+		return wc.c, fmt.Errorf("creating entry for manifest: %w", err)
+	}
+
+	_, err = tw.Write(buf)
+	if err != nil {
+		return wc.c, fmt.Errorf("persisting manifest: %w", err)
+	}
+
 	for idx, layer := range n.layers {
-		sz, err := layer.encodedSize()
+		err = writeEntry(tw, "layer-"+strconv.Itoa(idx), func(w io.Writer) error {
+			_, err := layer.WriteTo(w)
+			return err
+		})
 		if err != nil {
-			return wc.c, fmt.Errorf("getting layer size for %d: %w", idx, err)
+			return wc.c, fmt.Errorf("persisting layer %d: %w", idx, err)
+		}
+
+		d, ok := layer.(*Dense)
+		if !ok || d.activationParameters() == nil {
+			continue
 		}
 
-		err = tw.WriteHeader(&tar.Header{
-			Name: "layer-" + strconv.Itoa(idx),
-			Size: int64(sz),
+		err = writeEntry(tw, "activation-"+strconv.Itoa(idx), func(w io.Writer) error {
+			_, err := d.writeActivation(w)
+			return err
 		})
 		if err != nil {
-			return wc.c, fmt.Errorf("creating entry for layer %d: %w", idx, err)
+			return wc.c, fmt.Errorf("persisting activation of layer %d: %w", idx, err)
 		}
+	}
 
-		_, err = layer.WriteTo(tw)
-		if err != nil {
-			return wc.c, fmt.Errorf("persisting layer %d: %w", idx, err)
+	for idx := range n.layers {
+		var buf bytes.Buffer
+
+		for _, s := range n.states[idx] {
+			_, err := s.WriteTo(&buf)
+			if err != nil {
+				return wc.c, fmt.Errorf("persisting optimizer state for layer %d: %w", idx, err)
+			}
 		}
 
-		err = tw.Flush()
+		if buf.Len() == 0 {
+			// Stateless optimizer, nothing to persist
+			continue
+		}
+
+		err = writeEntry(tw, "optimizer-"+strconv.Itoa(idx), func(w io.Writer) error {
+			_, err := buf.WriteTo(w)
+			return err
+		})
 		if err != nil {
-			return wc.c, fmt.Errorf("flushing layer %d: %w", idx, err)
+			return wc.c, fmt.Errorf("persisting optimizer state for layer %d: %w", idx, err)
 		}
 	}
 
-	err := tw.Close()
+	err = tw.Close()
 	if err != nil {
 		return wc.c, err
 	}
@@ -231,6 +535,28 @@ func (n *Network) WriteTo(w io.Writer) (int64, error) {
 
 var _ io.WriterTo = &Network{}
 
+// writeEntry adds an entry with the given name to tw, whose content is written by write. The content is buffered
+// in memory, since the size of the entry has to be known in advance.
+func writeEntry(tw *tar.Writer, name string, write func(io.Writer) error) error {
+	var buf bytes.Buffer
+
+	err := write(&buf)
+	if err != nil {
+		return err
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name: name,
+		Size: int64(buf.Len()),
+	})
+	if err != nil {
+		return fmt.Errorf("creating entry %s: %w", name, err)
+	}
+
+	_, err = buf.WriteTo(tw)
+	return err
+}
+
 type readCounter struct {
 	r io.Reader
 	c int64
@@ -242,64 +568,280 @@ func (r *readCounter) Read(data []byte) (int, error) {
 	return sz, err
 }
 
-// ReadFrom restores a network that was previously saved with `Snapshot`.
+// ReadFrom restores a network that was previously saved with WriteTo.
+//
+// The architecture described by the manifest of the snapshot has to match the one of n, otherwise an error
+// wrapping ErrArchitectureMismatch is returned. Snapshots from before manifests were introduced can still be
+// restored, but only the dimensions of the weights are validated for them.
 //
-// The result is undefined if the network architecture differs. You will likely get panics or weird errors
-// when using or training a network that was restored from different parameters.
+// If the snapshot contains optimizer state, it is restored into the optimizer currently set for n. This fails if
+// the snapshot was taken with a different optimizer.
 //
-// TODO: Persist network architecture and validate on restore.
+// n is left untouched if restoring fails. Otherwise, its layers are replaced by restored copies, so layers returned
+// by Layers before aren't updated.
 func (n *Network) ReadFrom(r io.Reader) (int64, error) {
 	rc := readCounter{r: r}
 	tr := tar.NewReader(&rc)
 
-	for idx, layer := range n.layers {
+	// Restore into a copy, so that errors in later entries don't leave n partially restored
+	restored := n.Clone()
+
+	err := restored.restore(tr, false)
+	if err != nil {
+		return rc.c, err
+	}
+
+	n.layers = restored.layers
+	n.states = restored.states
+
+	return rc.c, nil
+}
+
+// Load creates a network from a snapshot that was previously saved with WriteTo. Unlike ReadFrom, it doesn't
+// need a network with the same architecture, which is rebuilt from the manifest of the snapshot instead.
+//
+// Optimizer state is not restored. To resume training, create a network with New, set the optimizer and use
+// ReadFrom. The options are passed on to New.
+//
+// Only networks made of Dense layers can be rebuilt, since the manifest doesn't describe other layers in enough
+// detail.
+func Load(r io.Reader, opts ...Option) (*Network, error) {
+	tr := tar.NewReader(r)
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("reading archive header: %w", err)
+	}
+	if hdr.Name != "manifest" {
+		return nil, fmt.Errorf("snapshot has no manifest, got archive entry %q", hdr.Name)
+	}
+
+	m, err := readManifest(tr)
+	if err != nil {
+		return nil, err
+	}
+
+	confs, err := m.layerConfs()
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := New(confs, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = n.restore(tr, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+func readManifest(r io.Reader) (manifest, error) {
+	var m manifest
+
+	err := json.NewDecoder(r).Decode(&m)
+	if err != nil {
+		return manifest{}, fmt.Errorf("decoding manifest: %w", err)
+	}
+
+	return m, nil
+}
+
+// restore restores the entries of a snapshot from tr into n. If ignoreOptimizer is set, optimizer state is
+// skipped.
+func (n *Network) restore(tr *tar.Reader, ignoreOptimizer bool) error {
+	for {
 		hdr, err := tr.Next()
 		if errors.Is(err, io.EOF) {
 			break
 		}
 		if err != nil {
-			return rc.c, fmt.Errorf("skipping archive header: %w", err)
+			return fmt.Errorf("skipping archive header: %w", err)
 		}
 
-		if hdr.Name != "layer-"+strconv.Itoa(idx) {
-			return rc.c, fmt.Errorf("unexpected archive entry %q, expected one for layer %d", hdr.Name, idx)
+		if hdr.Name == "manifest" {
+			m, err := readManifest(tr)
+			if err != nil {
+				return err
+			}
+
+			expected, err := n.manifest()
+			if err != nil {
+				return err
+			}
+
+			err = m.validate(expected)
+			if err != nil {
+				return err
+			}
+
+			continue
 		}
 
-		_, err = layer.ReadFrom(tr)
-		if err != nil {
-			return rc.c, fmt.Errorf("restoring layer %d: %w", idx, err)
+		idx := strings.LastIndexByte(hdr.Name, '-')
+		if idx < 0 {
+			return fmt.Errorf("unexpected archive entry %q", hdr.Name)
+		}
+
+		layerIdx, err := strconv.Atoi(hdr.Name[idx+1:])
+		if err != nil || layerIdx < 0 || layerIdx >= len(n.layers) {
+			return fmt.Errorf("%w: unexpected archive entry %q, network has %d layers", ErrArchitectureMismatch, hdr.Name, len(n.layers))
+		}
+
+		layer := n.layers[layerIdx]
+
+		switch hdr.Name[:idx] {
+		case "layer":
+			_, err = layer.ReadFrom(tr)
+
+			// The optimizer state belongs to the previous parameters
+			n.resetOptimizerState(layerIdx)
+
+			if err != nil {
+				return fmt.Errorf("restoring layer %d: %w", layerIdx, err)
+			}
+		case "activation":
+			d, ok := layer.(*Dense)
+			if !ok {
+				return fmt.Errorf("%w: unexpected archive entry %q, layer %d has no activation", ErrArchitectureMismatch, hdr.Name, layerIdx)
+			}
+
+			err = d.readActivation(tr)
+			if err != nil {
+				return fmt.Errorf("restoring activation of layer %d: %w", layerIdx, err)
+			}
+		case "optimizer":
+			if ignoreOptimizer {
+				continue
+			}
+
+			var sz int64
+			for _, s := range n.states[layerIdx] {
+				ssz, err := s.ReadFrom(tr)
+				sz += ssz
+				if err != nil {
+					return fmt.Errorf("restoring optimizer state for layer %d: %w", layerIdx, err)
+				}
+			}
+
+			// Optimizers without state, like SGD, read nothing, so leftovers mean that the snapshot was taken with
+			// a different optimizer
+			if sz != hdr.Size {
+				return fmt.Errorf("restoring optimizer state for layer %d: read %d of %d bytes, snapshot was taken with a different optimizer", layerIdx, sz, hdr.Size)
+			}
+		default:
+			return fmt.Errorf("unexpected archive entry %q", hdr.Name)
 		}
 	}
 
-	return rc.c, nil
+	return nil
 }
 
 var _ io.ReaderFrom = &Network{}
 
 // Forward performs a forward pass through the network for the given inputs.
 // The returned value is the output of the uppermost layer of neurons.
+//
+// Forward panics if the number of inputs doesn't match the network, or if a NaN or infinite value shows up during
+// the forward pass. Use ForwardE to handle these cases gracefully.
+//
+// Forward keeps the activations of all layers for Backprop, so it must not be called concurrently. Use Predict
+// for concurrent inference. Recurrent layers advance their state by one step, use ForwardSequence to process a
+// whole sequence at once.
 func (n *Network) Forward(inputs []float64) []float64 {
-	output := mat.NewVecDense(len(inputs), inputs)
+	res, err := n.ForwardE(inputs)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return res
+}
+
+// ForwardE is like Forward, but returns an error instead of panicking if the number of inputs doesn't match the
+// network, and a *NonFiniteError if a NaN or infinite value shows up in the inputs or in the output of any layer.
+// This allows callers to recover, for example by restoring a snapshot or lowering the learning rate.
+func (n *Network) ForwardE(inputs []float64) ([]float64, error) {
+	return n.forward(inputs, false)
+}
+
+// forward implements ForwardE. Dropout is only applied if training is set.
+func (n *Network) forward(inputs []float64, training bool) ([]float64, error) {
+	numInputs, _ := n.layers[0].Dims()
+	if len(inputs) != numInputs {
+		return nil, fmt.Errorf("input has length %d, expected %d", len(inputs), numInputs)
+	}
+
+	err := checkInputs(inputs, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	output := inputs
 
 	for layerIdx, layer := range n.layers {
-		for outIdx, o := range output.RawVector().Data {
-			if math.IsNaN(o) {
-				panic(fmt.Sprintf("NaN layer output at %d (layer %d)", outIdx, layerIdx))
-			}
+		output, err = layer.Forward(output, training, n.rng)
+		if err != nil {
+			return nil, withLayer(err, layerIdx)
 		}
+	}
+
+	res := make([]float64, len(output))
+	copy(res, output)
 
-		output = layer.forward(output)
+	return res, nil
+}
+
+// withLayer sets the index of the layer in err if it is a *NonFiniteError, and returns err.
+func withLayer(err error, idx int) error {
+	var nf *NonFiniteError
+	if errors.As(err, &nf) {
+		nf.Layer = idx
 	}
+	return err
+}
 
-	res := []float64{}
-	for idx := 0; idx < output.Len(); idx++ {
-		if math.IsNaN(output.AtVec(idx)) {
-			panic("NaN output value")
+// Predict performs a forward pass like ForwardE, but keeps the activations of the layers in scratch buffers
+// private to the call. It is safe to call Predict from multiple goroutines on the same network, as long as
+// the network isn't trained or restored at the same time. Recurrent layers compute a single step from their current
+// state without changing it.
+func (n *Network) Predict(inputs []float64) ([]float64, error) {
+	numInputs, _ := n.layers[0].Dims()
+	if len(inputs) != numInputs {
+		return nil, fmt.Errorf("input has length %d, expected %d", len(inputs), numInputs)
+	}
+
+	err := checkInputs(inputs, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, ok := n.scratch.Get().([][]float64)
+	if !ok {
+		for _, layer := range n.layers {
+			_, size := layer.Dims()
+			outputs = append(outputs, make([]float64, size))
 		}
-		res = append(res, output.AtVec(idx))
 	}
+	defer n.scratch.Put(outputs)
 
-	return res
+	output := inputs
+
+	for layerIdx, layer := range n.layers {
+		err = layer.Predict(outputs[layerIdx], output)
+		if err != nil {
+			return nil, withLayer(err, layerIdx)
+		}
+
+		output = outputs[layerIdx]
+	}
+
+	res := make([]float64, len(output))
+	copy(res, output)
+
+	return res, nil
 }
 
 // Backprop performs one pass of back propagation through the network for the given input, error and learning rate.
@@ -307,22 +849,250 @@ func (n *Network) Forward(inputs []float64) []float64 {
 // Before Backprop is called, you need to do one forward pass for the input with Forward. A typical usage
 // looks like this:
 //
-//  input := []float64{0, 1.0, 2.0}
-//  target := []float64{0, 1}
-//  output := net.Forward(input)
-//  error := Error(output, target)
-//  net.Backprop(input, error, 0.1) // Perform back propagation with learning rate 0.1
+//	input := []float64{0, 1.0, 2.0}
+//	target := []float64{0, 1}
+//	output := net.Forward(input)
+//	error := Error(output, target)
+//	net.Backprop(input, error, 0.1) // Perform back propagation with learning rate 0.1
+//
+// The inputs argument is unused, since the layers keep the inputs of the last forward pass. It is only kept so that
+// existing callers don't break.
 func (n *Network) Backprop(inputs, error []float64, learningRate float64) {
-	localError := mat.NewVecDense(len(error), error)
+	n.backprop(error, false, learningRate)
+}
+
+// backprop implements Backprop. If fused is set, error holds the deltas of the output layer instead of the error
+// at its outputs, see outputDeltas.
+func (n *Network) backprop(error []float64, fused bool, learningRate float64) {
+	n.computeSteps(error, fused)
+	n.applyUpdates(learningRate)
+}
+
+// computeSteps propagates error backwards through n and computes the steps for all layers without applying
+// them. The meaning of fused is the same as for backprop.
+func (n *Network) computeSteps(error []float64, fused bool) {
 	for idx := len(n.layers) - 1; idx >= 0; idx-- {
-		localError = n.layers[idx].computeGradient(localError)
+		layer := n.layers[idx]
+
+		if fused && idx == len(n.layers)-1 {
+			error = layer.(*Dense).backwardDeltas(error)
+			continue
+		}
+
+		error = layer.Backward(error)
 	}
+}
 
-	localInput := mat.NewVecDense(len(inputs), inputs)
+// Gradient computes the gradient of the loss l for a single sample with respect to the parameters of each layer,
+// in the layout used by Parameters. Like Train, it performs a forward pass and propagates the error backwards,
+// but it doesn't apply dropout and leaves the parameters of n untouched. Like Forward, it advances the state of
+// recurrent layers by one step. The L1 and L2 penalties are not included in the gradient.
+//
+// Gradient is meant for verifying the gradients computed by the network, see the gradcheck package.
+func (n *Network) Gradient(inputs, targets []float64, l loss.Loss) ([][]float64, error) {
+	output, err := n.forward(inputs, false)
+	if err != nil {
+		return nil, err
+	}
+
+	deltas, fused := n.outputDeltas(l, output, targets)
+	if !fused {
+		deltas = l.Error(output, targets)
+	}
+
+	n.computeSteps(deltas, fused)
+
+	var res [][]float64
+	for _, layer := range n.layers {
+		grad := flatten(layer.Steps())
+
+		// The steps point in the direction of the negative gradient
+		floats.Scale(-1, grad)
+
+		res = append(res, grad)
+	}
+
+	return res, nil
+}
+
+// Parameters returns a copy of the trainable parameters of each layer of n, with the slices returned by the
+// Parameters method of each layer concatenated. For Dense layers, the weights come first, one row per neuron,
+// followed by the biases if the layer has any and the parameters of its activation if it is trainable.
+func (n *Network) Parameters() [][]float64 {
+	var res [][]float64
 	for _, layer := range n.layers {
-		layer.updateWeights(localInput, learningRate)
-		localInput = layer.output
+		res = append(res, flatten(layer.Parameters()))
+	}
+	return res
+}
+
+// SetParameters replaces the trainable parameters of each layer of n with the given ones, which have to be in
+// the layout returned by Parameters.
+func (n *Network) SetParameters(params [][]float64) error {
+	if len(params) != len(n.layers) {
+		return fmt.Errorf("got parameters for %d layers, expected %d", len(params), len(n.layers))
+	}
+
+	for idx, layer := range n.layers {
+		p := params[idx]
+
+		dst := layer.Parameters()
+		if size := len(flatten(dst)); len(p) != size {
+			return fmt.Errorf("layer %d: got %d parameters, expected %d", idx, len(p), size)
+		}
+
+		for _, d := range dst {
+			p = p[copy(d, p):]
+		}
+	}
+
+	return nil
+}
+
+// flatten returns the concatenation of vs.
+func flatten(vs [][]float64) []float64 {
+	res := []float64{}
+	for _, v := range vs {
+		res = append(res, v...)
+	}
+	return res
+}
+
+// outputDeltas computes the deltas of the output layer directly if the combination of its activation and the
+// loss l allows a shortcut that is more stable than going through the derivatives of both. It returns false
+// otherwise.
+//
+// The only such combination right now is a Softmax activation with categorical cross-entropy, where the deltas
+// are simply the difference between targets and outputs.
+func (n *Network) outputDeltas(l loss.Loss, outputs, targets []float64) ([]float64, bool) {
+	d, dense := n.layers[len(n.layers)-1].(*Dense)
+	if !dense {
+		return nil, false
+	}
+
+	_, softmax := d.activation.(activation.Softmax)
+	_, crossEntropy := l.(loss.CategoricalCrossEntropy)
+
+	if !softmax || !crossEntropy {
+		return nil, false
+	}
+
+	sum := floats.Sum(targets)
+
+	res := make([]float64, len(targets))
+	for idx, t := range targets {
+		res[idx] = t - sum*outputs[idx]
+	}
+
+	return res, true
+}
+
+// Train performs one training step on a single sample: a forward pass, followed by back propagation of the
+// error computed by l. It returns the loss of the output computed before the weights were updated, including
+// the L1 and L2 penalties of the weights.
+//
+// If the output layer uses a Softmax activation and l is categorical cross-entropy, the gradient of both is
+// computed in one numerically stable step.
+//
+// If a NaN or infinite value shows up during the forward pass, Train returns a *NonFiniteError and leaves the
+// weights untouched.
+func (n *Network) Train(inputs, targets []float64, l loss.Loss, learningRate float64) (float64, error) {
+	output, err := n.forward(inputs, n.mode == Training)
+	if err != nil {
+		return 0, err
+	}
+
+	res := l.Value(output, targets) + n.penalty()
+
+	if deltas, ok := n.outputDeltas(l, output, targets); ok {
+		n.backprop(deltas, true, learningRate)
+	} else {
+		n.Backprop(inputs, l.Error(output, targets), learningRate)
+	}
+
+	return res, nil
+}
+
+// TrainBatch performs one training step on a batch of samples. The whole batch is passed through the network
+// at once, and the gradients of the loss l for all samples are averaged into a single update with the given
+// learning rate.
+//
+// It returns the mean loss over the batch plus the L1 and L2 penalties of the weights, computed before the
+// update was applied. Unlike Forward and Backprop, TrainBatch doesn't modify the state used by Backprop, so the
+// two can be mixed freely.
+//
+// Like Train, TrainBatch fuses the gradients of a Softmax output layer and categorical cross-entropy, and
+// returns a *NonFiniteError if a NaN or infinite value shows up during the forward pass.
+func (n *Network) TrainBatch(inputs, targets [][]float64, l loss.Loss, learningRate float64) (float64, error) {
+	if len(inputs) == 0 {
+		return 0, errors.New("empty batch")
+	}
+	if len(inputs) != len(targets) {
+		return 0, fmt.Errorf("got %d inputs, but %d targets", len(inputs), len(targets))
+	}
+
+	numInputs, _ := n.layers[0].Dims()
+	_, numOutputs := n.layers[len(n.layers)-1].Dims()
+
+	input := mat.NewDense(len(inputs), numInputs, nil)
+	for idx := range inputs {
+		if len(inputs[idx]) != numInputs {
+			return 0, fmt.Errorf("input %d has length %d, expected %d", idx, len(inputs[idx]), numInputs)
+		}
+		if len(targets[idx]) != numOutputs {
+			return 0, fmt.Errorf("target %d has length %d, expected %d", idx, len(targets[idx]), numOutputs)
+		}
+
+		err := checkInputs(inputs[idx], idx)
+		if err != nil {
+			return 0, err
+		}
+
+		input.SetRow(idx, inputs[idx])
+	}
+
+	output := input
+	for layerIdx, layer := range n.layers {
+		var err error
+
+		output, err = layer.ForwardBatch(output, n.mode == Training, n.rng)
+		if err != nil {
+			return 0, withLayer(err, layerIdx)
+		}
+	}
+
+	var fused bool
+
+	meanLoss := float64(0)
+	errs := mat.NewDense(len(inputs), numOutputs, nil)
+	for idx := range inputs {
+		o := output.RawRowView(idx)
+		meanLoss += l.Value(o, targets[idx])
+
+		var e []float64
+		e, fused = n.outputDeltas(l, o, targets[idx])
+		if !fused {
+			e = l.Error(o, targets[idx])
+		}
+		errs.SetRow(idx, e)
 	}
+	meanLoss /= float64(len(inputs))
+	meanLoss += n.penalty()
+
+	for idx := len(n.layers) - 1; idx >= 0; idx-- {
+		layer := n.layers[idx]
+
+		if fused && idx == len(n.layers)-1 {
+			errs = layer.(*Dense).backwardBatchDeltas(errs)
+			continue
+		}
+
+		errs = layer.BackwardBatch(errs)
+	}
+
+	n.applyUpdates(learningRate)
+
+	return meanLoss, nil
 }
 
 // Error computes the error of the given outputs when compared to the given targets.
@@ -2,20 +2,60 @@ package network
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"math"
+	"math/rand"
+	"sync"
 	"testing"
 
 	"github.com/farhaven/nn-go/activation"
+	"github.com/farhaven/nn-go/initializer"
+	"github.com/farhaven/nn-go/loss"
+	"github.com/farhaven/nn-go/optimizer"
+	"gonum.org/v1/gonum/floats"
 	"gonum.org/v1/gonum/mat"
 )
 
+// denseLayer returns layer idx of n, which has to be a Dense layer.
+func denseLayer(n *Network, idx int) *Dense {
+	return n.layers[idx].(*Dense)
+}
+
+func newDense(t *testing.T, inputs int, conf LayerConf, rng *rand.Rand) *Dense {
+	t.Helper()
+
+	l, err := NewDense(inputs, conf, rng)
+	if err != nil {
+		t.Fatal(`can't create layer:`, err)
+	}
+
+	return l
+}
+
+// testNetwork creates a network from config whose random numbers are drawn from a source with the given seed, so
+// that tests can create identical networks.
+func testNetwork(t *testing.T, config []LayerConf, seed int64) *Network {
+	t.Helper()
+
+	net, err := New(config, WithSeed(seed))
+	if err != nil {
+		t.Fatal(`can't create network:`, err)
+	}
+
+	return net
+}
+
 func TestLayerComputeGradient(t *testing.T) {
-	input := mat.NewVecDense(3, []float64{-1, 0, 1})
-	error := mat.NewVecDense(2, []float64{0, 0.3})
+	input := []float64{-1, 0, 1}
+	error := []float64{0, 0.3}
 
-	layer := newLayer(3, 2, activation.Sigmoid{})
-	output := layer.forward(input)
-	layer.computeGradient(error)
+	layer := newDense(t, 3, LayerConf{Inputs: 2, Activation: activation.Sigmoid{}}, testRand())
+	output, err := layer.Forward(input, false, nil)
+	if err != nil {
+		t.Fatal(`unexpected error during forward pass:`, err)
+	}
+	layer.Backward(error)
 
 	t.Log("output", output)
 }
@@ -67,7 +107,7 @@ func TestNetworkLearnXOR(t *testing.T) {
 		{Inputs: 3, Activation: act},
 		{Inputs: 1, Activation: act},
 	}
-	net, err := New(config)
+	net, err := New(config, WithSeed(1))
 	if err != nil {
 		t.Error(`can't create network`, err)
 	}
@@ -181,8 +221,8 @@ func TestNetworkSnapshotAndRestoreNew(t *testing.T) {
 		t.Fatalf(`can't restore network: %s`, err)
 	}
 
-	if net1.layers[0].weights.At(0, 0) != net2.layers[0].weights.At(0, 0) {
-		t.Errorf(`Weight changed. Expected %f, got %f`, net1.layers[0].weights.At(0, 0), net2.layers[0].weights.At(0, 0))
+	if denseLayer(net1, 0).weights.At(0, 0) != denseLayer(net2, 0).weights.At(0, 0) {
+		t.Errorf(`Weight changed. Expected %f, got %f`, denseLayer(net1, 0).weights.At(0, 0), denseLayer(net2, 0).weights.At(0, 0))
 	}
 
 	output1 := net1.Forward([]float64{1, 0})
@@ -194,32 +234,1238 @@ func TestNetworkSnapshotAndRestoreNew(t *testing.T) {
 }
 
 func TestLayerSnapshotAndRestoreNewLayer(t *testing.T) {
-	input := mat.NewVecDense(1, []float64{1})
+	input := []float64{1}
 
-	layer1 := newLayer(1, 1, activation.Sigmoid{})
-	output1 := layer1.forward(input)
+	layer1 := newDense(t, 1, LayerConf{Inputs: 1, Activation: activation.Sigmoid{}}, testRand())
+	output1, err := layer1.Forward(input, false, nil)
+	if err != nil {
+		t.Fatal("unexpected error during forward pass:", err)
+	}
 
 	var buf bytes.Buffer
 
-	_, err := layer1.WriteTo(&buf)
+	_, err = layer1.WriteTo(&buf)
 	if err != nil {
 		t.Fatal("can't snapshot layer:", err)
 	}
 
-	layer2 := newLayer(1, 1, activation.Sigmoid{})
+	layer2 := newDense(t, 1, LayerConf{Inputs: 1, Activation: activation.Sigmoid{}}, rand.New(rand.NewSource(2)))
 
 	_, err = layer2.ReadFrom(&buf)
 	if err != nil {
 		t.Fatal("can't restore layer:", err)
 	}
 
-	output2 := layer2.forward(input)
+	output2, err := layer2.Forward(input, false, nil)
+	if err != nil {
+		t.Fatal("unexpected error during forward pass:", err)
+	}
 
 	if layer1.weights.At(0, 0) != layer2.weights.At(0, 0) {
 		t.Errorf(`Weights changed: expected %f, got %f`, layer1.weights.At(0, 0), layer2.weights.At(0, 0))
 	}
 
-	if output1.AtVec(0) != output2.AtVec(0) {
+	if output1[0] != output2[0] {
+		t.Errorf(`Output changed: expected %v, got %v`, output1, output2)
+	}
+}
+
+func TestNetworkLearnOffsetWithBias(t *testing.T) {
+	config := []LayerConf{
+		{Inputs: 1},
+		{Inputs: 1, Activation: activation.Sigmoid{}, Bias: true},
+	}
+	net, err := New(config)
+	if err != nil {
+		t.Fatal(`can't create network`, err)
+	}
+
+	// Without a bias, the output for a zero input is stuck at sigmoid(0) = 0.5
+	input := []float64{0}
+	target := []float64{0.8}
+
+	for iter := 0; iter < 1000; iter++ {
+		output := net.Forward(input)
+		net.Backprop(input, Error(output, target), 0.5)
+	}
+
+	output := net.Forward(input)
+	if math.Abs(output[0]-target[0]) > 0.01 {
+		t.Errorf(`failed to learn offset: expected %f, got %f`, target[0], output[0])
+	}
+}
+
+func TestLayerSnapshotAndRestoreBias(t *testing.T) {
+	input := []float64{1, -1}
+
+	layer1 := newDense(t, 2, LayerConf{Inputs: 3, Activation: activation.Tanh{}, Bias: true}, testRand())
+	layer1.bias.SetVec(1, 0.5)
+	output1, err := layer1.Forward(input, false, nil)
+	if err != nil {
+		t.Fatal("unexpected error during forward pass:", err)
+	}
+	output1 = append([]float64{}, output1...)
+
+	var buf bytes.Buffer
+
+	sz, err := layer1.WriteTo(&buf)
+	if err != nil {
+		t.Fatal("can't snapshot layer:", err)
+	}
+
+	if sz != int64(buf.Len()) {
+		t.Errorf(`unexpected encoded size: expected %d, got %d`, buf.Len(), sz)
+	}
+
+	snapshot := buf.Bytes()
+
+	// Restoring biases into an unbiased layer would change its architecture
+	unbiased := newDense(t, 2, LayerConf{Inputs: 3, Activation: activation.Tanh{}}, rand.New(rand.NewSource(2)))
+	weights := mat.DenseCopyOf(unbiased.weights)
+
+	_, err = unbiased.ReadFrom(bytes.NewReader(snapshot))
+	if !errors.Is(err, ErrArchitectureMismatch) {
+		t.Errorf(`expected an architecture mismatch, got %v`, err)
+	}
+
+	if unbiased.bias != nil || !mat.Equal(unbiased.weights, weights) {
+		t.Error(`unbiased layer was modified`)
+	}
+
+	layer2 := newDense(t, 2, LayerConf{Inputs: 3, Activation: activation.Tanh{}, Bias: true}, rand.New(rand.NewSource(2)))
+
+	_, err = layer2.ReadFrom(bytes.NewReader(snapshot))
+	if err != nil {
+		t.Fatal("can't restore layer:", err)
+	}
+
+	if layer2.bias == nil || layer2.bias.AtVec(1) != 0.5 {
+		t.Fatalf(`bias not restored: %v`, layer2.bias)
+	}
+
+	output2, err := layer2.Forward(input, false, nil)
+	if err != nil {
+		t.Fatal("unexpected error during forward pass:", err)
+	}
+
+	if !floats.Equal(output1, output2) {
+		t.Errorf(`Output changed: expected %v, got %v`, output1, output2)
+	}
+}
+
+func TestNetworkRestoreUnbiasedSnapshot(t *testing.T) {
+	unbiased := []LayerConf{
+		{Inputs: 2},
+		{Inputs: 3, Activation: activation.Sigmoid{}},
+		{Inputs: 1, Activation: activation.Sigmoid{}},
+	}
+	biased := []LayerConf{
+		{Inputs: 2},
+		{Inputs: 3, Activation: activation.Sigmoid{}, Bias: true},
+		{Inputs: 1, Activation: activation.Sigmoid{}, Bias: true},
+	}
+
+	net1, err := New(unbiased)
+	if err != nil {
+		t.Fatal(`can't create first network`, err)
+	}
+
+	net2, err := New(biased)
+	if err != nil {
+		t.Fatal(`can't create second network`, err)
+	}
+	denseLayer(net2, 0).bias.SetVec(0, 1)
+
+	var buf bytes.Buffer
+
+	_, err = net1.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error during snapshot: %s", err)
+	}
+
+	legacy := stripManifest(t, &buf)
+
+	_, err = net2.ReadFrom(legacy)
+	if err != nil {
+		t.Fatalf(`can't restore network: %s`, err)
+	}
+
+	if denseLayer(net2, 0).bias.AtVec(0) != 0 {
+		t.Errorf(`bias not reset: %v`, denseLayer(net2, 0).bias)
+	}
+
+	output1 := net1.Forward([]float64{1, 0})
+	output2 := net2.Forward([]float64{1, 0})
+
+	if output1[0] != output2[0] {
 		t.Errorf(`Output changed: expected %v, got %v`, output1, output2)
 	}
 }
+
+func TestNetworkTrainBatchMatchesBackprop(t *testing.T) {
+	config := []LayerConf{
+		{Inputs: 2},
+		{Inputs: 3, Activation: activation.Tanh{}, Bias: true},
+		{Inputs: 2, Activation: activation.Sigmoid{}},
+	}
+	net1, err := New(config)
+	if err != nil {
+		t.Fatal(`can't create network`, err)
+	}
+	net2 := net1.Clone()
+
+	samples := [][2][]float64{
+		{{0, 1}, {1, 0}},
+		{{1, 0}, {0, 1}},
+		{{0.5, -1}, {0.3, 0.7}},
+	}
+
+	for _, s := range samples {
+		output1 := net1.Forward(s[0])
+		net1.Backprop(s[0], Error(output1, s[1]), 0.3)
+
+		loss2, err := net2.TrainBatch([][]float64{s[0]}, [][]float64{s[1]}, loss.SquaredError{}, 0.3)
+		if err != nil {
+			t.Fatal(`can't train batch:`, err)
+		}
+
+		if loss1 := (loss.SquaredError{}).Value(output1, s[1]); math.Abs(loss1-loss2) > 1e-12 {
+			t.Errorf(`losses differ: expected %f, got %f`, loss1, loss2)
+		}
+	}
+
+	for idx := range net1.layers {
+		if !mat.EqualApprox(denseLayer(net1, idx).weights, denseLayer(net2, idx).weights, 1e-12) {
+			t.Errorf(`weights of layer %d differ`, idx)
+		}
+
+		if denseLayer(net1, idx).bias != nil && !mat.EqualApprox(denseLayer(net1, idx).bias, denseLayer(net2, idx).bias, 1e-12) {
+			t.Errorf(`biases of layer %d differ`, idx)
+		}
+	}
+}
+
+func TestNetworkTrainBatchAveragesGradients(t *testing.T) {
+	config := []LayerConf{
+		{Inputs: 2},
+		{Inputs: 1, Activation: activation.Tanh{}, Bias: true},
+	}
+	net, err := New(config)
+	if err != nil {
+		t.Fatal(`can't create network`, err)
+	}
+
+	inputs := [][]float64{{0, 1}, {1, 0}}
+	targets := [][]float64{{0.5}, {-0.5}}
+
+	// The averaged update equals the mean of the single sample updates computed from the same weights.
+	expected := mat.NewDense(1, 2, nil)
+	for idx := range inputs {
+		single := net.Clone()
+		single.Backprop(inputs[idx], Error(single.Forward(inputs[idx]), targets[idx]), 1)
+
+		var update mat.Dense
+		update.Sub(denseLayer(single, 0).weights, denseLayer(net, 0).weights)
+		expected.Add(expected, &update)
+	}
+	expected.Scale(0.5, expected)
+	expected.Add(expected, denseLayer(net, 0).weights)
+
+	_, err = net.TrainBatch(inputs, targets, loss.SquaredError{}, 1)
+	if err != nil {
+		t.Fatal(`can't train batch:`, err)
+	}
+
+	if !mat.EqualApprox(expected, denseLayer(net, 0).weights, 1e-12) {
+		t.Errorf(`unexpected weights: expected %v, got %v`, mat.Formatted(expected), mat.Formatted(denseLayer(net, 0).weights))
+	}
+}
+
+func TestNetworkTrainBatchErrors(t *testing.T) {
+	config := []LayerConf{
+		{Inputs: 2},
+		{Inputs: 1, Activation: activation.Sigmoid{}},
+	}
+	net, err := New(config)
+	if err != nil {
+		t.Fatal(`can't create network`, err)
+	}
+
+	tests := map[string][2][][]float64{
+		"empty":           {nil, nil},
+		"count mismatch":  {{{0, 1}}, {{1}, {0}}},
+		"input mismatch":  {{{0, 1, 2}}, {{1}}},
+		"target mismatch": {{{0, 1}}, {{1, 0}}},
+	}
+
+	for name, tc := range tests {
+		_, err := net.TrainBatch(tc[0], tc[1], loss.MSE{}, 0.1)
+		if err == nil {
+			t.Errorf(`%s: expected an error`, name)
+		}
+	}
+}
+
+func TestNetworkSnapshotAndRestoreOptimizerState(t *testing.T) {
+	config := []LayerConf{
+		{Inputs: 2},
+		{Inputs: 3, Activation: activation.Tanh{}, Bias: true},
+		{Inputs: 1, Activation: activation.Tanh{}},
+	}
+
+	net1, err := New(config)
+	if err != nil {
+		t.Fatal(`can't create network`, err)
+	}
+	net1.SetOptimizer(optimizer.Adam{})
+
+	inputs := [][]float64{{0, 1}, {1, 0}}
+	targets := [][]float64{{1}, {-1}}
+
+	_, err = net1.TrainBatch(inputs, targets, loss.MSE{}, 0.01)
+	if err != nil {
+		t.Fatal(`can't train batch:`, err)
+	}
+
+	var buf bytes.Buffer
+
+	_, err = net1.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(`can't snapshot network:`, err)
+	}
+
+	net2, err := New(config)
+	if err != nil {
+		t.Fatal(`can't create network`, err)
+	}
+	net2.SetOptimizer(optimizer.Adam{})
+
+	_, err = net2.ReadFrom(&buf)
+	if err != nil {
+		t.Fatal(`can't restore network:`, err)
+	}
+
+	// Resumed training has to continue exactly where the first network is
+	for i := 0; i < 3; i++ {
+		_, err = net1.TrainBatch(inputs, targets, loss.MSE{}, 0.01)
+		if err != nil {
+			t.Fatal(`can't train batch:`, err)
+		}
+
+		_, err = net2.TrainBatch(inputs, targets, loss.MSE{}, 0.01)
+		if err != nil {
+			t.Fatal(`can't train batch:`, err)
+		}
+	}
+
+	for idx := range net1.layers {
+		if !mat.Equal(denseLayer(net1, idx).weights, denseLayer(net2, idx).weights) {
+			t.Errorf(`weights of layer %d differ after resuming`, idx)
+		}
+	}
+}
+
+func TestNetworkRestoreOptimizerStateMismatch(t *testing.T) {
+	config := []LayerConf{
+		{Inputs: 2},
+		{Inputs: 1, Activation: activation.Tanh{}},
+	}
+
+	net1, err := New(config)
+	if err != nil {
+		t.Fatal(`can't create network`, err)
+	}
+	net1.SetOptimizer(optimizer.Momentum{Mu: 0.9})
+
+	var buf bytes.Buffer
+
+	_, err = net1.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(`can't snapshot network:`, err)
+	}
+
+	net2, err := New(config)
+	if err != nil {
+		t.Fatal(`can't create network`, err)
+	}
+	net2.SetOptimizer(optimizer.Adam{})
+
+	_, err = net2.ReadFrom(&buf)
+	if err == nil {
+		t.Error(`expected an error when restoring momentum state into Adam`)
+	}
+
+	// SGD has no state to read, but must still notice state from other optimizers
+	net2.SetOptimizer(optimizer.Adam{})
+
+	_, err = net2.TrainBatch([][]float64{{0, 1}}, [][]float64{{1}}, loss.MSE{}, 0.01)
+	if err != nil {
+		t.Fatal(`can't train batch:`, err)
+	}
+
+	buf.Reset()
+
+	_, err = net2.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(`can't snapshot network:`, err)
+	}
+
+	net3, err := New(config)
+	if err != nil {
+		t.Fatal(`can't create network`, err)
+	}
+
+	_, err = net3.ReadFrom(&buf)
+	if err == nil {
+		t.Error(`expected an error when restoring Adam state into SGD`)
+	}
+}
+
+func TestNetworkTrainReportsLoss(t *testing.T) {
+	config := []LayerConf{
+		{Inputs: 2},
+		{Inputs: 3, Activation: activation.Tanh{}, Bias: true},
+		{Inputs: 2, Activation: activation.Sigmoid{}},
+	}
+	net, err := New(config)
+	if err != nil {
+		t.Fatal(`can't create network`, err)
+	}
+
+	input := []float64{1, 0}
+	target := []float64{1, 0}
+	l := loss.BinaryCrossEntropy{}
+
+	expected := l.Value(net.Forward(input), target)
+
+	loss1, err := net.Train(input, target, l, 0.5)
+	if err != nil {
+		t.Fatal(`can't train:`, err)
+	}
+	if loss1 != expected {
+		t.Errorf(`unexpected loss: expected %f, got %f`, expected, loss1)
+	}
+
+	loss2, err := net.Train(input, target, l, 0.5)
+	if err != nil {
+		t.Fatal(`can't train:`, err)
+	}
+	if loss2 >= loss1 {
+		t.Errorf(`training failed to improve loss: loss1: %f, loss2: %f`, loss1, loss2)
+	}
+}
+
+func TestNetworkSoftmaxFusedCrossEntropy(t *testing.T) {
+	config := []LayerConf{
+		{Inputs: 2},
+		{Inputs: 4, Activation: activation.Tanh{}, Bias: true},
+		{Inputs: 3, Activation: activation.Softmax{}, Bias: true},
+	}
+	net1, err := New(config)
+	if err != nil {
+		t.Fatal(`can't create network`, err)
+	}
+	net2 := net1.Clone()
+	net3 := net1.Clone()
+
+	input := []float64{0.5, -0.5}
+	target := []float64{0, 1, 0}
+	l := loss.CategoricalCrossEntropy{}
+
+	// The fused path has to agree with going through the derivatives of loss and softmax separately
+	output := net1.Forward(input)
+	net1.Backprop(input, l.Error(output, target), 0.1)
+
+	loss2, err := net2.Train(input, target, l, 0.1)
+	if err != nil {
+		t.Fatal(`can't train:`, err)
+	}
+
+	loss3, err := net3.TrainBatch([][]float64{input}, [][]float64{target}, l, 0.1)
+	if err != nil {
+		t.Fatal(`can't train batch:`, err)
+	}
+
+	if loss2 != loss3 {
+		t.Errorf(`losses differ: %f vs %f`, loss2, loss3)
+	}
+
+	for idx := range net1.layers {
+		if !mat.EqualApprox(denseLayer(net1, idx).weights, denseLayer(net2, idx).weights, 1e-9) {
+			t.Errorf(`weights of layer %d differ between Backprop and Train`, idx)
+		}
+		if !mat.EqualApprox(denseLayer(net2, idx).weights, denseLayer(net3, idx).weights, 1e-12) {
+			t.Errorf(`weights of layer %d differ between Train and TrainBatch`, idx)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		_, err = net2.Train(input, target, l, 0.1)
+		if err != nil {
+			t.Fatal(`can't train:`, err)
+		}
+	}
+
+	output = net2.Forward(input)
+	if output[1] < 0.9 {
+		t.Errorf(`failed to learn class: %v`, output)
+	}
+}
+
+func TestNetworkForwardNonFinite(t *testing.T) {
+	config := []LayerConf{
+		{Inputs: 2},
+		{Inputs: 3, Activation: activation.LeakyReLU{Leak: 0.01}},
+		{Inputs: 1, Activation: activation.Tanh{}},
+	}
+	net, err := New(config)
+	if err != nil {
+		t.Fatal(`can't create network`, err)
+	}
+
+	_, err = net.ForwardE([]float64{0, math.NaN()})
+
+	var nfe *NonFiniteError
+	if !errors.As(err, &nfe) {
+		t.Fatalf(`expected a NonFiniteError, got %v`, err)
+	}
+	if !nfe.Input || nfe.Unit != 1 || nfe.Layer != 0 {
+		t.Errorf(`unexpected error for NaN input: %#v`, nfe)
+	}
+
+	// Make the second unit of the first layer overflow
+	denseLayer(net, 0).weights.Set(1, 0, math.MaxFloat64)
+	denseLayer(net, 0).weights.Set(1, 1, math.MaxFloat64)
+
+	_, err = net.ForwardE([]float64{1, 1})
+	if !errors.As(err, &nfe) {
+		t.Fatalf(`expected a NonFiniteError, got %v`, err)
+	}
+	if nfe.Input || nfe.Layer != 0 || nfe.Unit != 1 || !math.IsInf(nfe.Value, 1) {
+		t.Errorf(`unexpected error for overflowing unit: %#v`, nfe)
+	}
+
+	_, err = net.Train([]float64{1, 1}, []float64{0}, loss.MSE{}, 0.1)
+	if !errors.As(err, &nfe) {
+		t.Errorf(`expected a NonFiniteError from Train, got %v`, err)
+	}
+
+	_, err = net.TrainBatch([][]float64{{0, 0}, {1, 1}}, [][]float64{{0}, {0}}, loss.MSE{}, 0.1)
+	if !errors.As(err, &nfe) {
+		t.Fatalf(`expected a NonFiniteError from TrainBatch, got %v`, err)
+	}
+	if nfe.Sample != 1 || nfe.Layer != 0 || nfe.Unit != 1 {
+		t.Errorf(`unexpected error from TrainBatch: %#v`, nfe)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error(`expected Forward to panic`)
+		}
+	}()
+
+	net.Forward([]float64{1, 1})
+}
+
+func TestNetworkForwardInputLength(t *testing.T) {
+	config := []LayerConf{
+		{Inputs: 2},
+		{Inputs: 3, Activation: activation.Tanh{}, Bias: true},
+		{Inputs: 1, Activation: activation.Tanh{}},
+	}
+	net, err := New(config)
+	if err != nil {
+		t.Fatal(`can't create network`, err)
+	}
+
+	// Leave values from a previous forward pass in the layers, so that short inputs can't pick them up
+	net.Forward([]float64{0.5, -0.5})
+
+	for _, inputs := range [][]float64{{1}, {1, 2, 3}} {
+		_, err := net.ForwardE(inputs)
+		if err == nil {
+			t.Errorf(`ForwardE: expected an error for %d inputs`, len(inputs))
+		}
+
+		_, err = net.Predict(inputs)
+		if err == nil {
+			t.Errorf(`Predict: expected an error for %d inputs`, len(inputs))
+		}
+
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf(`Forward: expected a panic for %d inputs`, len(inputs))
+				}
+			}()
+
+			net.Forward(inputs)
+		}()
+	}
+}
+
+func TestNetworkPredictConcurrently(t *testing.T) {
+	config := []LayerConf{
+		{Inputs: 4},
+		{Inputs: 16, Activation: activation.Tanh{}, Bias: true},
+		{Inputs: 3, Activation: activation.Softmax{}},
+	}
+	net, err := New(config)
+	if err != nil {
+		t.Fatal(`can't create network`, err)
+	}
+
+	inputs := make([][]float64, 32)
+	expected := make([][]float64, len(inputs))
+	for idx := range inputs {
+		inputs[idx] = []float64{float64(idx), -1, 0.5, float64(idx % 3)}
+		expected[idx] = net.Forward(inputs[idx])
+	}
+
+	var wg sync.WaitGroup
+
+	errs := make(chan error, 8)
+
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < 100; i++ {
+				for idx, input := range inputs {
+					output, err := net.Predict(input)
+					if err != nil {
+						errs <- err
+						return
+					}
+
+					for j := range output {
+						if output[j] != expected[idx][j] {
+							errs <- fmt.Errorf(`output %d differs: expected %v, got %v`, idx, expected[idx], output)
+							return
+						}
+					}
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// testRand returns a random source with a fixed seed.
+func testRand() *rand.Rand {
+	return rand.New(rand.NewSource(1))
+}
+
+func TestNewLayerInitializer(t *testing.T) {
+	init := initializer.Constant{Value: 0.5}
+
+	l := newDense(t, 3, LayerConf{Inputs: 2, Activation: activation.Tanh{}, Initializer: init}, testRand())
+	for _, w := range l.weights.RawMatrix().Data {
+		if w != 0.5 {
+			t.Fatal(`initializer wasn't used:`, mat.Formatted(l.weights))
+		}
+	}
+}
+
+func TestDefaultInitializer(t *testing.T) {
+	tests := map[activation.Activation]initializer.Initializer{
+		activation.LeakyReLU{Leak: 0.01}: initializer.He{},
+		activation.ELU{A: 1}:             initializer.He{},
+		activation.GELU{}:                initializer.He{},
+		activation.SELU{}:                initializer.LeCun{},
+		activation.Tanh{}:                initializer.XavierUniform{},
+		activation.Sigmoid{}:             initializer.XavierUniform{},
+		activation.Softmax{}:             initializer.XavierUniform{},
+	}
+
+	for act, expected := range tests {
+		if init := defaultInitializer(act); init != expected {
+			t.Errorf(`%T: expected %T, got %T`, act, expected, init)
+		}
+	}
+}
+
+func TestNetworkWithSeedIsReproducible(t *testing.T) {
+	config := []LayerConf{
+		{Inputs: 3},
+		{Inputs: 5, Activation: activation.Tanh{}, Bias: true},
+		{Inputs: 4, Activation: activation.LeakyReLU{Leak: 0.01}},
+		{Inputs: 2, Activation: activation.Softmax{}, Initializer: initializer.Orthogonal{}},
+	}
+
+	snapshot := func(seed int64) []byte {
+		net, err := New(config, WithSeed(seed))
+		if err != nil {
+			t.Fatal(`can't create network`, err)
+		}
+
+		var buf bytes.Buffer
+
+		_, err = net.WriteTo(&buf)
+		if err != nil {
+			t.Fatal(`can't snapshot network:`, err)
+		}
+
+		return buf.Bytes()
+	}
+
+	if !bytes.Equal(snapshot(42), snapshot(42)) {
+		t.Error(`snapshots of networks with the same seed differ`)
+	}
+
+	if bytes.Equal(snapshot(42), snapshot(43)) {
+		t.Error(`snapshots of networks with different seeds are identical`)
+	}
+}
+
+func TestNetworkRegularization(t *testing.T) {
+	input := []float64{1, -1}
+	learningRate := 0.1
+
+	for name, tc := range map[string]struct {
+		conf     LayerConf
+		expected func(w float64) float64
+		penalty  func(weights []float64) float64
+	}{
+		"l1": {
+			conf: LayerConf{L1: 0.5},
+			expected: func(w float64) float64 {
+				return w - learningRate*0.5*math.Copysign(1, w)
+			},
+			penalty: func(weights []float64) float64 {
+				res := float64(0)
+				for _, w := range weights {
+					res += 0.5 * math.Abs(w)
+				}
+				return res
+			},
+		},
+		"l2": {
+			conf: LayerConf{L2: 0.5},
+			expected: func(w float64) float64 {
+				return w * (1 - 2*learningRate*0.5)
+			},
+			penalty: func(weights []float64) float64 {
+				res := float64(0)
+				for _, w := range weights {
+					res += 0.5 * w * w
+				}
+				return res
+			},
+		},
+		"weight decay": {
+			conf: LayerConf{WeightDecay: 0.5},
+			expected: func(w float64) float64 {
+				return w * (1 - learningRate*0.5)
+			},
+			penalty: func([]float64) float64 {
+				return 0
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			conf := tc.conf
+			conf.Inputs = 3
+			conf.Activation = activation.Tanh{}
+
+			net, err := New([]LayerConf{{Inputs: 2}, conf}, WithSeed(1))
+			if err != nil {
+				t.Fatal(`can't create network:`, err)
+			}
+
+			before := mat.DenseCopyOf(denseLayer(net, 0).weights)
+
+			// Train towards the current output, so that only the regularization changes the weights.
+			output := net.Forward(input)
+			lossValue, err := net.Train(input, output, loss.SquaredError{}, learningRate)
+			if err != nil {
+				t.Fatal(`training failed:`, err)
+			}
+
+			if math.Abs(lossValue-tc.penalty(before.RawMatrix().Data)) > 1e-12 {
+				t.Errorf(`expected loss %f, got %f`, tc.penalty(before.RawMatrix().Data), lossValue)
+			}
+
+			rows, cols := before.Dims()
+			for r := 0; r < rows; r++ {
+				for c := 0; c < cols; c++ {
+					expected := tc.expected(before.At(r, c))
+					actual := denseLayer(net, 0).weights.At(r, c)
+					if math.Abs(expected-actual) > 1e-12 {
+						t.Errorf(`weight %d/%d: expected %f, got %f`, r, c, expected, actual)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestNetworkRegularizationIsDecoupledFromOptimizer(t *testing.T) {
+	config := []LayerConf{
+		{Inputs: 2},
+		{Inputs: 2, Activation: activation.Tanh{}},
+	}
+
+	input := []float64{1, -1}
+
+	for name, conf := range map[string]LayerConf{
+		"l2":           {L2: 0.05},
+		"weight decay": {WeightDecay: 0.1},
+	} {
+		conf.Inputs = 2
+		conf.Activation = activation.Tanh{}
+
+		net, err := New([]LayerConf{config[0], conf}, WithSeed(1))
+		if err != nil {
+			t.Fatal(`can't create network:`, err)
+		}
+		net.SetOptimizer(optimizer.Adam{})
+
+		before := mat.DenseCopyOf(denseLayer(net, 0).weights)
+
+		output := net.Forward(input)
+		_, err = net.TrainBatch([][]float64{input}, [][]float64{output}, loss.SquaredError{}, 0.1)
+		if err != nil {
+			t.Fatal(`training failed:`, err)
+		}
+
+		var diff mat.Dense
+		diff.Sub(before, denseLayer(net, 0).weights)
+
+		// Adam normalizes the step of the L2 gradient to the learning rate, while weight decay scales with the
+		// weights.
+		for idx, d := range diff.RawMatrix().Data {
+			w := before.RawMatrix().Data[idx]
+
+			expected := 0.1 * 0.1 * w
+			if name == "l2" {
+				expected = 0.1 * math.Copysign(1, w)
+			}
+
+			if math.Abs(d-expected) > 1e-6 {
+				t.Errorf(`%s: weight %d changed by %f, expected %f`, name, idx, d, expected)
+			}
+		}
+	}
+
+	_, err := New([]LayerConf{config[0], {Inputs: 2, Activation: activation.Tanh{}, L2: -1}})
+	if err == nil {
+		t.Error(`expected error for negative regularization`)
+	}
+}
+
+var dropoutConfig = []LayerConf{
+	{Inputs: 4},
+	{Inputs: 50, Activation: activation.Tanh{}, Bias: true, Dropout: 0.5},
+	{Inputs: 2, Activation: activation.Sigmoid{}},
+}
+
+func TestNetworkDropout(t *testing.T) {
+	net := testNetwork(t, dropoutConfig, 1)
+	input := []float64{0.5, -0.25, 1, 0}
+
+	expected, err := net.Predict(input)
+	if err != nil {
+		t.Fatal(`can't predict:`, err)
+	}
+
+	if net.Mode() != Inference {
+		t.Fatal(`new network isn't in inference mode`)
+	}
+
+	output := net.Forward(input)
+	if !floats.Equal(output, expected) {
+		t.Errorf(`Forward in inference mode applies dropout: %v != %v`, output, expected)
+	}
+
+	net.SetMode(Training)
+
+	output = net.Forward(input)
+	if !floats.Equal(output, expected) {
+		t.Errorf(`Forward in training mode applies dropout: %v != %v`, output, expected)
+	}
+
+	hidden := denseLayer(net, 0)
+	before := mat.DenseCopyOf(hidden.weights)
+
+	_, err = net.Train(input, []float64{1, 0}, loss.SquaredError{}, 0.1)
+	if err != nil {
+		t.Fatal(`training failed:`, err)
+	}
+
+	dropped := 0
+	for idx, o := range hidden.output.RawVector().Data {
+		d := hidden.dropped.AtVec(idx)
+		switch {
+		case d == 0:
+			dropped++
+		case math.Abs(d-2*o) > 1e-12:
+			t.Errorf(`output %d: kept output %f isn't scaled, expected %f`, idx, d, 2*o)
+		}
+	}
+
+	if dropped == 0 || dropped == hidden.output.Len() {
+		t.Errorf(`unexpected number of dropped outputs: %d`, dropped)
+	}
+
+	// Weights of dropped units must not change, since they don't contribute to the output.
+	for idx := 0; idx < hidden.output.Len(); idx++ {
+		unchanged := mat.Equal(before.RowView(idx), hidden.weights.RowView(idx))
+		if unchanged != (hidden.mask.AtVec(idx) == 0) {
+			t.Errorf(`unit %d: weights unchanged: %t, but mask is %f`, idx, unchanged, hidden.mask.AtVec(idx))
+		}
+	}
+
+	p, err := net.Predict(input)
+	if err != nil {
+		t.Fatal(`can't predict:`, err)
+	}
+
+	net.SetMode(Inference)
+	if !floats.Equal(net.Forward(input), p) {
+		t.Error(`Predict applies dropout in training mode`)
+	}
+}
+
+func TestNetworkTrainBatchDropoutMatchesTrain(t *testing.T) {
+	input := []float64{0.5, -0.25, 1, 0}
+	target := []float64{1, 0}
+
+	net1 := testNetwork(t, dropoutConfig, 1)
+	net1.SetMode(Training)
+
+	_, err := net1.Train(input, target, loss.SquaredError{}, 0.1)
+	if err != nil {
+		t.Fatal(`training failed:`, err)
+	}
+
+	net2 := testNetwork(t, dropoutConfig, 1)
+	net2.SetMode(Training)
+
+	_, err = net2.TrainBatch([][]float64{input}, [][]float64{target}, loss.SquaredError{}, 0.1)
+	if err != nil {
+		t.Fatal(`training failed:`, err)
+	}
+
+	for idx := range net1.layers {
+		if !mat.EqualApprox(denseLayer(net1, idx).weights, denseLayer(net2, idx).weights, 1e-12) {
+			t.Errorf(`layer %d: weights differ between Train and TrainBatch`, idx)
+		}
+	}
+}
+
+func TestNetworkCloneKeepsRandomStream(t *testing.T) {
+	input := []float64{0.5, -0.25, 1, 0}
+	target := []float64{1, 0}
+
+	net1 := testNetwork(t, dropoutConfig, 1)
+	net1.SetMode(Training)
+
+	net2 := testNetwork(t, dropoutConfig, 1)
+	net2.SetMode(Training)
+
+	// Cloning must not draw from the random source of net1, otherwise the dropout masks of both networks differ.
+	net1.Clone()
+
+	for idx := 0; idx < 3; idx++ {
+		for _, net := range []*Network{net1, net2} {
+			_, err := net.TrainBatch([][]float64{input}, [][]float64{target}, loss.SquaredError{}, 0.1)
+			if err != nil {
+				t.Fatal(`training failed:`, err)
+			}
+		}
+	}
+
+	for idx := range net1.layers {
+		if !mat.Equal(denseLayer(net1, idx).weights, denseLayer(net2, idx).weights) {
+			t.Errorf(`layer %d: cloning changed the dropout masks of the original network`, idx)
+		}
+	}
+}
+
+func TestNetworkDropoutErrors(t *testing.T) {
+	for name, configs := range map[string][]LayerConf{
+		"output layer": {
+			{Inputs: 2},
+			{Inputs: 2, Activation: activation.Tanh{}, Dropout: 0.5},
+		},
+		"rate too large": {
+			{Inputs: 2},
+			{Inputs: 2, Activation: activation.Tanh{}, Dropout: 1},
+			{Inputs: 2, Activation: activation.Tanh{}},
+		},
+		"negative rate": {
+			{Inputs: 2},
+			{Inputs: 2, Activation: activation.Tanh{}, Dropout: -0.1},
+			{Inputs: 2, Activation: activation.Tanh{}},
+		},
+	} {
+		_, err := New(configs)
+		if err == nil {
+			t.Errorf(`%s: expected error`, name)
+		}
+	}
+}
+
+func TestNetworkClipping(t *testing.T) {
+	config := []LayerConf{
+		{Inputs: 3},
+		{Inputs: 4, Activation: activation.Tanh{}, Bias: true},
+		{Inputs: 2, Activation: activation.Tanh{}, Bias: true},
+	}
+
+	inputs := [][]float64{{1, -2, 3}, {0.5, 0, -1}}
+	targets := [][]float64{{5, -5}, {-3, 4}}
+	learningRate := 0.1
+
+	// steps trains a network with the given clipping and returns the steps that were applied to the weights
+	// and biases of each layer, along with the gradient norm reported by the network.
+	steps := func(c Clipping) ([][]float64, float64) {
+		net, err := New(config, WithSeed(1))
+		if err != nil {
+			t.Fatal(`can't create network:`, err)
+		}
+		net.SetClipping(c)
+
+		before := net.Clone()
+
+		_, err = net.TrainBatch(inputs, targets, loss.SquaredError{}, learningRate)
+		if err != nil {
+			t.Fatal(`training failed:`, err)
+		}
+
+		var res [][]float64
+		for idx := range net.layers {
+			var step []float64
+
+			l := denseLayer(net, idx)
+
+			params := append(mat.DenseCopyOf(l.weights).RawMatrix().Data, l.bias.RawVector().Data...)
+			old := append(mat.DenseCopyOf(denseLayer(before, idx).weights).RawMatrix().Data, denseLayer(before, idx).bias.RawVector().Data...)
+			for i := range params {
+				step = append(step, (params[i]-old[i])/learningRate)
+			}
+
+			res = append(res, step)
+		}
+
+		return res, net.GradientNorm()
+	}
+
+	norm := func(steps ...[]float64) float64 {
+		res := float64(0)
+		for _, s := range steps {
+			res += floats.Dot(s, s)
+		}
+		return math.Sqrt(res)
+	}
+
+	unclipped, gradientNorm := steps(Clipping{})
+	if math.Abs(gradientNorm-norm(unclipped...)) > 1e-9 {
+		t.Errorf(`gradient norm %f doesn't match the norm of the steps %f`, gradientNorm, norm(unclipped...))
+	}
+
+	limit := 0.1
+	for name, tc := range map[string]struct {
+		clipping Clipping
+		expected func(layer, idx int) float64
+	}{
+		"value": {
+			clipping: Clipping{Value: limit},
+			expected: func(layer, idx int) float64 {
+				return math.Max(-limit, math.Min(limit, unclipped[layer][idx]))
+			},
+		},
+		"layer norm": {
+			clipping: Clipping{LayerNorm: limit},
+			expected: func(layer, idx int) float64 {
+				return unclipped[layer][idx] * limit / norm(unclipped[layer])
+			},
+		},
+		"global norm": {
+			clipping: Clipping{GlobalNorm: limit},
+			expected: func(layer, idx int) float64 {
+				return unclipped[layer][idx] * limit / norm(unclipped...)
+			},
+		},
+		"loose global norm": {
+			clipping: Clipping{GlobalNorm: 2 * gradientNorm},
+			expected: func(layer, idx int) float64 {
+				return unclipped[layer][idx]
+			},
+		},
+	} {
+		clipped, clippedNorm := steps(tc.clipping)
+
+		if clippedNorm != gradientNorm {
+			t.Errorf(`%s: reported gradient norm %f isn't the one before clipping %f`, name, clippedNorm, gradientNorm)
+		}
+
+		for layer := range clipped {
+			for idx, s := range clipped[layer] {
+				if math.Abs(s-tc.expected(layer, idx)) > 1e-9 {
+					t.Errorf(`%s: layer %d, parameter %d: expected step %f, got %f`, name, layer, idx, tc.expected(layer, idx), s)
+				}
+			}
+		}
+	}
+}
+
+func TestNetworkParameters(t *testing.T) {
+	net, err := New([]LayerConf{
+		{Inputs: 2},
+		{Inputs: 3, Activation: activation.Tanh{}, Bias: true},
+		{Inputs: 1, Activation: activation.Tanh{}},
+	}, WithSeed(1))
+	if err != nil {
+		t.Fatal(`can't create network:`, err)
+	}
+
+	params := net.Parameters()
+	if len(params) != 2 || len(params[0]) != 2*3+3 || len(params[1]) != 3 {
+		t.Fatalf(`unexpected parameter layout: %v`, params)
+	}
+
+	if params[0][1] != denseLayer(net, 0).weights.At(0, 1) || params[0][6] != denseLayer(net, 0).bias.AtVec(0) {
+		t.Error(`parameters are not in the documented order`)
+	}
+
+	params[0][6] = 42
+	if denseLayer(net, 0).bias.AtVec(0) == 42 {
+		t.Error(`Parameters doesn't return a copy`)
+	}
+
+	err = net.SetParameters(params)
+	if err != nil {
+		t.Fatal(`can't set parameters:`, err)
+	}
+	if denseLayer(net, 0).bias.AtVec(0) != 42 {
+		t.Error(`parameters weren't set`)
+	}
+
+	err = net.SetParameters(params[:1])
+	if err == nil {
+		t.Error(`expected error for missing layer`)
+	}
+
+	err = net.SetParameters([][]float64{params[0], params[0]})
+	if err == nil {
+		t.Error(`expected error for wrong number of parameters`)
+	}
+}
+
+var trainableConfig = []LayerConf{
+	{Inputs: 2},
+	{Inputs: 4, Activation: activation.PReLU{PerUnit: true}, Bias: true},
+	{Inputs: 3, Activation: activation.ParametricELU{}, Bias: true},
+	{Inputs: 1, Activation: activation.Tanh{}},
+}
+
+func TestNetworkTrainableActivation(t *testing.T) {
+	inputs := [][]float64{{0, 1}, {1, 0}, {-1, -1}, {-0.5, 1}}
+	targets := [][]float64{{1}, {-1}, {0.5}, {-0.5}}
+
+	net1 := testNetwork(t, trainableConfig, 1)
+	net1.SetOptimizer(optimizer.Adam{})
+
+	if len(denseLayer(net1, 0).activationParameters()) != 4 || len(denseLayer(net1, 1).activationParameters()) != 1 {
+		t.Fatalf(`unexpected number of activation parameters: %v`, net1.Parameters())
+	}
+
+	before := net1.Clone()
+
+	for i := 0; i < 10; i++ {
+		_, err := net1.TrainBatch(inputs, targets, loss.MSE{}, 0.01)
+		if err != nil {
+			t.Fatal(`can't train batch:`, err)
+		}
+	}
+
+	for idx := range net1.layers[:2] {
+		if floats.Equal(denseLayer(net1, idx).activationParameters(), denseLayer(before, idx).activationParameters()) {
+			t.Errorf(`layer %d: activation parameters weren't updated`, idx)
+		}
+	}
+
+	var buf bytes.Buffer
+
+	_, err := net1.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(`can't snapshot network:`, err)
+	}
+
+	loaded, err := Load(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(`can't load network:`, err)
+	}
+
+	for idx := range net1.layers {
+		if !floats.Equal(flatten(denseLayer(net1, idx).Parameters()), flatten(denseLayer(loaded, idx).Parameters())) {
+			t.Errorf(`layer %d: parameters differ after loading`, idx)
+		}
+	}
+
+	net2 := testNetwork(t, trainableConfig, 2)
+	net2.SetOptimizer(optimizer.Adam{})
+
+	_, err = net2.ReadFrom(&buf)
+	if err != nil {
+		t.Fatal(`can't restore network:`, err)
+	}
+
+	// Resumed training has to continue exactly where the first network is
+	for i := 0; i < 3; i++ {
+		_, err = net1.TrainBatch(inputs, targets, loss.MSE{}, 0.01)
+		if err != nil {
+			t.Fatal(`can't train batch:`, err)
+		}
+
+		_, err = net2.TrainBatch(inputs, targets, loss.MSE{}, 0.01)
+		if err != nil {
+			t.Fatal(`can't train batch:`, err)
+		}
+	}
+
+	for idx := range net1.layers {
+		if !floats.Equal(flatten(denseLayer(net1, idx).Parameters()), flatten(denseLayer(net2, idx).Parameters())) {
+			t.Errorf(`layer %d: parameters differ after resuming`, idx)
+		}
+	}
+
+	// Training the original network must not affect its clone
+	for idx := range net1.layers[:2] {
+		if floats.Equal(denseLayer(net1, idx).activationParameters(), denseLayer(before, idx).activationParameters()) {
+			t.Errorf(`layer %d: clone shares activation parameters with the original`, idx)
+		}
+	}
+}
+
+func TestNetworkTrainableActivationTrainBatchMatchesBackprop(t *testing.T) {
+	input := []float64{-0.5, 0.25}
+	target := []float64{0.5}
+
+	net1 := testNetwork(t, trainableConfig, 1)
+
+	output := net1.Forward(input)
+	net1.Backprop(input, Error(output, target), 0.1)
+
+	net2 := testNetwork(t, trainableConfig, 1)
+
+	_, err := net2.TrainBatch([][]float64{input}, [][]float64{target}, loss.SquaredError{}, 0.1)
+	if err != nil {
+		t.Fatal(`training failed:`, err)
+	}
+
+	for idx := range net1.layers {
+		if !floats.EqualApprox(flatten(denseLayer(net1, idx).Parameters()), flatten(denseLayer(net2, idx).Parameters()), 1e-12) {
+			t.Errorf(`layer %d: parameters differ between Backprop and TrainBatch`, idx)
+		}
+	}
+}
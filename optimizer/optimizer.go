@@ -0,0 +1,367 @@
+// Package optimizer contains optimization algorithms that turn gradients into parameter updates.
+package optimizer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"gonum.org/v1/gonum/floats"
+)
+
+// Optimizer represents an optimization algorithm. It creates one State for every set of parameters it
+// optimizes, e.g. one for the weights and one for the biases of each layer.
+type Optimizer interface {
+	NewState(size int) State
+}
+
+// State holds everything an Optimizer has to remember between updates of one set of parameters, like
+// velocities or moment estimates.
+//
+// Update applies one step to params. The step slice holds the direction in which the parameters should move to
+// reduce the error, that is, the negative gradient of the loss. Both slices have the size the state was created
+// with.
+//
+// States can be persisted with WriteTo and restored with ReadFrom, so that training can be resumed seamlessly.
+type State interface {
+	Update(params, step []float64, learningRate float64)
+	Clone() State
+
+	io.WriterTo
+	io.ReaderFrom
+}
+
+// SGD is plain stochastic gradient descent. It computes params += learningRate * step.
+type SGD struct{}
+
+func (s SGD) NewState(size int) State {
+	return sgdState{}
+}
+
+type sgdState struct{}
+
+func (s sgdState) Update(params, step []float64, learningRate float64) {
+	floats.AddScaled(params, learningRate, step)
+}
+
+func (s sgdState) Clone() State {
+	return s
+}
+
+func (s sgdState) WriteTo(w io.Writer) (int64, error) {
+	return 0, nil
+}
+
+func (s sgdState) ReadFrom(r io.Reader) (int64, error) {
+	return 0, nil
+}
+
+// Momentum is stochastic gradient descent with momentum. Each update adds the current step to a velocity that
+// decays by a factor of Mu, and moves the parameters along that velocity.
+//
+// If Nesterov is set, the parameters are moved along the velocity they will have after the next update
+// instead (Nesterov accelerated gradient).
+type Momentum struct {
+	Mu       float64
+	Nesterov bool
+}
+
+func (m Momentum) NewState(size int) State {
+	return &momentumState{
+		conf:     m,
+		velocity: make([]float64, size),
+	}
+}
+
+type momentumState struct {
+	conf     Momentum
+	velocity []float64
+}
+
+func (m *momentumState) Update(params, step []float64, learningRate float64) {
+	for idx, s := range step {
+		v := m.conf.Mu*m.velocity[idx] + learningRate*s
+		m.velocity[idx] = v
+
+		if m.conf.Nesterov {
+			params[idx] += m.conf.Mu*v + learningRate*s
+		} else {
+			params[idx] += v
+		}
+	}
+}
+
+func (m *momentumState) Clone() State {
+	return &momentumState{
+		conf:     m.conf,
+		velocity: append([]float64(nil), m.velocity...),
+	}
+}
+
+func (m *momentumState) WriteTo(w io.Writer) (int64, error) {
+	return writeState(w, "momentum", 0, m.velocity)
+}
+
+func (m *momentumState) ReadFrom(r io.Reader) (int64, error) {
+	sz, _, err := readState(r, "momentum", m.velocity)
+	return sz, err
+}
+
+// Adagrad scales each step by the inverse square root of the sum of all squared steps seen so far, which gives
+// rarely updated parameters larger learning rates. Epsilon avoids division by zero and defaults to 1e-8.
+type Adagrad struct {
+	Epsilon float64
+}
+
+func (a Adagrad) NewState(size int) State {
+	return &adagradState{
+		epsilon: withDefault(a.Epsilon, 1e-8),
+		sum:     make([]float64, size),
+	}
+}
+
+type adagradState struct {
+	epsilon float64
+	sum     []float64
+}
+
+func (a *adagradState) Update(params, step []float64, learningRate float64) {
+	for idx, s := range step {
+		a.sum[idx] += s * s
+		params[idx] += learningRate * s / (math.Sqrt(a.sum[idx]) + a.epsilon)
+	}
+}
+
+func (a *adagradState) Clone() State {
+	return &adagradState{
+		epsilon: a.epsilon,
+		sum:     append([]float64(nil), a.sum...),
+	}
+}
+
+func (a *adagradState) WriteTo(w io.Writer) (int64, error) {
+	return writeState(w, "adagrad", 0, a.sum)
+}
+
+func (a *adagradState) ReadFrom(r io.Reader) (int64, error) {
+	sz, _, err := readState(r, "adagrad", a.sum)
+	return sz, err
+}
+
+// RMSProp scales each step by the inverse square root of a moving average of squared steps. Decay is the decay
+// rate of the moving average and defaults to 0.9, Epsilon avoids division by zero and defaults to 1e-8.
+type RMSProp struct {
+	Decay   float64
+	Epsilon float64
+}
+
+func (r RMSProp) NewState(size int) State {
+	return &rmspropState{
+		decay:   withDefault(r.Decay, 0.9),
+		epsilon: withDefault(r.Epsilon, 1e-8),
+		mean:    make([]float64, size),
+	}
+}
+
+type rmspropState struct {
+	decay   float64
+	epsilon float64
+	mean    []float64
+}
+
+func (r *rmspropState) Update(params, step []float64, learningRate float64) {
+	for idx, s := range step {
+		r.mean[idx] = r.decay*r.mean[idx] + (1-r.decay)*s*s
+		params[idx] += learningRate * s / (math.Sqrt(r.mean[idx]) + r.epsilon)
+	}
+}
+
+func (r *rmspropState) Clone() State {
+	return &rmspropState{
+		decay:   r.decay,
+		epsilon: r.epsilon,
+		mean:    append([]float64(nil), r.mean...),
+	}
+}
+
+func (r *rmspropState) WriteTo(w io.Writer) (int64, error) {
+	return writeState(w, "rmsprop", 0, r.mean)
+}
+
+func (r *rmspropState) ReadFrom(rd io.Reader) (int64, error) {
+	sz, _, err := readState(rd, "rmsprop", r.mean)
+	return sz, err
+}
+
+// Adam keeps bias corrected moving averages of the steps and their squares, and moves the parameters along
+// the first moment scaled by the inverse square root of the second moment.
+//
+// Beta1 and Beta2 are the decay rates of the first and second moment and default to 0.9 and 0.999. Epsilon
+// avoids division by zero and defaults to 1e-8.
+type Adam struct {
+	Beta1   float64
+	Beta2   float64
+	Epsilon float64
+}
+
+func (a Adam) NewState(size int) State {
+	return &adamState{
+		beta1:   withDefault(a.Beta1, 0.9),
+		beta2:   withDefault(a.Beta2, 0.999),
+		epsilon: withDefault(a.Epsilon, 1e-8),
+		first:   make([]float64, size),
+		second:  make([]float64, size),
+	}
+}
+
+type adamState struct {
+	beta1, beta2, epsilon float64
+
+	steps  uint64
+	first  []float64
+	second []float64
+}
+
+func (a *adamState) Update(params, step []float64, learningRate float64) {
+	a.steps++
+
+	correction1 := 1 - math.Pow(a.beta1, float64(a.steps))
+	correction2 := 1 - math.Pow(a.beta2, float64(a.steps))
+
+	for idx, s := range step {
+		a.first[idx] = a.beta1*a.first[idx] + (1-a.beta1)*s
+		a.second[idx] = a.beta2*a.second[idx] + (1-a.beta2)*s*s
+
+		m := a.first[idx] / correction1
+		v := a.second[idx] / correction2
+
+		params[idx] += learningRate * m / (math.Sqrt(v) + a.epsilon)
+	}
+}
+
+func (a *adamState) Clone() State {
+	return &adamState{
+		beta1:   a.beta1,
+		beta2:   a.beta2,
+		epsilon: a.epsilon,
+		steps:   a.steps,
+		first:   append([]float64(nil), a.first...),
+		second:  append([]float64(nil), a.second...),
+	}
+}
+
+func (a *adamState) WriteTo(w io.Writer) (int64, error) {
+	return writeState(w, "adam", a.steps, a.first, a.second)
+}
+
+func (a *adamState) ReadFrom(r io.Reader) (int64, error) {
+	sz, steps, err := readState(r, "adam", a.first, a.second)
+	if err != nil {
+		return sz, err
+	}
+
+	a.steps = steps
+
+	return sz, nil
+}
+
+func withDefault(v, def float64) float64 {
+	if v == 0 {
+		return def
+	}
+	return v
+}
+
+// stateHeader prefixes every persisted state. It identifies the optimizer the state belongs to, so that
+// restoring a state into a different optimizer fails instead of silently mixing up values.
+type stateHeader struct {
+	Name   [8]byte
+	Steps  uint64
+	Slices uint64
+}
+
+// writeState writes a header with name and steps, followed by the length and contents of each slice.
+func writeState(w io.Writer, name string, steps uint64, slices ...[]float64) (int64, error) {
+	hdr := stateHeader{
+		Steps:  steps,
+		Slices: uint64(len(slices)),
+	}
+	copy(hdr.Name[:], name)
+
+	err := binary.Write(w, binary.LittleEndian, hdr)
+	if err != nil {
+		return 0, err
+	}
+	sz := int64(binary.Size(hdr))
+
+	for _, s := range slices {
+		err = binary.Write(w, binary.LittleEndian, uint64(len(s)))
+		if err != nil {
+			return sz, err
+		}
+		sz += 8
+
+		err = binary.Write(w, binary.LittleEndian, s)
+		if err != nil {
+			return sz, err
+		}
+		sz += int64(8 * len(s))
+	}
+
+	return sz, nil
+}
+
+// readState reads a state written by writeState into the given slices, which must have the same lengths as
+// the ones that were written. It returns the number of bytes read and the persisted number of steps.
+func readState(r io.Reader, name string, slices ...[]float64) (int64, uint64, error) {
+	var hdr stateHeader
+
+	err := binary.Read(r, binary.LittleEndian, &hdr)
+	if err != nil {
+		return 0, 0, err
+	}
+	sz := int64(binary.Size(hdr))
+
+	var expected [8]byte
+	copy(expected[:], name)
+
+	if hdr.Name != expected {
+		return sz, 0, fmt.Errorf("state belongs to optimizer %q, expected %q", trimName(hdr.Name), name)
+	}
+
+	if hdr.Slices != uint64(len(slices)) {
+		return sz, 0, fmt.Errorf("state has %d slices, expected %d", hdr.Slices, len(slices))
+	}
+
+	for idx, s := range slices {
+		var l uint64
+
+		err = binary.Read(r, binary.LittleEndian, &l)
+		if err != nil {
+			return sz, 0, err
+		}
+		sz += 8
+
+		if l != uint64(len(s)) {
+			return sz, 0, fmt.Errorf("slice %d has length %d, expected %d", idx, l, len(s))
+		}
+
+		err = binary.Read(r, binary.LittleEndian, s)
+		if err != nil {
+			return sz, 0, err
+		}
+		sz += int64(8 * len(s))
+	}
+
+	return sz, hdr.Steps, nil
+}
+
+func trimName(name [8]byte) string {
+	for idx, c := range name {
+		if c == 0 {
+			return string(name[:idx])
+		}
+	}
+	return string(name[:])
+}
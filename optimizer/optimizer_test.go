@@ -0,0 +1,140 @@
+package optimizer
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+var optimizers = map[string]Optimizer{
+	"sgd":      SGD{},
+	"momentum": Momentum{Mu: 0.9},
+	"nesterov": Momentum{Mu: 0.9, Nesterov: true},
+	"adagrad":  Adagrad{},
+	"rmsprop":  RMSProp{},
+	"adam":     Adam{},
+}
+
+// minimize runs the given optimizer on f(x) = sum((x - 3)^2) for a number of steps and returns x.
+func minimize(s State, x []float64, learningRate float64, steps int) []float64 {
+	step := make([]float64, len(x))
+
+	for i := 0; i < steps; i++ {
+		for idx, v := range x {
+			step[idx] = -2 * (v - 3)
+		}
+		s.Update(x, step, learningRate)
+	}
+
+	return x
+}
+
+func TestOptimizersMinimizeQuadratic(t *testing.T) {
+	for name, o := range optimizers {
+		learningRate := 0.05
+		if name == "adagrad" {
+			// Adagrad's effective learning rate shrinks quickly
+			learningRate = 1
+		}
+
+		x := minimize(o.NewState(2), []float64{-1, 10}, learningRate, 1000)
+
+		for idx, v := range x {
+			if math.Abs(v-3) > 1e-3 {
+				t.Errorf(`%s: x[%d] = %f, expected 3`, name, idx, v)
+			}
+		}
+	}
+}
+
+func TestMomentumUpdate(t *testing.T) {
+	s := Momentum{Mu: 0.5}.NewState(1)
+	params := []float64{0}
+
+	s.Update(params, []float64{1}, 0.1)
+	if params[0] != 0.1 {
+		t.Errorf(`first update: expected 0.1, got %f`, params[0])
+	}
+
+	// Velocity is now 0.5 * 0.1 + 0.1 = 0.15
+	s.Update(params, []float64{1}, 0.1)
+	if math.Abs(params[0]-0.25) > 1e-15 {
+		t.Errorf(`second update: expected 0.25, got %f`, params[0])
+	}
+}
+
+func TestAdamFirstStep(t *testing.T) {
+	// Thanks to bias correction, the first step of Adam has the size of the learning rate regardless of the
+	// magnitude of the gradient.
+	s := Adam{}.NewState(2)
+	params := []float64{0, 0}
+
+	s.Update(params, []float64{1000, -0.001}, 0.01)
+
+	if math.Abs(params[0]-0.01) > 1e-6 || math.Abs(params[1]+0.01) > 1e-6 {
+		t.Errorf(`unexpected first step: %v`, params)
+	}
+}
+
+func TestStateSnapshotAndRestore(t *testing.T) {
+	for name, o := range optimizers {
+		s1 := o.NewState(3)
+		minimize(s1, []float64{0, 1, 2}, 0.01, 10)
+
+		var buf bytes.Buffer
+
+		wsz, err := s1.WriteTo(&buf)
+		if err != nil {
+			t.Fatalf(`%s: can't snapshot state: %s`, name, err)
+		}
+
+		s2 := o.NewState(3)
+		rsz, err := s2.ReadFrom(&buf)
+		if err != nil {
+			t.Fatalf(`%s: can't restore state: %s`, name, err)
+		}
+
+		if wsz != rsz {
+			t.Errorf(`%s: wrote %d bytes, but read %d`, name, wsz, rsz)
+		}
+
+		// Both states have to produce identical updates from here on
+		x1 := minimize(s1, []float64{1, 1, 1}, 0.01, 10)
+		x2 := minimize(s2, []float64{1, 1, 1}, 0.01, 10)
+
+		for idx := range x1 {
+			if x1[idx] != x2[idx] {
+				t.Errorf(`%s: restored state diverged: %v vs %v`, name, x1, x2)
+				break
+			}
+		}
+	}
+}
+
+func TestStateRestoreFromOtherOptimizer(t *testing.T) {
+	var buf bytes.Buffer
+
+	_, err := Adam{}.NewState(2).WriteTo(&buf)
+	if err != nil {
+		t.Fatal(`can't snapshot state:`, err)
+	}
+
+	_, err = RMSProp{}.NewState(2).ReadFrom(&buf)
+	if err == nil {
+		t.Error(`expected an error when restoring Adam state into RMSProp`)
+	}
+}
+
+func TestStateRestoreWrongSize(t *testing.T) {
+	var buf bytes.Buffer
+
+	_, err := Momentum{Mu: 0.9}.NewState(2).WriteTo(&buf)
+	if err != nil {
+		t.Fatal(`can't snapshot state:`, err)
+	}
+
+	_, err = Momentum{Mu: 0.9}.NewState(3).ReadFrom(&buf)
+	if err == nil {
+		t.Error(`expected an error when restoring state of a different size`)
+	}
+}
@@ -0,0 +1,335 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Pool2DConf configures a MaxPool2D or AvgPool2D layer.
+//
+// Input is the shape of the inputs of the layer. Each channel is pooled separately over windows of Size x Size
+// values, which are moved by Stride rows and columns at a time, 0 means Size. Windows that don't fit into the
+// inputs completely are dropped.
+type Pool2DConf struct {
+	Input  Shape
+	Size   int
+	Stride int
+}
+
+// pool2D holds what MaxPool2D and AvgPool2D have in common.
+type pool2D struct {
+	noParameters
+
+	input  Shape
+	output Shape
+
+	windows [][]int // Positions in the inputs that are pooled into each output
+}
+
+// newPool2D validates conf and computes the windows of a pooling layer.
+func newPool2D(conf Pool2DConf) (pool2D, error) {
+	if conf.Size <= 0 || conf.Stride < 0 {
+		return pool2D{}, errors.New("pool size must be positive and stride must not be negative")
+	}
+
+	if conf.Stride == 0 {
+		conf.Stride = conf.Size
+	}
+
+	return newPool(conf.Input, conf.Size, conf.Size, conf.Stride)
+}
+
+// newGlobalPool computes the windows of a pooling layer that pools each channel of the given shape into a single
+// value.
+func newGlobalPool(input Shape) (pool2D, error) {
+	return newPool(input, input.Height, input.Width, 1)
+}
+
+// newPool computes the windows of a pooling layer with windows of the given size and stride.
+func newPool(input Shape, height, width, stride int) (pool2D, error) {
+	if input.Channels <= 0 || input.Height <= 0 || input.Width <= 0 {
+		return pool2D{}, fmt.Errorf("invalid input shape %+v", input)
+	}
+
+	output := Shape{
+		Channels: input.Channels,
+		Height:   convOutputSize(input.Height, height, stride, 0),
+		Width:    convOutputSize(input.Width, width, stride, 0),
+	}
+	if output.Height <= 0 || output.Width <= 0 {
+		return pool2D{}, fmt.Errorf("pool of size %dx%d doesn't fit into inputs of shape %+v", height, width, input)
+	}
+
+	p := pool2D{
+		input:   input,
+		output:  output,
+		windows: make([][]int, output.Size()),
+	}
+
+	for c := 0; c < output.Channels; c++ {
+		for oy := 0; oy < output.Height; oy++ {
+			for ox := 0; ox < output.Width; ox++ {
+				window := make([]int, 0, height*width)
+
+				for y := oy * stride; y < oy*stride+height; y++ {
+					for x := ox * stride; x < ox*stride+width; x++ {
+						window = append(window, input.index(c, y, x))
+					}
+				}
+
+				p.windows[output.index(c, oy, ox)] = window
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// Dims returns the number of inputs and outputs of the layer.
+func (p pool2D) Dims() (int, int) {
+	return p.input.Size(), p.output.Size()
+}
+
+// OutputShape returns the shape of the outputs of the layer.
+func (p pool2D) OutputShape() Shape {
+	return p.output
+}
+
+// MaxPool2D is a pooling layer that passes on the largest value of each window. Errors are propagated to the
+// inputs that were passed on. It is configured with a Pool2DConf and has no parameters. NewGlobalMaxPool creates
+// a MaxPool2D with a single window per channel.
+type MaxPool2D struct {
+	pool2D
+
+	argmax []int // Position of the largest input in each window during the last forward pass
+	out    []float64
+
+	batchArgmax [][]int // Positions of the largest inputs of each sample passed to ForwardBatch
+}
+
+// NewMaxPool2D creates a max pooling layer configured by conf.
+func NewMaxPool2D(conf Pool2DConf) (*MaxPool2D, error) {
+	p, err := newPool2D(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMaxPool2D(p), nil
+}
+
+// NewGlobalMaxPool creates a layer that passes on the largest value of each channel of inputs with the given
+// shape. Its outputs have the shape Shape{input.Channels, 1, 1}. Applied to the outputs of a Conv1D, it detects
+// features anywhere in a sequence.
+func NewGlobalMaxPool(input Shape) (*MaxPool2D, error) {
+	p, err := newGlobalPool(input)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMaxPool2D(p), nil
+}
+
+func newMaxPool2D(p pool2D) *MaxPool2D {
+	return &MaxPool2D{
+		pool2D: p,
+		argmax: make([]int, p.output.Size()),
+		out:    make([]float64, p.output.Size()),
+	}
+}
+
+// pool writes the largest input of each window to dst, and its position to argmax.
+func (l *MaxPool2D) pool(dst []float64, argmax []int, inputs []float64) {
+	for o, window := range l.windows {
+		best := window[0]
+		for _, idx := range window[1:] {
+			if inputs[idx] > inputs[best] {
+				best = idx
+			}
+		}
+
+		argmax[o] = best
+		dst[o] = inputs[best]
+	}
+}
+
+// maxUnpool adds the error at each output to the error of the input it was taken from in dst, and returns dst.
+func maxUnpool(dst []float64, argmax []int, error []float64) []float64 {
+	for o, e := range error {
+		dst[argmax[o]] += e
+	}
+	return dst
+}
+
+// Forward pools the given inputs and keeps track of the pooled positions for Backward.
+func (l *MaxPool2D) Forward(inputs []float64, training bool, rng *rand.Rand) ([]float64, error) {
+	l.pool(l.out, l.argmax, inputs)
+	return l.out, nil
+}
+
+// Predict pools the given inputs like Forward and writes the result to dst. It is safe for concurrent use.
+func (l *MaxPool2D) Predict(dst, inputs []float64) error {
+	l.pool(dst, make([]int, len(dst)), inputs)
+	return nil
+}
+
+// Backward returns the error at the inputs for the error at the outputs of the last forward pass.
+func (l *MaxPool2D) Backward(error []float64) []float64 {
+	return maxUnpool(make([]float64, l.input.Size()), l.argmax, error)
+}
+
+// ForwardBatch pools a batch of inputs with one sample per row. The state used by Backward is left untouched.
+func (l *MaxPool2D) ForwardBatch(inputs *mat.Dense, training bool, rng *rand.Rand) (*mat.Dense, error) {
+	samples, _ := inputs.Dims()
+
+	res := mat.NewDense(samples, l.output.Size(), nil)
+	l.batchArgmax = make([][]int, samples)
+
+	for i := range l.batchArgmax {
+		l.batchArgmax[i] = make([]int, l.output.Size())
+		l.pool(res.RawRowView(i), l.batchArgmax[i], inputs.RawRowView(i))
+	}
+
+	return res, nil
+}
+
+// BackwardBatch returns the errors at the inputs for the errors at the outputs of the batch passed to the last call
+// of ForwardBatch.
+func (l *MaxPool2D) BackwardBatch(error *mat.Dense) *mat.Dense {
+	samples, _ := error.Dims()
+
+	res := mat.NewDense(samples, l.input.Size(), nil)
+	for i := 0; i < samples; i++ {
+		maxUnpool(res.RawRowView(i), l.batchArgmax[i], error.RawRowView(i))
+	}
+
+	return res
+}
+
+// Clone returns a copy of l.
+func (l *MaxPool2D) Clone() Layer {
+	return &MaxPool2D{
+		pool2D: l.pool2D,
+		argmax: append([]int{}, l.argmax...),
+		out:    make([]float64, len(l.out)),
+
+		// ForwardBatch replaces the positions instead of modifying them, so they can be shared
+		batchArgmax: l.batchArgmax,
+	}
+}
+
+var _ Layer = &MaxPool2D{}
+
+// AvgPool2D is a pooling layer that passes on the average of each window. Errors are distributed evenly over the
+// inputs of each window. It is configured with a Pool2DConf and has no parameters. NewGlobalAvgPool creates an
+// AvgPool2D with a single window per channel.
+type AvgPool2D struct {
+	pool2D
+
+	out []float64
+}
+
+// NewAvgPool2D creates an average pooling layer configured by conf.
+func NewAvgPool2D(conf Pool2DConf) (*AvgPool2D, error) {
+	p, err := newPool2D(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return newAvgPool2D(p), nil
+}
+
+// NewGlobalAvgPool creates a layer that passes on the average of each channel of inputs with the given shape. Its
+// outputs have the shape Shape{input.Channels, 1, 1}.
+func NewGlobalAvgPool(input Shape) (*AvgPool2D, error) {
+	p, err := newGlobalPool(input)
+	if err != nil {
+		return nil, err
+	}
+
+	return newAvgPool2D(p), nil
+}
+
+func newAvgPool2D(p pool2D) *AvgPool2D {
+	return &AvgPool2D{
+		pool2D: p,
+		out:    make([]float64, p.output.Size()),
+	}
+}
+
+// pool writes the average of each window of inputs to dst.
+func (l *AvgPool2D) pool(dst, inputs []float64) {
+	for o, window := range l.windows {
+		sum := float64(0)
+		for _, idx := range window {
+			sum += inputs[idx]
+		}
+
+		dst[o] = sum / float64(len(window))
+	}
+}
+
+// unpool distributes the error at each output evenly over its window in dst, and returns dst.
+func (l *AvgPool2D) unpool(dst, error []float64) []float64 {
+	for o, window := range l.windows {
+		e := error[o] / float64(len(window))
+		for _, idx := range window {
+			dst[idx] += e
+		}
+	}
+
+	return dst
+}
+
+// Forward pools the given inputs.
+func (l *AvgPool2D) Forward(inputs []float64, training bool, rng *rand.Rand) ([]float64, error) {
+	l.pool(l.out, inputs)
+	return l.out, nil
+}
+
+// Predict pools the given inputs like Forward and writes the result to dst. It is safe for concurrent use.
+func (l *AvgPool2D) Predict(dst, inputs []float64) error {
+	l.pool(dst, inputs)
+	return nil
+}
+
+// Backward returns the error at the inputs for the given error at the outputs.
+func (l *AvgPool2D) Backward(error []float64) []float64 {
+	return l.unpool(make([]float64, l.input.Size()), error)
+}
+
+// ForwardBatch pools a batch of inputs with one sample per row.
+func (l *AvgPool2D) ForwardBatch(inputs *mat.Dense, training bool, rng *rand.Rand) (*mat.Dense, error) {
+	samples, _ := inputs.Dims()
+
+	res := mat.NewDense(samples, l.output.Size(), nil)
+	for i := 0; i < samples; i++ {
+		l.pool(res.RawRowView(i), inputs.RawRowView(i))
+	}
+
+	return res, nil
+}
+
+// BackwardBatch returns the errors at the inputs for the given errors at the outputs, one sample per row.
+func (l *AvgPool2D) BackwardBatch(error *mat.Dense) *mat.Dense {
+	samples, _ := error.Dims()
+
+	res := mat.NewDense(samples, l.input.Size(), nil)
+	for i := 0; i < samples; i++ {
+		l.unpool(res.RawRowView(i), error.RawRowView(i))
+	}
+
+	return res
+}
+
+// Clone returns a copy of l.
+func (l *AvgPool2D) Clone() Layer {
+	return &AvgPool2D{
+		pool2D: l.pool2D,
+		out:    make([]float64, len(l.out)),
+	}
+}
+
+var _ Layer = &AvgPool2D{}
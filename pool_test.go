@@ -0,0 +1,198 @@
+package network
+
+import (
+	"testing"
+
+	"gonum.org/v1/gonum/floats"
+)
+
+func TestPool2D(t *testing.T) {
+	inputs := []float64{
+		1, 2, 3, 4,
+		5, 6, 7, 8,
+		9, 0, 1, 2,
+		3, 4, 5, 9,
+
+		-1, -2, -3, -4,
+		-5, -6, -7, -8,
+		-9, -1, -1, -2,
+		-3, -4, -5, -6,
+	}
+
+	for _, tc := range []struct {
+		name     string
+		conf     Pool2DConf
+		avg      bool
+		shape    Shape
+		expected []float64
+	}{
+		{"max", Pool2DConf{Input: Shape{2, 4, 4}, Size: 2}, false, Shape{2, 2, 2}, []float64{
+			6, 8,
+			9, 9,
+
+			-1, -3,
+			-1, -1,
+		}},
+		{"max/strided", Pool2DConf{Input: Shape{2, 4, 4}, Size: 3, Stride: 1}, false, Shape{2, 2, 2}, []float64{
+			9, 8,
+			9, 9,
+
+			-1, -1,
+			-1, -1,
+		}},
+		{"avg", Pool2DConf{Input: Shape{2, 4, 4}, Size: 2}, true, Shape{2, 2, 2}, []float64{
+			3.5, 5.5,
+			4, 4.25,
+
+			-3.5, -5.5,
+			-4.25, -3.5,
+		}},
+		{"avg/partial", Pool2DConf{Input: Shape{1, 4, 4}, Size: 3}, true, Shape{1, 1, 1}, []float64{
+			34.0 / 9,
+		}},
+	} {
+		var (
+			l     Layer
+			shape Shape
+			err   error
+		)
+
+		if tc.avg {
+			var p *AvgPool2D
+			p, err = NewAvgPool2D(tc.conf)
+			if err == nil {
+				l, shape = p, p.OutputShape()
+			}
+		} else {
+			var p *MaxPool2D
+			p, err = NewMaxPool2D(tc.conf)
+			if err == nil {
+				l, shape = p, p.OutputShape()
+			}
+		}
+		if err != nil {
+			t.Errorf(`%s: can't create layer: %v`, tc.name, err)
+			continue
+		}
+
+		if shape != tc.shape {
+			t.Errorf(`%s: expected output shape %+v, got %+v`, tc.name, tc.shape, shape)
+		}
+
+		outputs, err := l.Forward(inputs[:tc.conf.Input.Size()], false, nil)
+		if err != nil {
+			t.Fatalf(`%s: forward pass failed: %v`, tc.name, err)
+		}
+
+		if !floats.EqualApprox(outputs, tc.expected, 1e-12) {
+			t.Errorf(`%s: expected outputs %v, got %v`, tc.name, tc.expected, outputs)
+		}
+	}
+}
+
+func TestNewPool2DInvalid(t *testing.T) {
+	for _, conf := range []Pool2DConf{
+		{Input: Shape{1, 4, 4}},
+		{Input: Shape{1, 4, 4}, Size: 2, Stride: -1},
+		{Input: Shape{1, 0, 4}, Size: 2},
+		{Input: Shape{1, 4, 4}, Size: 5},
+	} {
+		_, err := NewMaxPool2D(conf)
+		if err == nil {
+			t.Errorf(`%+v: expected an error`, conf)
+		}
+
+		_, err = NewAvgPool2D(conf)
+		if err == nil {
+			t.Errorf(`%+v: expected an error`, conf)
+		}
+	}
+}
+
+func TestPool2DGradients(t *testing.T) {
+	rng := testRand()
+
+	for _, conf := range []Pool2DConf{
+		{Input: Shape{2, 4, 4}, Size: 2},
+		{Input: Shape{3, 5, 6}, Size: 3, Stride: 2},
+	} {
+		maxPool, err := NewMaxPool2D(conf)
+		if err != nil {
+			t.Fatal(`can't create layer:`, err)
+		}
+
+		avgPool, err := NewAvgPool2D(conf)
+		if err != nil {
+			t.Fatal(`can't create layer:`, err)
+		}
+
+		for _, l := range []Layer{maxPool, avgPool} {
+			checkLayerGradients(t, l, randomInputs(3, conf.Input.Size(), rng), rng)
+		}
+	}
+}
+
+func TestGlobalPool(t *testing.T) {
+	input := Shape{2, 2, 3}
+	inputs := []float64{
+		1, 5, 3,
+		2, 0, 1,
+
+		-1, -2, -3,
+		-4, -5, -3,
+	}
+
+	maxPool, err := NewGlobalMaxPool(input)
+	if err != nil {
+		t.Fatal(`can't create layer:`, err)
+	}
+
+	avgPool, err := NewGlobalAvgPool(input)
+	if err != nil {
+		t.Fatal(`can't create layer:`, err)
+	}
+
+	rng := testRand()
+
+	for _, tc := range []struct {
+		layer    Layer
+		shape    Shape
+		expected []float64
+	}{
+		{maxPool, maxPool.OutputShape(), []float64{5, -1}},
+		{avgPool, avgPool.OutputShape(), []float64{2, -3}},
+	} {
+		if tc.shape != (Shape{2, 1, 1}) {
+			t.Errorf(`%T: unexpected output shape %+v`, tc.layer, tc.shape)
+		}
+
+		outputs, err := tc.layer.Forward(inputs, false, nil)
+		if err != nil {
+			t.Fatalf(`%T: forward pass failed: %v`, tc.layer, err)
+		}
+
+		if !floats.Equal(outputs, tc.expected) {
+			t.Errorf(`%T: expected outputs %v, got %v`, tc.layer, tc.expected, outputs)
+		}
+
+		checkLayerGradients(t, tc.layer, randomInputs(3, input.Size(), rng), rng)
+	}
+
+	_, err = NewGlobalMaxPool(Shape{})
+	if err == nil {
+		t.Error(`expected an error for an empty input shape`)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	l := NewFlatten(Shape{2, 3, 2})
+
+	inputs, outputs := l.Dims()
+	if inputs != 12 || outputs != 12 {
+		t.Errorf(`unexpected dimensions %d -> %d`, inputs, outputs)
+	}
+
+	rng := testRand()
+
+	checkLayerGradients(t, l, randomInputs(2, 12, rng), rng)
+}
@@ -0,0 +1,569 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+
+	"github.com/farhaven/nn-go/activation"
+	"github.com/farhaven/nn-go/initializer"
+)
+
+// Recurrent is implemented by layers that keep a state between the steps of a sequence, like RNN, LSTM and GRU.
+// The outputs of such a layer depend on its inputs and on its state, which is updated with every step.
+//
+// ForwardSequence computes the outputs for a sequence of inputs with one step per row, starting from the current
+// state. It leaves the layer in the state after the last step, and keeps whatever it needs for a following call
+// of BackwardSequence.
+//
+// BackwardSequence takes the errors at the outputs of each step of the last call of ForwardSequence and
+// propagates them backwards through time. It computes the steps for the parameters of the layer, summed over all
+// steps of the sequence, and returns the errors at the inputs of each step. The errors don't flow into the state
+// the sequence started from, so processing a long sequence in chunks results in truncated backpropagation through
+// time.
+//
+// The methods of Layer treat their inputs as a single step. Forward advances the state by one step, and Backward
+// propagates the error through that step only. Predict and ForwardBatch compute one step from the current state
+// for each of their inputs, without changing the state.
+//
+// State returns a copy of the current state, and SetState replaces it. ResetState sets the state to zero, which
+// is also the state of new layers. The first entries of the state are the outputs of the last step.
+type Recurrent interface {
+	Layer
+
+	ForwardSequence(inputs *mat.Dense, training bool, rng *rand.Rand) (*mat.Dense, error)
+	BackwardSequence(error *mat.Dense) *mat.Dense
+
+	ResetState()
+	State() []float64
+	SetState(state []float64) error
+}
+
+// RecurrentConf configures an RNN, LSTM or GRU layer.
+//
+// Units is the number of outputs of the layer, which are fed back into it as part of its state at the next step.
+//
+// Activation is applied to the weighted inputs and outputs of an RNN, nil means Tanh. LSTM and GRU have a fixed
+// set of activations, Activation has to be nil for them. Trainable activations are not supported.
+//
+// Initializer chooses the initial weights for the inputs like for LayerConf, and RecurrentInitializer the ones for
+// the outputs fed back into the layer. If RecurrentInitializer is nil, orthogonal initialization is used, which
+// keeps the state from growing or vanishing over long sequences. The weights of each gate of an LSTM or GRU are
+// initialized separately.
+//
+// If Bias is set, the layer gets trainable biases. The biases of the forget gate of an LSTM start out at 1, so
+// that it keeps its state by default, all others start out at 0.
+type RecurrentConf struct {
+	Units                int
+	Activation           activation.Activation
+	Initializer          initializer.Initializer
+	RecurrentInitializer initializer.Initializer
+	Bias                 bool
+}
+
+// cell computes the steps of a recurrent layer. The weighted inputs and previous outputs of all gates of the cell
+// are computed by the layer, the cell combines them into the new state.
+type cell interface {
+	// gates returns the number of gates of the cell. Each gate has its own weights.
+	gates() int
+
+	// stateSize and cacheSize return the size of the state and of the intermediate values kept for backward
+	// for a layer with the given number of units.
+	stateSize(units int) int
+	cacheSize(units int) int
+
+	// forward computes the new state of t from its weighted inputs and previous outputs and its previous state.
+	forward(t *timeStep, units int)
+
+	// backward computes the deltas of the weighted inputs and previous outputs of t from the error of its new
+	// state, and adds the error of the previous state that doesn't flow through the weights to prev.
+	backward(t *timeStep, units int, error, inputDelta, outputDelta, prev []float64)
+}
+
+// timeStep holds the values computed by a recurrent layer for a single step.
+type timeStep struct {
+	input []float64
+	prev  []float64 // State before the step
+	a     []float64 // Weighted inputs plus biases of each gate
+	u     []float64 // Weighted previous outputs of each gate
+	cache []float64 // Intermediate values of the cell
+	state []float64 // State after the step
+}
+
+// recurrent implements what RNN, LSTM and GRU have in common.
+type recurrent struct {
+	cell  cell
+	units int
+
+	weights          *mat.Dense // One row per unit and gate, with the weights of each gate in turn
+	recurrentWeights *mat.Dense // Weights of the previous outputs, in the same layout as weights
+	bias             []float64  // nil if the layer is unbiased
+
+	weightStep          *mat.Dense
+	recurrentWeightStep *mat.Dense
+	biasStep            []float64
+
+	state []float64
+
+	history []*timeStep // Steps of the last call of Forward or ForwardSequence
+	batch   []*timeStep // Steps of the last call of ForwardBatch
+}
+
+// init sets up l with the given cell and number of inputs, and initializes its parameters as configured by conf.
+// act is the activation that determines the default initializer of the input weights.
+func (l *recurrent) init(c cell, inputs int, conf RecurrentConf, act activation.Activation, rng *rand.Rand) error {
+	if conf.Units <= 0 {
+		return errors.New("number of units must be positive")
+	}
+
+	if inputs <= 0 {
+		return errors.New("number of inputs must be positive")
+	}
+
+	inputInit := conf.Initializer
+	if inputInit == nil {
+		inputInit = defaultInitializer(act)
+	}
+
+	recurrentInit := conf.RecurrentInitializer
+	if recurrentInit == nil {
+		recurrentInit = initializer.Orthogonal{}
+	}
+
+	rows := c.gates() * conf.Units
+
+	l.cell = c
+	l.units = conf.Units
+	l.weights = mat.NewDense(rows, inputs, nil)
+	l.recurrentWeights = mat.NewDense(rows, conf.Units, nil)
+
+	for gate := 0; gate < c.gates(); gate++ {
+		from, to := gate*conf.Units, (gate+1)*conf.Units
+
+		inputInit.Initialize(l.weights.Slice(from, to, 0, inputs).(*mat.Dense), rng)
+		recurrentInit.Initialize(l.recurrentWeights.Slice(from, to, 0, conf.Units).(*mat.Dense), rng)
+	}
+
+	// Biases start out at zero, cells that need anything else adjust them
+	if conf.Bias {
+		l.bias = make([]float64, rows)
+	}
+
+	l.allocate()
+
+	return nil
+}
+
+// allocate allocates the buffers for the steps and the state of l.
+func (l *recurrent) allocate() {
+	rows, inputs := l.weights.Dims()
+
+	l.weightStep = mat.NewDense(rows, inputs, nil)
+	l.recurrentWeightStep = mat.NewDense(rows, l.units, nil)
+	if l.bias != nil {
+		l.biasStep = make([]float64, rows)
+	}
+
+	l.state = make([]float64, l.cell.stateSize(l.units))
+}
+
+// clone initializes dst as a deep copy of l, including its state.
+func (l *recurrent) clone(dst *recurrent) {
+	dst.cell = l.cell
+	dst.units = l.units
+	dst.weights = mat.DenseCopyOf(l.weights)
+	dst.recurrentWeights = mat.DenseCopyOf(l.recurrentWeights)
+	if l.bias != nil {
+		dst.bias = append([]float64{}, l.bias...)
+	}
+
+	dst.allocate()
+
+	dst.weightStep.Copy(l.weightStep)
+	dst.recurrentWeightStep.Copy(l.recurrentWeightStep)
+	copy(dst.biasStep, l.biasStep)
+	copy(dst.state, l.state)
+
+	// Steps are never modified once they are computed, so they can be shared
+	dst.history = append([]*timeStep{}, l.history...)
+	dst.batch = append([]*timeStep{}, l.batch...)
+}
+
+// hasBias returns whether l has biases.
+func (l *recurrent) hasBias() bool {
+	return l.bias != nil
+}
+
+// Dims returns the number of inputs and outputs of l.
+func (l *recurrent) Dims() (int, int) {
+	_, inputs := l.weights.Dims()
+	return inputs, l.units
+}
+
+// ResetState sets the state of l to zero.
+func (l *recurrent) ResetState() {
+	for idx := range l.state {
+		l.state[idx] = 0
+	}
+}
+
+// State returns a copy of the state of l.
+func (l *recurrent) State() []float64 {
+	return append([]float64{}, l.state...)
+}
+
+// SetState replaces the state of l with a copy of state, which must have the size of the one returned by State.
+func (l *recurrent) SetState(state []float64) error {
+	if len(state) != len(l.state) {
+		return fmt.Errorf("got state of size %d, expected %d", len(state), len(l.state))
+	}
+
+	copy(l.state, state)
+
+	return nil
+}
+
+// step computes a single step of l for the given inputs, starting from the state prev. sample is the index of
+// the step within a sequence or batch, it is only used to report non-finite outputs.
+func (l *recurrent) step(inputs, prev []float64, sample int) (*timeStep, error) {
+	rows, _ := l.weights.Dims()
+
+	t := &timeStep{
+		input: append([]float64{}, inputs...),
+		prev:  append([]float64{}, prev...),
+		a:     make([]float64, rows),
+		u:     make([]float64, rows),
+		cache: make([]float64, l.cell.cacheSize(l.units)),
+		state: make([]float64, len(prev)),
+	}
+
+	a := mat.NewVecDense(rows, t.a)
+	a.MulVec(l.weights, mat.NewVecDense(len(t.input), t.input))
+	if l.bias != nil {
+		floats.Add(t.a, l.bias)
+	}
+
+	u := mat.NewVecDense(rows, t.u)
+	u.MulVec(l.recurrentWeights, mat.NewVecDense(l.units, t.prev[:l.units]))
+
+	l.cell.forward(t, l.units)
+
+	return t, checkOutputs(t.a, t.state[:l.units], sample)
+}
+
+// backward propagates the errors at the outputs of the given steps backwards through l, and sets the steps for
+// the parameters of l to the sum of the steps for all of them. If linked is set, the steps form a sequence and
+// the errors flow from each step into the previous one. The errors at the inputs of each step are returned.
+func (l *recurrent) backward(steps []*timeStep, error *mat.Dense, linked bool) *mat.Dense {
+	rows, inputs := l.weights.Dims()
+
+	l.weightStep.Zero()
+	l.recurrentWeightStep.Zero()
+	for idx := range l.biasStep {
+		l.biasStep[idx] = 0
+	}
+
+	res := mat.NewDense(len(steps), inputs, nil)
+
+	stateError := make([]float64, len(l.state))
+	next := make([]float64, len(l.state)) // Error of the state after the current step from the following steps
+
+	inputDelta := make([]float64, rows)
+	outputDelta := make([]float64, rows)
+
+	for idx := len(steps) - 1; idx >= 0; idx-- {
+		t := steps[idx]
+
+		copy(stateError, next)
+		floats.Add(stateError[:l.units], error.RawRowView(idx))
+
+		for i := range next {
+			next[i] = 0
+		}
+
+		l.cell.backward(t, l.units, stateError, inputDelta, outputDelta, next)
+
+		// Compute: Step += Delta * Inputs^T
+		inputDeltaVec := mat.NewVecDense(rows, inputDelta)
+		outputDeltaVec := mat.NewVecDense(rows, outputDelta)
+
+		l.weightStep.RankOne(l.weightStep, 1, inputDeltaVec, mat.NewVecDense(inputs, t.input))
+		l.recurrentWeightStep.RankOne(l.recurrentWeightStep, 1, outputDeltaVec, mat.NewVecDense(l.units, t.prev[:l.units]))
+		if l.bias != nil {
+			floats.Add(l.biasStep, inputDelta)
+		}
+
+		inputError := mat.NewVecDense(inputs, res.RawRowView(idx))
+		inputError.MulVec(l.weights.T(), inputDeltaVec)
+
+		// Unlinked steps only get the errors at their own outputs
+		if !linked {
+			for i := range next {
+				next[i] = 0
+			}
+			continue
+		}
+
+		var prevOutputError mat.VecDense
+		prevOutputError.MulVec(l.recurrentWeights.T(), outputDeltaVec)
+		floats.Add(next[:l.units], prevOutputError.RawVector().Data)
+	}
+
+	return res
+}
+
+// Forward computes a single step of l for the given inputs, advances the state of l and returns the outputs of
+// the step.
+func (l *recurrent) Forward(inputs []float64, training bool, rng *rand.Rand) ([]float64, error) {
+	t, err := l.step(inputs, l.state, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	l.history = []*timeStep{t}
+	copy(l.state, t.state)
+
+	return t.state[:l.units], nil
+}
+
+// Predict computes a single step of l for the given inputs like Forward and writes the outputs to dst, but leaves
+// the state of l untouched. It is safe for concurrent use as long as the state isn't changed at the same time.
+func (l *recurrent) Predict(dst, inputs []float64) error {
+	t, err := l.step(inputs, l.state, 0)
+	if err != nil {
+		return err
+	}
+
+	copy(dst, t.state[:l.units])
+
+	return nil
+}
+
+// Backward computes the steps for the parameters of l from the error at the outputs of the last call of Forward,
+// and returns the error at its inputs. The error isn't propagated into the state before that step.
+func (l *recurrent) Backward(error []float64) []float64 {
+	return l.backward(l.history, mat.NewDense(1, len(error), error), false).RawRowView(0)
+}
+
+// ForwardBatch computes a single step of l for each row of inputs, each starting from the current state of l. It
+// changes neither the state of l nor what Backward works on.
+func (l *recurrent) ForwardBatch(inputs *mat.Dense, training bool, rng *rand.Rand) (*mat.Dense, error) {
+	samples, _ := inputs.Dims()
+
+	l.batch = make([]*timeStep, samples)
+	res := mat.NewDense(samples, l.units, nil)
+
+	for i := range l.batch {
+		t, err := l.step(inputs.RawRowView(i), l.state, i)
+		if err != nil {
+			return nil, err
+		}
+
+		l.batch[i] = t
+		res.SetRow(i, t.state[:l.units])
+	}
+
+	return res, nil
+}
+
+// BackwardBatch computes the averaged steps for the parameters of l from the errors at its outputs for the batch
+// passed to the last call of ForwardBatch, and returns the errors at its inputs.
+func (l *recurrent) BackwardBatch(error *mat.Dense) *mat.Dense {
+	res := l.backward(l.batch, error, false)
+	scaleSteps(l.Steps(), 1/float64(len(l.batch)))
+
+	return res
+}
+
+// ForwardSequence computes the outputs of l for a sequence of inputs with one step per row, starting from the
+// current state. The state of l is advanced to the one after the last step.
+func (l *recurrent) ForwardSequence(inputs *mat.Dense, training bool, rng *rand.Rand) (*mat.Dense, error) {
+	steps, _ := inputs.Dims()
+
+	l.history = make([]*timeStep, steps)
+	res := mat.NewDense(steps, l.units, nil)
+
+	state := l.state
+	for i := range l.history {
+		t, err := l.step(inputs.RawRowView(i), state, i)
+		if err != nil {
+			return nil, err
+		}
+
+		l.history[i] = t
+		res.SetRow(i, t.state[:l.units])
+
+		state = t.state
+	}
+
+	copy(l.state, state)
+
+	return res, nil
+}
+
+// BackwardSequence propagates the errors at the outputs of each step of the last call of ForwardSequence
+// backwards through time. It computes the steps for the parameters of l, summed over all steps, and returns the
+// errors at the inputs of each step.
+func (l *recurrent) BackwardSequence(error *mat.Dense) *mat.Dense {
+	return l.backward(l.history, error, true)
+}
+
+// Parameters returns the weights of the inputs of l in row-major order, followed by the weights of its outputs
+// fed back into it and the biases if l has any. Each unit has one row of weights per gate, with the rows of all
+// units for one gate next to each other.
+func (l *recurrent) Parameters() [][]float64 {
+	res := [][]float64{l.weights.RawMatrix().Data, l.recurrentWeights.RawMatrix().Data}
+
+	if l.bias != nil {
+		res = append(res, l.bias)
+	}
+
+	return res
+}
+
+// Steps returns the steps for the parameters of l, in the layout returned by Parameters.
+func (l *recurrent) Steps() [][]float64 {
+	res := [][]float64{l.weightStep.RawMatrix().Data, l.recurrentWeightStep.RawMatrix().Data}
+
+	if l.bias != nil {
+		res = append(res, l.biasStep)
+	}
+
+	return res
+}
+
+// WriteTo writes the weights of l to w, followed by the biases if l has any. The state is not included.
+func (l *recurrent) WriteTo(w io.Writer) (int64, error) {
+	var res int64
+
+	for _, m := range []*mat.Dense{l.weights, l.recurrentWeights} {
+		sz, err := m.MarshalBinaryTo(w)
+		res += int64(sz)
+		if err != nil {
+			return res, err
+		}
+	}
+
+	if l.bias == nil {
+		return res, nil
+	}
+
+	sz, err := mat.NewVecDense(len(l.bias), l.bias).MarshalBinaryTo(w)
+	return res + int64(sz), err
+}
+
+// ReadFrom restores the weights and biases of l from r. Snapshots of biased layers can only be restored into biased
+// layers and vice versa.
+func (l *recurrent) ReadFrom(r io.Reader) (int64, error) {
+	var (
+		res     int64
+		weights [2]mat.Dense
+	)
+
+	for idx, expected := range []*mat.Dense{l.weights, l.recurrentWeights} {
+		sz, err := weights[idx].UnmarshalBinaryFrom(r)
+		res += int64(sz)
+		if err != nil {
+			return res, err
+		}
+
+		r1, c1 := weights[idx].Dims()
+		r2, c2 := expected.Dims()
+		if r1 != r2 || c1 != c2 {
+			return res, fmt.Errorf("%w: weights have dimensions %dx%d, expected %dx%d", ErrArchitectureMismatch, r1, c1, r2, c2)
+		}
+	}
+
+	var bias mat.VecDense
+
+	sz, err := bias.UnmarshalBinaryFrom(r)
+	res += int64(sz)
+	switch {
+	case l.bias == nil && sz == 0 && (errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)):
+		// Snapshot of an unbiased layer
+	case err != nil:
+		return res, fmt.Errorf("restoring biases: %w", err)
+	case l.bias == nil:
+		return res, fmt.Errorf("%w: snapshot has biases, but the layer is unbiased", ErrArchitectureMismatch)
+	case bias.Len() != len(l.bias):
+		return res, fmt.Errorf("%w: %d biases, expected %d", ErrArchitectureMismatch, bias.Len(), len(l.bias))
+	}
+
+	l.weights.Copy(&weights[0])
+	l.recurrentWeights.Copy(&weights[1])
+	if l.bias != nil {
+		copy(l.bias, bias.RawVector().Data)
+	}
+
+	return res, nil
+}
+
+// RNN is a simple recurrent layer, also known as Elman network. At each step, its outputs are computed by applying
+// the activation to the weighted inputs and the weighted outputs of the previous step, plus an optional bias. Its
+// state consists of its outputs. It is configured with a RecurrentConf.
+type RNN struct {
+	recurrent
+}
+
+// NewRNN creates a simple recurrent layer with the given number of inputs, configured by conf. The weights are
+// initialized with random values drawn from rng.
+func NewRNN(inputs int, conf RecurrentConf, rng *rand.Rand) (*RNN, error) {
+	act := conf.Activation
+	if act == nil {
+		act = activation.Tanh{}
+	}
+
+	if _, ok := act.(activation.Trainable); ok {
+		return nil, errors.New("trainable activations are not supported")
+	}
+
+	l := &RNN{}
+
+	err := l.init(rnnCell{activation: act}, inputs, conf, act, rng)
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Clone returns a deep copy of l, including its state.
+func (l *RNN) Clone() Layer {
+	clone := &RNN{}
+	l.clone(&clone.recurrent)
+
+	return clone
+}
+
+var _ Recurrent = &RNN{}
+
+// rnnCell computes the steps of an RNN. Its cache holds the sums of the weighted inputs and outputs.
+type rnnCell struct {
+	activation activation.Activation
+}
+
+func (rnnCell) gates() int {
+	return 1
+}
+
+func (rnnCell) stateSize(units int) int {
+	return units
+}
+
+func (rnnCell) cacheSize(units int) int {
+	return units
+}
+
+func (c rnnCell) forward(t *timeStep, units int) {
+	floats.AddTo(t.cache, t.a, t.u)
+	activate(c.activation, t.state, t.cache)
+}
+
+func (c rnnCell) backward(t *timeStep, units int, error, inputDelta, outputDelta, prev []float64) {
+	computeDeltas(c.activation, inputDelta, t.cache, t.state, error)
+	copy(outputDelta, inputDelta)
+}
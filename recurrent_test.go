@@ -0,0 +1,806 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+
+	"github.com/farhaven/nn-go/activation"
+	"github.com/farhaven/nn-go/initializer"
+	"github.com/farhaven/nn-go/loss"
+	"github.com/farhaven/nn-go/optimizer"
+)
+
+// newRecurrent creates a layer of the given kind ("rnn", "lstm" or "gru").
+func newRecurrent(t *testing.T, kind string, inputs int, conf RecurrentConf, rng *rand.Rand) Recurrent {
+	t.Helper()
+
+	var (
+		l   Recurrent
+		err error
+	)
+
+	switch kind {
+	case "rnn":
+		l, err = NewRNN(inputs, conf, rng)
+	case "lstm":
+		l, err = NewLSTM(inputs, conf, rng)
+	case "gru":
+		l, err = NewGRU(inputs, conf, rng)
+	default:
+		t.Fatal(`unknown kind of layer:`, kind)
+	}
+	if err != nil {
+		t.Fatalf(`can't create %s layer: %v`, kind, err)
+	}
+
+	return l
+}
+
+// randomState sets the state of l to random values and returns them.
+func randomState(t *testing.T, l Recurrent, rng *rand.Rand) []float64 {
+	t.Helper()
+
+	state := l.State()
+	for idx := range state {
+		state[idx] = rng.Float64() - 0.5
+	}
+
+	err := l.SetState(state)
+	if err != nil {
+		t.Fatal(`can't set state:`, err)
+	}
+
+	return state
+}
+
+// checkSequenceGradients compares the errors and steps computed by BackwardSequence for a random error at each
+// step of the given sequence with numerical derivatives.
+func checkSequenceGradients(t *testing.T, l Recurrent, inputs *mat.Dense, rng *rand.Rand) {
+	t.Helper()
+
+	const h = 1e-6
+
+	steps, numInputs := inputs.Dims()
+	_, numOutputs := l.Dims()
+
+	state := randomState(t, l, rng)
+
+	outputErrors := mat.NewDense(steps, numOutputs, nil)
+	outputErrors.Apply(func(i, j int, v float64) float64 {
+		return rng.NormFloat64()
+	}, outputErrors)
+
+	// loss returns the dot product of the outputs for the sequence with the errors, so its derivatives are the
+	// errors
+	loss := func() float64 {
+		err := l.SetState(state)
+		if err != nil {
+			t.Fatal(`can't set state:`, err)
+		}
+
+		outputs, err := l.ForwardSequence(inputs, false, nil)
+		if err != nil {
+			t.Fatal(`forward pass failed:`, err)
+		}
+
+		outputs.MulElem(outputs, outputErrors)
+
+		return mat.Sum(outputs)
+	}
+
+	_, err := l.ForwardSequence(inputs, true, rng)
+	if err != nil {
+		t.Fatal(`forward pass failed:`, err)
+	}
+
+	final := l.State()
+
+	inputErrors := l.BackwardSequence(outputErrors)
+
+	// Copy the steps, since computing the loss doesn't leave them untouched
+	var expectedSteps [][]float64
+	for _, step := range l.Steps() {
+		expectedSteps = append(expectedSteps, append([]float64{}, step...))
+	}
+
+	for s := 0; s < steps; s++ {
+		x := inputs.RawRowView(s)
+
+		for idx := 0; idx < numInputs; idx++ {
+			v := x[idx]
+
+			x[idx] = v + h
+			plus := loss()
+			x[idx] = v - h
+			minus := loss()
+			x[idx] = v
+
+			expected := (plus - minus) / (2 * h)
+			if got := inputErrors.At(s, idx); math.Abs(expected-got) > 1e-5 {
+				t.Errorf(`step %d: error at input %d is %f, expected %f`, s, idx, got, expected)
+			}
+		}
+	}
+
+	for i, params := range l.Parameters() {
+		for idx := range params {
+			v := params[idx]
+
+			params[idx] = v + h
+			plus := loss()
+			params[idx] = v - h
+			minus := loss()
+			params[idx] = v
+
+			expected := (plus - minus) / (2 * h)
+			if got := expectedSteps[i][idx]; math.Abs(expected-got) > 1e-5 {
+				t.Errorf(`step %d for parameter %d is %f, expected %f`, i, idx, got, expected)
+			}
+		}
+	}
+
+	// Stepping through the sequence has to end up in the same state
+	err = l.SetState(state)
+	if err != nil {
+		t.Fatal(`can't set state:`, err)
+	}
+
+	for s := 0; s < steps; s++ {
+		_, err = l.Forward(inputs.RawRowView(s), false, nil)
+		if err != nil {
+			t.Fatal(`forward pass failed:`, err)
+		}
+	}
+
+	if !floats.EqualApprox(final, l.State(), 1e-12) {
+		t.Errorf(`final state is %v after single steps, expected %v`, l.State(), final)
+	}
+}
+
+func TestRecurrentGradients(t *testing.T) {
+	rng := testRand()
+
+	for _, kind := range []string{"rnn", "lstm", "gru"} {
+		for _, bias := range []bool{false, true} {
+			l := newRecurrent(t, kind, 3, RecurrentConf{Units: 4, Bias: bias}, rng)
+
+			inputs := mat.NewDense(5, 3, flatten(randomInputs(5, 3, rng)))
+			checkSequenceGradients(t, l, inputs, rng)
+
+			randomState(t, l, rng)
+			checkLayerGradients(t, l, randomInputs(3, 3, rng), rng)
+		}
+	}
+
+	l := newRecurrent(t, "rnn", 2, RecurrentConf{Units: 3, Activation: activation.Sigmoid{}, Bias: true}, rng)
+	checkSequenceGradients(t, l, mat.NewDense(4, 2, flatten(randomInputs(4, 2, rng))), rng)
+}
+
+func TestNewRecurrentInvalid(t *testing.T) {
+	rng := testRand()
+
+	for _, tc := range []struct {
+		name   string
+		inputs int
+		conf   RecurrentConf
+	}{
+		{"no units", 2, RecurrentConf{}},
+		{"no inputs", 0, RecurrentConf{Units: 2}},
+		{"trainable activation", 2, RecurrentConf{Units: 2, Activation: activation.PReLU{}}},
+	} {
+		_, err := NewRNN(tc.inputs, tc.conf, rng)
+		if err == nil {
+			t.Errorf(`%s: expected an error for RNN`, tc.name)
+		}
+
+		_, err = NewLSTM(tc.inputs, tc.conf, rng)
+		if err == nil {
+			t.Errorf(`%s: expected an error for LSTM`, tc.name)
+		}
+
+		_, err = NewGRU(tc.inputs, tc.conf, rng)
+		if err == nil {
+			t.Errorf(`%s: expected an error for GRU`, tc.name)
+		}
+	}
+
+	_, err := NewLSTM(2, RecurrentConf{Units: 2, Activation: activation.Tanh{}}, rng)
+	if err == nil {
+		t.Error(`expected an error for an LSTM with an activation`)
+	}
+}
+
+func TestRecurrentInitialization(t *testing.T) {
+	l, err := NewLSTM(3, RecurrentConf{Units: 4, Initializer: initializer.Constant{Value: 0.5}, Bias: true}, testRand())
+	if err != nil {
+		t.Fatal(`can't create layer:`, err)
+	}
+
+	for _, w := range l.weights.RawMatrix().Data {
+		if w != 0.5 {
+			t.Fatal(`input weights weren't initialized by the configured initializer`)
+		}
+	}
+
+	// Each gate gets its own orthogonal block
+	for gate := 0; gate < 4; gate++ {
+		block := l.recurrentWeights.Slice(gate*4, (gate+1)*4, 0, 4)
+
+		var product mat.Dense
+		product.Mul(block, block.T())
+
+		if !mat.EqualApprox(&product, eye(4), 1e-12) {
+			t.Errorf(`recurrent weights of gate %d aren't orthogonal`, gate)
+		}
+	}
+
+	for idx, b := range l.bias {
+		expected := float64(0)
+		if idx >= 4 && idx < 8 {
+			expected = 1
+		}
+
+		if b != expected {
+			t.Errorf(`bias %d is %f, expected %f`, idx, b, expected)
+		}
+	}
+}
+
+// eye returns the n x n identity matrix.
+func eye(n int) *mat.Dense {
+	res := mat.NewDense(n, n, nil)
+	for i := 0; i < n; i++ {
+		res.Set(i, i, 1)
+	}
+	return res
+}
+
+func TestRecurrentState(t *testing.T) {
+	rng := testRand()
+	l := newRecurrent(t, "lstm", 2, RecurrentConf{Units: 3, Bias: true}, rng)
+
+	if state := l.State(); len(state) != 6 || floats.Norm(state, 2) != 0 {
+		t.Fatal(`unexpected initial state:`, state)
+	}
+
+	inputs := randomInputs(4, 2, rng)
+
+	var outputs [][]float64
+	for _, x := range inputs {
+		output, err := l.Forward(x, false, nil)
+		if err != nil {
+			t.Fatal(`forward pass failed:`, err)
+		}
+
+		outputs = append(outputs, append([]float64{}, output...))
+	}
+
+	state := l.State()
+	if !floats.Equal(state[:3], outputs[3]) {
+		t.Errorf(`state %v doesn't start with the last outputs %v`, state, outputs[3])
+	}
+
+	// Predict and ForwardBatch don't change the state
+	err := l.Predict(make([]float64, 3), inputs[0])
+	if err != nil {
+		t.Fatal(`can't predict:`, err)
+	}
+
+	_, err = l.ForwardBatch(mat.NewDense(4, 2, flatten(inputs)), false, nil)
+	if err != nil {
+		t.Fatal(`batch forward pass failed:`, err)
+	}
+
+	if !floats.Equal(state, l.State()) {
+		t.Error(`state changed without a call of Forward`)
+	}
+
+	// Cloning copies the state without sharing it
+	clone := l.Clone().(Recurrent)
+	if !floats.Equal(state, clone.State()) {
+		t.Error(`clone has a different state`)
+	}
+
+	l.ResetState()
+	if floats.Norm(l.State(), 2) != 0 {
+		t.Error(`state wasn't reset:`, l.State())
+	}
+	if !floats.Equal(state, clone.State()) {
+		t.Error(`clone shares its state`)
+	}
+
+	// Starting over gives the same outputs again
+	sequence, err := l.ForwardSequence(mat.NewDense(4, 2, flatten(inputs)), false, nil)
+	if err != nil {
+		t.Fatal(`forward pass failed:`, err)
+	}
+
+	for idx, output := range outputs {
+		if !floats.EqualApprox(output, sequence.RawRowView(idx), 1e-12) {
+			t.Errorf(`step %d: expected outputs %v, got %v`, idx, output, sequence.RawRowView(idx))
+		}
+	}
+
+	err = l.SetState(make([]float64, 3))
+	if err == nil {
+		t.Error(`expected an error for a state of the wrong size`)
+	}
+}
+
+func TestRecurrentSnapshot(t *testing.T) {
+	for _, kind := range []string{"rnn", "lstm", "gru"} {
+		conf := RecurrentConf{Units: 3, Bias: true}
+
+		l := newRecurrent(t, kind, 2, conf, rand.New(rand.NewSource(1)))
+
+		var buf bytes.Buffer
+
+		_, err := l.WriteTo(&buf)
+		if err != nil {
+			t.Fatal(`can't write layer:`, err)
+		}
+
+		restored := newRecurrent(t, kind, 2, conf, rand.New(rand.NewSource(2)))
+
+		_, err = restored.ReadFrom(&buf)
+		if err != nil {
+			t.Fatal(`can't restore layer:`, err)
+		}
+
+		clone := l.Clone()
+
+		for _, other := range []Layer{restored, clone} {
+			for idx, p := range l.Parameters() {
+				if !floats.Equal(p, other.Parameters()[idx]) {
+					t.Errorf(`%T: parameters %d differ`, other, idx)
+				}
+			}
+		}
+
+		clone.Parameters()[1][0]++
+		if l.Parameters()[1][0] == clone.Parameters()[1][0] {
+			t.Errorf(`%T: clone shares its weights`, l)
+		}
+
+		_, err = l.WriteTo(&buf)
+		if err != nil {
+			t.Fatal(`can't write layer:`, err)
+		}
+
+		conf.Units = 4
+
+		other := newRecurrent(t, kind, 2, conf, testRand())
+
+		_, err = other.ReadFrom(&buf)
+		if err == nil {
+			t.Errorf(`%T: expected an error when restoring a layer with a different number of units`, l)
+		}
+
+		conf.Units = 3
+		conf.Bias = false
+
+		unbiased := newRecurrent(t, kind, 2, conf, testRand())
+		before := unbiased.Parameters()
+
+		buf.Reset()
+
+		_, err = l.WriteTo(&buf)
+		if err != nil {
+			t.Fatal(`can't write layer:`, err)
+		}
+
+		_, err = unbiased.ReadFrom(&buf)
+		if !errors.Is(err, ErrArchitectureMismatch) {
+			t.Errorf(`%T: expected an architecture mismatch when restoring biases into an unbiased layer, got %v`, l, err)
+		}
+
+		for idx, p := range unbiased.Parameters() {
+			if !floats.Equal(p, before[idx]) {
+				t.Errorf(`%T: parameters %d of the unbiased layer were modified`, l, idx)
+			}
+		}
+
+		// Unbiased snapshots still restore into unbiased layers
+		buf.Reset()
+
+		_, err = unbiased.WriteTo(&buf)
+		if err != nil {
+			t.Fatal(`can't write layer:`, err)
+		}
+
+		_, err = newRecurrent(t, kind, 2, conf, testRand()).ReadFrom(&buf)
+		if err != nil {
+			t.Errorf(`%T: can't restore unbiased layer: %v`, l, err)
+		}
+	}
+}
+
+func TestNetworkTrainSequenceGradient(t *testing.T) {
+	rng := testRand()
+
+	inputs := randomInputs(6, 2, rng)
+	targets := randomInputs(6, 2, rng)
+	targets[1], targets[4] = nil, nil
+
+	for _, kind := range []string{"rnn", "lstm", "gru"} {
+		net, err := NewFromLayers([]Layer{
+			newRecurrent(t, kind, 2, RecurrentConf{Units: 3, Bias: true}, rng),
+			newDense(t, 3, LayerConf{Inputs: 2, Activation: activation.Tanh{}, Bias: true}, rng),
+		}, WithSeed(1))
+		if err != nil {
+			t.Fatal(`can't create network:`, err)
+		}
+
+		// meanLoss returns the mean loss of the current parameters over the steps with targets
+		meanLoss := func() float64 {
+			net.ResetState()
+
+			outputs, err := net.ForwardSequence(inputs)
+			if err != nil {
+				t.Fatal(`forward pass failed:`, err)
+			}
+
+			res := float64(0)
+			for idx, target := range targets {
+				if target != nil {
+					res += loss.MSE{}.Value(outputs[idx], target)
+				}
+			}
+			return res / 4
+		}
+
+		const h = 1e-6
+
+		params := net.Parameters()
+
+		var gradient [][]float64
+		for _, p := range params {
+			grad := make([]float64, len(p))
+			for idx := range p {
+				v := p[idx]
+
+				p[idx] = v + h
+				must(t, net.SetParameters(params))
+				plus := meanLoss()
+
+				p[idx] = v - h
+				must(t, net.SetParameters(params))
+				minus := meanLoss()
+
+				p[idx] = v
+				grad[idx] = (plus - minus) / (2 * h)
+			}
+			gradient = append(gradient, grad)
+		}
+		must(t, net.SetParameters(params))
+
+		expectedLoss := meanLoss()
+
+		net.ResetState()
+
+		value, err := net.TrainSequence(inputs, targets, loss.MSE{}, 1, 0)
+		if err != nil {
+			t.Fatal(`training failed:`, err)
+		}
+
+		if math.Abs(value-expectedLoss) > 1e-12 {
+			t.Errorf(`%s: loss is %f, expected %f`, kind, value, expectedLoss)
+		}
+
+		// With plain SGD and a learning rate of 1, the update is the negative gradient
+		for idx, p := range net.Parameters() {
+			floats.Sub(p, params[idx])
+
+			if !floats.EqualApprox(p, floats.ScaleTo(make([]float64, len(p)), -1, gradient[idx]), 1e-6) {
+				t.Errorf(`%s: update of layer %d doesn't follow the gradient of the mean loss`, kind, idx)
+			}
+		}
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNetworkTrainSequenceTruncated(t *testing.T) {
+	rng := testRand()
+
+	inputs := randomInputs(5, 2, rng)
+	targets := make([][]float64, 5)
+	targets[0] = []float64{0.5, -0.5}
+
+	net1, err := NewFromLayers([]Layer{
+		newRecurrent(t, "lstm", 2, RecurrentConf{Units: 3, Bias: true}, rng),
+		newDense(t, 3, LayerConf{Inputs: 2, Activation: activation.Tanh{}, Bias: true}, rng),
+	}, WithSeed(1))
+	if err != nil {
+		t.Fatal(`can't create network:`, err)
+	}
+
+	net2 := net1.Clone()
+	net3 := net1.Clone()
+
+	// Truncating after every step only leaves the error of the first step for the first step, which is what Train
+	// does
+	_, err = net1.TrainSequence(inputs, targets, loss.MSE{}, 0.1, 1)
+	if err != nil {
+		t.Fatal(`training failed:`, err)
+	}
+
+	_, err = net2.Train(inputs[0], targets[0], loss.MSE{}, 0.1)
+	if err != nil {
+		t.Fatal(`training failed:`, err)
+	}
+
+	for idx, p := range net1.Parameters() {
+		if !floats.EqualApprox(p, net2.Parameters()[idx], 1e-12) {
+			t.Errorf(`parameters of layer %d differ`, idx)
+		}
+	}
+
+	// The state still covers the whole sequence, with the rest of it processed after the update
+	_, err = net2.ForwardSequence(inputs[1:])
+	if err != nil {
+		t.Fatal(`forward pass failed:`, err)
+	}
+
+	if !floats.EqualApprox(net1.State()[0], net2.State()[0], 1e-12) {
+		t.Errorf(`final state is %v, expected %v`, net1.State()[0], net2.State()[0])
+	}
+
+	// Chunks that are longer than the sequence are the same as no truncation
+	targets[3] = []float64{-0.5, 0.5}
+
+	net4 := net3.Clone()
+
+	_, err = net3.TrainSequence(inputs, targets, loss.MSE{}, 0.1, 0)
+	if err != nil {
+		t.Fatal(`training failed:`, err)
+	}
+
+	_, err = net4.TrainSequence(inputs, targets, loss.MSE{}, 0.1, 10)
+	if err != nil {
+		t.Fatal(`training failed:`, err)
+	}
+
+	for idx, p := range net3.Parameters() {
+		if !floats.Equal(p, net4.Parameters()[idx]) {
+			t.Errorf(`parameters of layer %d differ`, idx)
+		}
+	}
+}
+
+func TestNetworkTrainSequenceErrors(t *testing.T) {
+	rng := testRand()
+	net, err := NewFromLayers([]Layer{
+		newRecurrent(t, "gru", 2, RecurrentConf{Units: 3, Bias: true}, rng),
+		newDense(t, 3, LayerConf{Inputs: 1, Activation: activation.Sigmoid{}, Bias: true}, rng),
+	}, WithSeed(1))
+	if err != nil {
+		t.Fatal(`can't create network:`, err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		inputs  [][]float64
+		targets [][]float64
+	}{
+		{"empty", nil, nil},
+		{"mismatched lengths", [][]float64{{0, 1}, {1, 0}}, [][]float64{{1}}},
+		{"no targets", [][]float64{{0, 1}}, [][]float64{nil}},
+		{"wrong input size", [][]float64{{0, 1, 2}}, [][]float64{{1}}},
+		{"wrong target size", [][]float64{{0, 1}}, [][]float64{{1, 0}}},
+		{"non-finite input", [][]float64{{0, math.NaN()}}, [][]float64{{1}}},
+	} {
+		_, err := net.TrainSequence(tc.inputs, tc.targets, loss.BinaryCrossEntropy{}, 0.1, 0)
+		if err == nil {
+			t.Errorf(`%s: expected an error`, tc.name)
+		}
+	}
+}
+
+func TestNetworkBackpropSequenceErrors(t *testing.T) {
+	rng := testRand()
+	net, err := NewFromLayers([]Layer{
+		newRecurrent(t, "gru", 2, RecurrentConf{Units: 3, Bias: true}, rng),
+		newDense(t, 3, LayerConf{Inputs: 1, Activation: activation.Sigmoid{}, Bias: true}, rng),
+	}, WithSeed(1))
+	if err != nil {
+		t.Fatal(`can't create network:`, err)
+	}
+
+	err = net.BackpropSequence([][]float64{{1}}, 0.1)
+	if err == nil {
+		t.Error(`expected an error without a previous forward pass`)
+	}
+
+	_, err = net.ForwardSequence([][]float64{{0, 1}, {1, 0}, {1, 1}})
+	if err != nil {
+		t.Fatal(`forward pass failed:`, err)
+	}
+
+	before := net.Parameters()
+
+	for _, tc := range []struct {
+		name string
+		errs [][]float64
+	}{
+		{"too few steps", [][]float64{{1}, {1}}},
+		{"too many steps", [][]float64{{1}, {1}, {1}, {1}}},
+		{"wrong error size", [][]float64{{1}, nil, {1, 0}}},
+	} {
+		err := net.BackpropSequence(tc.errs, 0.1)
+		if err == nil {
+			t.Errorf(`%s: expected an error`, tc.name)
+		}
+	}
+
+	for idx, p := range net.Parameters() {
+		if !floats.Equal(p, before[idx]) {
+			t.Errorf(`layer %d: parameters changed`, idx)
+		}
+	}
+
+	err = net.BackpropSequence([][]float64{nil, nil, {1}}, 0.1)
+	if err != nil {
+		t.Error(`backpropagation failed:`, err)
+	}
+}
+
+func TestNetworkCloneAfterForwardSequence(t *testing.T) {
+	rng := testRand()
+	net1, err := NewFromLayers([]Layer{
+		newRecurrent(t, "lstm", 2, RecurrentConf{Units: 3, Bias: true}, rng),
+		newDense(t, 3, LayerConf{Inputs: 2, Activation: activation.Tanh{}, Bias: true}, rng),
+	}, WithSeed(1))
+	if err != nil {
+		t.Fatal(`can't create network:`, err)
+	}
+
+	_, err = net1.ForwardSequence([][]float64{{0, 1}, {1, 0}, {1, 1}})
+	if err != nil {
+		t.Fatal(`forward pass failed:`, err)
+	}
+
+	net2 := net1.Clone()
+
+	errs := [][]float64{{0.5, -0.5}, nil, {-1, 1}}
+	must(t, net1.BackpropSequence(errs, 0.1))
+	must(t, net2.BackpropSequence(errs, 0.1))
+
+	for idx, p := range net1.Parameters() {
+		if !floats.Equal(p, net2.Parameters()[idx]) {
+			t.Errorf(`parameters of layer %d differ`, idx)
+		}
+	}
+}
+
+func TestNetworkState(t *testing.T) {
+	rng := testRand()
+	net, err := NewFromLayers([]Layer{
+		newRecurrent(t, "lstm", 2, RecurrentConf{Units: 3, Bias: true}, rng),
+		newDense(t, 3, LayerConf{Inputs: 2, Activation: activation.Tanh{}, Bias: true}, rng),
+	}, WithSeed(1))
+	if err != nil {
+		t.Fatal(`can't create network:`, err)
+	}
+
+	first, second := randomInputs(4, 2, rng), randomInputs(3, 2, rng)
+
+	_, err = net.ForwardSequence(first)
+	if err != nil {
+		t.Fatal(`forward pass failed:`, err)
+	}
+
+	state := net.State()
+	if len(state) != 2 || len(state[0]) != 6 || state[1] != nil {
+		t.Fatal(`unexpected state:`, state)
+	}
+
+	expected, err := net.ForwardSequence(second)
+	if err != nil {
+		t.Fatal(`forward pass failed:`, err)
+	}
+
+	// Going back to the saved state gives the same outputs again, also when stepping through the sequence
+	must(t, net.SetState(state))
+
+	for idx, x := range second {
+		output, err := net.ForwardE(x)
+		if err != nil {
+			t.Fatal(`forward pass failed:`, err)
+		}
+
+		if !floats.EqualApprox(output, expected[idx], 1e-12) {
+			t.Errorf(`step %d: expected outputs %v, got %v`, idx, expected[idx], output)
+		}
+	}
+
+	net.ResetState()
+	if floats.Norm(net.State()[0], 2) != 0 {
+		t.Error(`state wasn't reset`)
+	}
+
+	for _, invalid := range [][][]float64{
+		{state[0]},
+		{state[0][:3], nil},
+		{state[0], {1}},
+	} {
+		err = net.SetState(invalid)
+		if err == nil {
+			t.Errorf(`%v: expected an error`, invalid)
+		}
+	}
+
+	if floats.Norm(net.State()[0], 2) != 0 {
+		t.Error(`state was changed by an invalid call of SetState`)
+	}
+}
+
+// delayedSequence returns a sequence of random bits where the target of each step is the input of the step delay
+// steps before it. The first steps have no targets.
+func delayedSequence(length, delay int, rng *rand.Rand) ([][]float64, [][]float64) {
+	inputs := make([][]float64, length)
+	targets := make([][]float64, length)
+
+	for idx := range inputs {
+		inputs[idx] = []float64{float64(rng.Intn(2))}
+
+		if idx >= delay {
+			targets[idx] = inputs[idx-delay]
+		}
+	}
+
+	return inputs, targets
+}
+
+func TestNetworkLearnSequence(t *testing.T) {
+	for _, kind := range []string{"rnn", "lstm", "gru"} {
+		rng := testRand()
+
+		net, err := NewFromLayers([]Layer{
+			newRecurrent(t, kind, 1, RecurrentConf{Units: 8, Bias: true}, rng),
+			newDense(t, 8, LayerConf{Inputs: 1, Activation: activation.Sigmoid{}, Bias: true}, rng),
+		}, WithSeed(1))
+		if err != nil {
+			t.Fatal(`can't create network:`, err)
+		}
+		net.SetOptimizer(optimizer.Adam{})
+
+		for epoch := 0; epoch < 300; epoch++ {
+			inputs, targets := delayedSequence(20, 2, rng)
+
+			net.ResetState()
+
+			_, err := net.TrainSequence(inputs, targets, loss.BinaryCrossEntropy{}, 0.01, 5)
+			if err != nil {
+				t.Fatal(`training failed:`, err)
+			}
+		}
+
+		inputs, targets := delayedSequence(50, 2, rng)
+
+		net.ResetState()
+
+		outputs, err := net.ForwardSequence(inputs)
+		if err != nil {
+			t.Fatal(`forward pass failed:`, err)
+		}
+
+		for idx, output := range outputs {
+			if targets[idx] != nil && math.Abs(output[0]-targets[idx][0]) > 0.5 {
+				t.Errorf(`%s: step %d: expected %v, got %v`, kind, idx, targets[idx], output)
+			}
+		}
+	}
+}
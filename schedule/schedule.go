@@ -0,0 +1,186 @@
+// Package schedule contains learning rate schedules that adjust the learning rate over the course of training.
+package schedule
+
+import "math"
+
+// Schedule computes the learning rate at a point of training. The point is measured in epochs, fractions of an
+// epoch denote progress within it. A schedule that is used by a trainer is asked for the learning rate before
+// every batch.
+type Schedule interface {
+	LearningRate(epoch float64) float64
+}
+
+// Observer is implemented by schedules that adapt to the progress of training, like ReduceOnPlateau. Observe
+// is called once after every epoch with a measure of the quality of the network, smaller values are better.
+type Observer interface {
+	Observe(value float64)
+}
+
+func withDefault(value, def float64) float64 {
+	if value == 0 {
+		return def
+	}
+	return value
+}
+
+// Constant always returns the same learning rate.
+type Constant float64
+
+func (c Constant) LearningRate(epoch float64) float64 {
+	return float64(c)
+}
+
+// Step multiplies the learning rate by Factor every Every epochs, starting from Initial. The learning rate
+// doesn't drop below Min.
+type Step struct {
+	Initial float64
+	Factor  float64
+	Every   int // 1 if zero
+	Min     float64
+}
+
+func (s Step) LearningRate(epoch float64) float64 {
+	every := s.Every
+	if every <= 0 {
+		every = 1
+	}
+
+	steps := math.Floor(epoch / float64(every))
+
+	return math.Max(s.Min, s.Initial*math.Pow(s.Factor, steps))
+}
+
+// Exponential decays the learning rate continuously, so that it is multiplied by Decay with every epoch,
+// starting from Initial. The learning rate doesn't drop below Min.
+type Exponential struct {
+	Initial float64
+	Decay   float64
+	Min     float64
+}
+
+func (e Exponential) LearningRate(epoch float64) float64 {
+	return math.Max(e.Min, e.Initial*math.Pow(e.Decay, epoch))
+}
+
+// CosineRestarts is cosine annealing with warm restarts (SGDR). Within each cycle, the learning rate follows
+// half a cosine from Max down to Min. The first cycle lasts Period epochs, and each following cycle is Mult
+// times as long as the previous one.
+type CosineRestarts struct {
+	Max    float64
+	Min    float64
+	Period float64
+	Mult   float64 // 1 if zero
+}
+
+func (c CosineRestarts) LearningRate(epoch float64) float64 {
+	mult := withDefault(c.Mult, 1)
+
+	// Find the cycle that contains epoch, its length and the position within it.
+	pos := epoch
+	length := c.Period
+	if mult == 1 {
+		pos = math.Mod(epoch, c.Period)
+	} else {
+		cycle := math.Floor(math.Log(1+epoch/c.Period*(mult-1)) / math.Log(mult))
+		start := c.Period * (math.Pow(mult, cycle) - 1) / (mult - 1)
+		length = c.Period * math.Pow(mult, cycle)
+		pos = epoch - start
+	}
+
+	return c.Min + (c.Max-c.Min)*(1+math.Cos(math.Pi*pos/length))/2
+}
+
+// Warmup increases the learning rate linearly from 0 to the initial learning rate of After during the first
+// Epochs epochs. Afterwards, it follows After, shifted so that After starts once the warmup is over.
+type Warmup struct {
+	Epochs float64
+	After  Schedule
+}
+
+func (w Warmup) LearningRate(epoch float64) float64 {
+	if epoch < w.Epochs {
+		return w.After.LearningRate(0) * epoch / w.Epochs
+	}
+
+	return w.After.LearningRate(epoch - w.Epochs)
+}
+
+// OneCycle is the one-cycle policy. The learning rate rises from Max/DivFactor to Max during the first
+// PctStart of Epochs, and then falls to Max/(DivFactor*FinalDivFactor) at the end of Epochs. Both phases follow
+// half a cosine. After Epochs, the learning rate stays at its final value.
+type OneCycle struct {
+	Max            float64
+	Epochs         float64
+	PctStart       float64 // 0.3 if zero
+	DivFactor      float64 // 25 if zero
+	FinalDivFactor float64 // 1e4 if zero
+}
+
+func (o OneCycle) LearningRate(epoch float64) float64 {
+	initial := o.Max / withDefault(o.DivFactor, 25)
+	final := initial / withDefault(o.FinalDivFactor, 1e4)
+	rise := o.Epochs * withDefault(o.PctStart, 0.3)
+
+	if epoch < rise {
+		return anneal(initial, o.Max, epoch/rise)
+	}
+
+	if epoch >= o.Epochs {
+		return final
+	}
+
+	return anneal(o.Max, final, (epoch-rise)/(o.Epochs-rise))
+}
+
+// anneal moves from start to end along half a cosine as pos goes from 0 to 1.
+func anneal(start, end, pos float64) float64 {
+	return end + (start-end)*(1+math.Cos(math.Pi*pos))/2
+}
+
+// ReduceOnPlateau multiplies the learning rate by Factor once the observed value hasn't improved for Patience
+// epochs, starting from Initial. A value counts as an improvement if it is smaller than the best value so far
+// by more than MinDelta. After a reduction, the schedule waits another Patience epochs before reducing again.
+// The learning rate doesn't drop below Min.
+//
+// ReduceOnPlateau has to be used as a pointer, so that it can keep track of observed values.
+type ReduceOnPlateau struct {
+	Initial  float64
+	Factor   float64 // 0.1 if zero
+	Patience int
+	MinDelta float64
+	Min      float64
+
+	started bool
+	rate    float64
+	best    float64
+	wait    int
+}
+
+func (r *ReduceOnPlateau) init() {
+	if !r.started {
+		r.started = true
+		r.rate = r.Initial
+		r.best = math.Inf(1)
+	}
+}
+
+func (r *ReduceOnPlateau) LearningRate(epoch float64) float64 {
+	r.init()
+	return r.rate
+}
+
+func (r *ReduceOnPlateau) Observe(value float64) {
+	r.init()
+
+	if value < r.best-r.MinDelta {
+		r.best = value
+		r.wait = 0
+		return
+	}
+
+	r.wait++
+	if r.wait >= r.Patience {
+		r.rate = math.Max(r.Min, r.rate*withDefault(r.Factor, 0.1))
+		r.wait = 0
+	}
+}
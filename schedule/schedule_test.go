@@ -0,0 +1,128 @@
+package schedule
+
+import (
+	"math"
+	"testing"
+)
+
+func checkCurve(t *testing.T, s Schedule, expected map[float64]float64) {
+	t.Helper()
+
+	for epoch, rate := range expected {
+		actual := s.LearningRate(epoch)
+		if math.Abs(actual-rate) > 1e-12 {
+			t.Errorf(`epoch %v: expected learning rate %v, got %v`, epoch, rate, actual)
+		}
+	}
+}
+
+func TestConstant(t *testing.T) {
+	checkCurve(t, Constant(0.1), map[float64]float64{
+		0:    0.1,
+		17.5: 0.1,
+	})
+}
+
+func TestStep(t *testing.T) {
+	checkCurve(t, Step{Initial: 0.1, Factor: 0.9, Every: 10, Min: 0.05}, map[float64]float64{
+		0:    0.1,
+		9.99: 0.1,
+		10:   0.09,
+		25:   0.081,
+		30:   0.0729,
+		100:  0.05,
+	})
+
+	checkCurve(t, Step{Initial: 1, Factor: 0.5}, map[float64]float64{
+		0:   1,
+		1:   0.5,
+		2.5: 0.25,
+	})
+}
+
+func TestExponential(t *testing.T) {
+	checkCurve(t, Exponential{Initial: 0.1, Decay: 0.5, Min: 0.01}, map[float64]float64{
+		0:   0.1,
+		0.5: 0.1 / math.Sqrt2,
+		1:   0.05,
+		3:   0.0125,
+		4:   0.01,
+	})
+}
+
+func TestCosineRestarts(t *testing.T) {
+	checkCurve(t, CosineRestarts{Max: 1, Min: 0.1, Period: 10}, map[float64]float64{
+		0:  1,
+		5:  0.55,
+		10: 1,
+		15: 0.55,
+		25: 0.55,
+	})
+
+	// Cycles of 10, 20 and 40 epochs start at 0, 10 and 30.
+	checkCurve(t, CosineRestarts{Max: 1, Period: 10, Mult: 2}, map[float64]float64{
+		0:  1,
+		5:  0.5,
+		10: 1,
+		20: 0.5,
+		25: (1 + math.Cos(math.Pi*15/20)) / 2,
+		30: 1,
+		50: 0.5,
+	})
+}
+
+func TestWarmup(t *testing.T) {
+	checkCurve(t, Warmup{Epochs: 2, After: Step{Initial: 0.1, Factor: 0.5, Every: 1}}, map[float64]float64{
+		0:   0,
+		0.5: 0.025,
+		1:   0.05,
+		2:   0.1,
+		3:   0.05,
+		4.5: 0.025,
+	})
+}
+
+func TestOneCycle(t *testing.T) {
+	o := OneCycle{Max: 1, Epochs: 10, PctStart: 0.2, DivFactor: 10, FinalDivFactor: 100}
+
+	checkCurve(t, o, map[float64]float64{
+		0:  0.1,
+		1:  0.55,
+		2:  1,
+		6:  (1 + 0.001) / 2,
+		10: 0.001,
+		20: 0.001,
+	})
+
+	checkCurve(t, OneCycle{Max: 25, Epochs: 10}, map[float64]float64{
+		0:  1,
+		3:  25,
+		10: 1e-4,
+	})
+}
+
+func TestReduceOnPlateau(t *testing.T) {
+	r := &ReduceOnPlateau{Initial: 1, Factor: 0.5, Patience: 2, MinDelta: 0.01, Min: 0.2}
+
+	for idx, tc := range []struct {
+		value float64
+		rate  float64
+	}{
+		{value: 1, rate: 1},
+		{value: 0.5, rate: 1},
+		{value: 0.495, rate: 1}, // Improvement smaller than MinDelta
+		{value: 0.6, rate: 0.5},
+		{value: 0.4, rate: 0.5},
+		{value: 0.4, rate: 0.5},
+		{value: 0.4, rate: 0.25},
+		{value: 0.4, rate: 0.25},
+		{value: 0.4, rate: 0.2},
+	} {
+		r.Observe(tc.value)
+
+		rate := r.LearningRate(float64(idx + 1))
+		if rate != tc.rate {
+			t.Errorf(`observation %d: expected learning rate %v, got %v`, idx, tc.rate, rate)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+
+	"gonum.org/v1/gonum/mat"
+
+	"github.com/farhaven/nn-go/loss"
+)
+
+// ForwardSequence performs a forward pass through n for a sequence of inputs and returns the outputs for each step.
+// Recurrent layers, see Recurrent, carry their state from one step to the next, starting from their current state
+// and ending in the state after the last step. All other layers process each step on its own. Like ForwardE,
+// ForwardSequence never applies dropout.
+//
+// ForwardSequence keeps the activations of all layers for BackpropSequence, so it must not be called concurrently.
+func (n *Network) ForwardSequence(inputs [][]float64) ([][]float64, error) {
+	output, err := n.forwardSequence(inputs, false)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([][]float64, len(inputs))
+	for idx := range res {
+		res[idx] = append([]float64{}, output.RawRowView(idx)...)
+	}
+
+	return res, nil
+}
+
+// forwardSequence implements ForwardSequence and returns the outputs with one step per row. Dropout is only applied
+// if training is set.
+func (n *Network) forwardSequence(inputs [][]float64, training bool) (*mat.Dense, error) {
+	if len(inputs) == 0 {
+		return nil, errors.New("empty sequence")
+	}
+
+	numInputs, _ := n.layers[0].Dims()
+
+	input := mat.NewDense(len(inputs), numInputs, nil)
+	for idx := range inputs {
+		if len(inputs[idx]) != numInputs {
+			return nil, fmt.Errorf("input %d has length %d, expected %d", idx, len(inputs[idx]), numInputs)
+		}
+
+		err := checkInputs(inputs[idx], idx)
+		if err != nil {
+			return nil, err
+		}
+
+		input.SetRow(idx, inputs[idx])
+	}
+
+	// Don't let BackpropSequence use the activations of a previous sequence if this one fails
+	n.sequenceLength = 0
+
+	output := input
+	for layerIdx, layer := range n.layers {
+		var err error
+
+		if r, ok := layer.(Recurrent); ok {
+			output, err = r.ForwardSequence(output, training, n.rng)
+		} else {
+			output, err = layer.ForwardBatch(output, training, n.rng)
+		}
+		if err != nil {
+			return nil, withLayer(err, layerIdx)
+		}
+	}
+
+	n.sequenceLength = len(inputs)
+
+	return output, nil
+}
+
+// BackpropSequence propagates the errors at the outputs of each step of the last call of ForwardSequence backwards
+// through n and through time, and updates the parameters of n with the given learning rate. A nil error means that
+// there is no error for that step. The gradients of all steps are summed up.
+//
+// The errors don't flow into the state the sequence started from. Splitting a long sequence into chunks that are
+// processed one after the other results in truncated backpropagation through time, see TrainSequence.
+//
+// BackpropSequence returns an error and leaves the parameters of n untouched if there isn't an error for every
+// step of the last sequence, or if any of the errors doesn't match the outputs of n.
+func (n *Network) BackpropSequence(errs [][]float64, learningRate float64) error {
+	if n.sequenceLength == 0 {
+		return errors.New("no sequence to propagate errors through, ForwardSequence has to be called first")
+	}
+	if len(errs) != n.sequenceLength {
+		return fmt.Errorf("got errors for %d steps, but the last sequence had %d steps", len(errs), n.sequenceLength)
+	}
+
+	_, numOutputs := n.layers[len(n.layers)-1].Dims()
+
+	m := mat.NewDense(len(errs), numOutputs, nil)
+	for idx, e := range errs {
+		if e == nil {
+			continue
+		}
+		if len(e) != numOutputs {
+			return fmt.Errorf("error %d has length %d, expected %d", idx, len(e), numOutputs)
+		}
+		m.SetRow(idx, e)
+	}
+
+	n.backpropSequence(m, false, learningRate)
+
+	return nil
+}
+
+// backpropSequence implements BackpropSequence. If fused is set, errs holds the deltas of the output layer
+// instead of the errors at its outputs, see outputDeltas.
+func (n *Network) backpropSequence(errs *mat.Dense, fused bool, learningRate float64) {
+	steps, _ := errs.Dims()
+
+	for idx := len(n.layers) - 1; idx >= 0; idx-- {
+		layer := n.layers[idx]
+
+		if r, ok := layer.(Recurrent); ok {
+			errs = r.BackwardSequence(errs)
+			continue
+		}
+
+		if fused && idx == len(n.layers)-1 {
+			errs = layer.(*Dense).backwardBatchDeltas(errs)
+		} else {
+			errs = layer.BackwardBatch(errs)
+		}
+
+		// The steps are averaged over the batch, but the steps of a sequence add up
+		scaleSteps(layer.Steps(), float64(steps))
+	}
+
+	n.applyUpdates(learningRate)
+}
+
+// TrainSequence performs training on a single sequence of inputs. The error computed by l for each step is
+// propagated backwards through time, see BackpropSequence. The targets for some steps may be nil, for example
+// if only the output of the last step matters.
+//
+// If truncate is positive, the sequence is processed in chunks of at most truncate steps, with an update after
+// each chunk. The state of recurrent layers is carried over from one chunk to the next, but the errors are only
+// propagated back within each chunk. This is known as truncated backpropagation through time, and limits the
+// time and memory needed for long sequences. Otherwise, the whole sequence is processed at once.
+//
+// The gradients are divided by the number of steps that have targets, so that without truncation the update
+// follows the gradient of the mean loss over those steps. TrainSequence returns that mean loss plus the L1 and L2
+// penalties of the weights, computed before the first update.
+//
+// Training starts from the current state of the recurrent layers and leaves them in the state after the last
+// step. Use ResetState first if the sequence is unrelated to the previous one.
+//
+// Like Train, TrainSequence fuses the gradients of a Softmax output layer and categorical cross-entropy, and
+// returns a *NonFiniteError if a NaN or infinite value shows up during a forward pass. Chunks that were processed
+// before the error stay applied.
+func (n *Network) TrainSequence(inputs, targets [][]float64, l loss.Loss, learningRate float64, truncate int) (float64, error) {
+	if len(inputs) == 0 {
+		return 0, errors.New("empty sequence")
+	}
+	if len(inputs) != len(targets) {
+		return 0, fmt.Errorf("got %d inputs, but %d targets", len(inputs), len(targets))
+	}
+
+	_, numOutputs := n.layers[len(n.layers)-1].Dims()
+
+	numTargets := 0
+	for idx, t := range targets {
+		if t == nil {
+			continue
+		}
+		if len(t) != numOutputs {
+			return 0, fmt.Errorf("target %d has length %d, expected %d", idx, len(t), numOutputs)
+		}
+		numTargets++
+	}
+	if numTargets == 0 {
+		return 0, errors.New("sequence has no targets")
+	}
+
+	if truncate <= 0 {
+		truncate = len(inputs)
+	}
+
+	penalty := n.penalty()
+	scale := 1 / float64(numTargets)
+
+	totalLoss := float64(0)
+	for start := 0; start < len(inputs); start += truncate {
+		end := start + truncate
+		if end > len(inputs) {
+			end = len(inputs)
+		}
+
+		output, err := n.forwardSequence(inputs[start:end], n.mode == Training)
+		if err != nil {
+			return 0, err
+		}
+
+		var fused, hasTargets bool
+
+		errs := mat.NewDense(end-start, numOutputs, nil)
+		for idx, t := range targets[start:end] {
+			if t == nil {
+				continue
+			}
+			hasTargets = true
+
+			o := output.RawRowView(idx)
+			totalLoss += l.Value(o, t)
+
+			var e []float64
+			e, fused = n.outputDeltas(l, o, t)
+			if !fused {
+				e = l.Error(o, t)
+			}
+			errs.SetRow(idx, e)
+		}
+
+		// Without any targets, the chunk only advances the state
+		if !hasTargets {
+			continue
+		}
+
+		errs.Scale(scale, errs)
+		n.backpropSequence(errs, fused, learningRate)
+	}
+
+	return totalLoss*scale + penalty, nil
+}
+
+// ResetState sets the state of all recurrent layers of n to zero, so that the next step or sequence is processed
+// as if it was the first one.
+func (n *Network) ResetState() {
+	for _, layer := range n.layers {
+		if r, ok := layer.(Recurrent); ok {
+			r.ResetState()
+		}
+	}
+}
+
+// State returns a copy of the state of each layer of n. The entries of layers that aren't recurrent are nil. The
+// state is not included in snapshots.
+func (n *Network) State() [][]float64 {
+	res := make([][]float64, len(n.layers))
+	for idx, layer := range n.layers {
+		if r, ok := layer.(Recurrent); ok {
+			res[idx] = r.State()
+		}
+	}
+	return res
+}
+
+// SetState replaces the state of each recurrent layer of n with the given one, which has to be in the layout
+// returned by State. This allows processing several independent sequences step by step with a single network.
+func (n *Network) SetState(state [][]float64) error {
+	if len(state) != len(n.layers) {
+		return fmt.Errorf("got state for %d layers, expected %d", len(state), len(n.layers))
+	}
+
+	// Check all states first, so that n is left untouched on errors
+	for idx, layer := range n.layers {
+		r, ok := layer.(Recurrent)
+		if !ok {
+			if state[idx] != nil {
+				return fmt.Errorf("layer %d: got state for a layer that isn't recurrent", idx)
+			}
+			continue
+		}
+
+		if size := len(r.State()); len(state[idx]) != size {
+			return fmt.Errorf("layer %d: got state of size %d, expected %d", idx, len(state[idx]), size)
+		}
+	}
+
+	for idx, layer := range n.layers {
+		if r, ok := layer.(Recurrent); ok {
+			err := r.SetState(state[idx])
+			if err != nil {
+				return fmt.Errorf("layer %d: %w", idx, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,455 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+
+	"github.com/farhaven/nn-go/loss"
+	"github.com/farhaven/nn-go/optimizer"
+	"github.com/farhaven/nn-go/schedule"
+)
+
+// ErrStopTraining can be returned by callbacks to end training early. Trainer.Train doesn't treat it as an
+// error.
+var ErrStopTraining = errors.New("stop training")
+
+// Sample is a single training sample.
+type Sample struct {
+	Input  []float64
+	Target []float64
+}
+
+// Split splits samples into a validation set made up of the first fraction of samples and a training set made
+// up of the rest.
+func Split(samples []Sample, fraction float64) (training, validation []Sample) {
+	valSize := int(float64(len(samples)) * fraction)
+	return samples[valSize:], samples[:valSize]
+}
+
+// Metric measures the quality of the output of a network for a single sample. Smaller values are better.
+type Metric func(outputs, targets []float64) float64
+
+// ClassificationError is a Metric for classifiers. It is 0 if the largest output and the largest target have
+// the same index and 1 otherwise, so its mean over a set of samples is the error rate.
+func ClassificationError(outputs, targets []float64) float64 {
+	if argmax(outputs) == argmax(targets) {
+		return 0
+	}
+	return 1
+}
+
+func argmax(values []float64) int {
+	maxSeen := math.Inf(-1)
+	maxIdx := 0
+
+	for idx, val := range values {
+		if val > maxSeen {
+			maxSeen = val
+			maxIdx = idx
+		}
+	}
+
+	return maxIdx
+}
+
+// BatchStats describes a training step on one batch.
+type BatchStats struct {
+	Epoch        int
+	Batch        int
+	LearningRate float64
+	Loss         float64 // Mean loss over the batch
+	GradientNorm float64 // Norm of the gradient before clipping, see Network.GradientNorm
+}
+
+// EpochStats describes one training epoch.
+type EpochStats struct {
+	Epoch        int
+	LearningRate float64
+	Loss         float64 // Mean loss over all training samples
+
+	// Mean loss and metric over the validation samples. Both are NaN if there are no validation samples, and
+	// ValidationMetric is also NaN if the trainer has no metric.
+	ValidationLoss   float64
+	ValidationMetric float64
+}
+
+// History holds the statistics of all epochs of a training run.
+type History []EpochStats
+
+// Callback is notified by a Trainer after each batch and each epoch. Callbacks may modify the trainer, for
+// example to adjust the learning rate. Callbacks are called in order. If a callback returns an error, the
+// remaining callbacks are skipped and training stops. Unless the error is ErrStopTraining, it is returned from
+// Trainer.Train.
+type Callback interface {
+	BatchEnd(t *Trainer, n *Network, stats BatchStats) error
+	EpochEnd(t *Trainer, n *Network, stats EpochStats) error
+}
+
+// TrainEnder can be implemented by callbacks that need to be notified once training ends, either because all
+// epochs have run or because a callback returned ErrStopTraining.
+type TrainEnder interface {
+	TrainEnd(t *Trainer, n *Network, history History) error
+}
+
+// CallbackFuncs implements Callback with plain functions. Functions that are nil are skipped.
+type CallbackFuncs struct {
+	OnBatchEnd func(t *Trainer, n *Network, stats BatchStats) error
+	OnEpochEnd func(t *Trainer, n *Network, stats EpochStats) error
+}
+
+func (c CallbackFuncs) BatchEnd(t *Trainer, n *Network, stats BatchStats) error {
+	if c.OnBatchEnd == nil {
+		return nil
+	}
+	return c.OnBatchEnd(t, n, stats)
+}
+
+func (c CallbackFuncs) EpochEnd(t *Trainer, n *Network, stats EpochStats) error {
+	if c.OnEpochEnd == nil {
+		return nil
+	}
+	return c.OnEpochEnd(t, n, stats)
+}
+
+// Checkpoint is a Callback that writes a snapshot of the network to Path after each epoch and once training
+// has ended. Place it after callbacks that modify the network at the end of training, like EarlyStopping.
+type Checkpoint struct {
+	Path string
+}
+
+func (c Checkpoint) BatchEnd(t *Trainer, n *Network, stats BatchStats) error {
+	return nil
+}
+
+func (c Checkpoint) EpochEnd(t *Trainer, n *Network, stats EpochStats) error {
+	return c.write(n)
+}
+
+func (c Checkpoint) TrainEnd(t *Trainer, n *Network, history History) error {
+	return c.write(n)
+}
+
+func (c Checkpoint) write(n *Network) error {
+	fh, err := os.Create(c.Path)
+	if err != nil {
+		return err
+	}
+
+	_, err = n.WriteTo(fh)
+	if err != nil {
+		fh.Close()
+		return fmt.Errorf("writing checkpoint: %w", err)
+	}
+
+	return fh.Close()
+}
+
+// StopAtLoss is a Callback that stops training once the mean training loss of an epoch drops to Target or
+// below.
+type StopAtLoss struct {
+	Target float64
+}
+
+func (s StopAtLoss) BatchEnd(t *Trainer, n *Network, stats BatchStats) error {
+	return nil
+}
+
+func (s StopAtLoss) EpochEnd(t *Trainer, n *Network, stats EpochStats) error {
+	if stats.Loss <= s.Target {
+		return ErrStopTraining
+	}
+	return nil
+}
+
+// Monitor selects the value of EpochStats that is watched by callbacks like EarlyStopping and by schedules that
+// implement schedule.Observer.
+type Monitor int
+
+const (
+	MonitorValidationLoss Monitor = iota
+	MonitorValidationMetric
+	MonitorLoss
+)
+
+func (m Monitor) value(stats EpochStats) (float64, error) {
+	var val float64
+
+	switch m {
+	case MonitorValidationLoss:
+		val = stats.ValidationLoss
+	case MonitorValidationMetric:
+		val = stats.ValidationMetric
+	case MonitorLoss:
+		val = stats.Loss
+	default:
+		return 0, fmt.Errorf("unknown monitor %d", m)
+	}
+
+	if math.IsNaN(val) {
+		return 0, fmt.Errorf("monitored value %s is NaN, missing validation samples or metric?", m)
+	}
+
+	return val, nil
+}
+
+func (m Monitor) String() string {
+	switch m {
+	case MonitorValidationLoss:
+		return "ValidationLoss"
+	case MonitorValidationMetric:
+		return "ValidationMetric"
+	case MonitorLoss:
+		return "Loss"
+	default:
+		return fmt.Sprintf("Monitor(%d)", int(m))
+	}
+}
+
+// EarlyStopping is a Callback that stops training once the monitored value hasn't improved for Patience epochs.
+// It keeps a clone of the best network seen so far and restores it into the trained network once training
+// ends, regardless of whether training was stopped early.
+//
+// An epoch counts as an improvement if the monitored value decreased by more than MinDelta compared to the
+// best epoch so far. The state of an EarlyStopping is reset at the start of each training run.
+type EarlyStopping struct {
+	Monitor  Monitor
+	Patience int
+	MinDelta float64
+
+	best      float64
+	bestEpoch int
+	bestNet   *Network
+}
+
+func (e *EarlyStopping) BatchEnd(t *Trainer, n *Network, stats BatchStats) error {
+	return nil
+}
+
+func (e *EarlyStopping) EpochEnd(t *Trainer, n *Network, stats EpochStats) error {
+	val, err := e.Monitor.value(stats)
+	if err != nil {
+		return fmt.Errorf("early stopping: %w", err)
+	}
+
+	if stats.Epoch == 0 || val < e.best-e.MinDelta {
+		e.best = val
+		e.bestEpoch = stats.Epoch
+		e.bestNet = n.Clone()
+		return nil
+	}
+
+	if stats.Epoch-e.bestEpoch >= e.Patience {
+		return ErrStopTraining
+	}
+
+	return nil
+}
+
+func (e *EarlyStopping) TrainEnd(t *Trainer, n *Network, history History) error {
+	if e.bestNet != nil {
+		n.restoreClone(e.bestNet)
+		e.bestNet = nil
+	}
+
+	return nil
+}
+
+// Best returns the index of the best epoch and its monitored value.
+func (e *EarlyStopping) Best() (epoch int, value float64) {
+	return e.bestEpoch, e.best
+}
+
+// Trainer trains networks with shuffled mini-batches over a number of epochs.
+type Trainer struct {
+	Loss         loss.Loss
+	Optimizer    optimizer.Optimizer // If nil, the optimizer of the network is used
+	LearningRate float64
+	BatchSize    int // Number of samples per batch, 1 if zero
+	Epochs       int
+
+	// Schedule is optional. If it is set, it replaces LearningRate before every batch. If it implements
+	// schedule.Observer, it observes the value selected by ScheduleMonitor after every epoch.
+	Schedule        schedule.Schedule
+	ScheduleMonitor Monitor
+
+	Metric    Metric // Optional metric computed on the validation samples after each epoch
+	Callbacks []Callback
+}
+
+// Train trains n on the training samples, and evaluates it on the validation samples after each epoch. The
+// samples are shuffled with the random source of n. It returns the statistics of all completed epochs.
+//
+// If the trainer has an optimizer that differs from the one of n, it is set on n before training. Otherwise
+// the optimizer state of n is kept, so training restored from a snapshot continues seamlessly.
+//
+// While training, n is in Training mode. Callbacks that need outputs of n without dropout should use
+// Network.Predict. The previous mode is restored once training ends.
+func (t *Trainer) Train(n *Network, training, validation []Sample) (History, error) {
+	if t.Loss == nil {
+		return nil, errors.New("trainer has no loss")
+	}
+
+	if len(training) == 0 {
+		return nil, errors.New("no training samples")
+	}
+
+	if t.Optimizer != nil && !reflect.DeepEqual(t.Optimizer, n.optimizer) {
+		n.SetOptimizer(t.Optimizer)
+	}
+
+	defer n.SetMode(n.Mode())
+	n.SetMode(Training)
+
+	batchSize := t.BatchSize
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+
+	var history History
+
+	for epoch := 0; epoch < t.Epochs; epoch++ {
+		stats, err := t.epoch(n, epoch, batchSize, training, validation)
+		if stats != nil {
+			history = append(history, *stats)
+		}
+		if errors.Is(err, ErrStopTraining) {
+			break
+		}
+		if err != nil {
+			return history, err
+		}
+	}
+
+	for _, c := range t.Callbacks {
+		if te, ok := c.(TrainEnder); ok {
+			err := te.TrainEnd(t, n, history)
+			if err != nil {
+				return history, err
+			}
+		}
+	}
+
+	return history, nil
+}
+
+// epoch runs a single training epoch. The returned stats are nil if the epoch didn't complete.
+func (t *Trainer) epoch(n *Network, epoch, batchSize int, training, validation []Sample) (*EpochStats, error) {
+	perm := n.rng.Perm(len(training))
+	numBatches := (len(perm) + batchSize - 1) / batchSize
+
+	t.scheduleLearningRate(epoch, 0, numBatches)
+
+	stats := EpochStats{
+		Epoch:        epoch,
+		LearningRate: t.LearningRate,
+	}
+
+	inputs := make([][]float64, 0, batchSize)
+	targets := make([][]float64, 0, batchSize)
+
+	for batch := 0; batch < numBatches; batch++ {
+		t.scheduleLearningRate(epoch, batch, numBatches)
+
+		inputs = inputs[:0]
+		targets = targets[:0]
+
+		for _, idx := range perm[batch*batchSize : min(len(perm), (batch+1)*batchSize)] {
+			inputs = append(inputs, training[idx].Input)
+			targets = append(targets, training[idx].Target)
+		}
+
+		batchLoss, err := n.TrainBatch(inputs, targets, t.Loss, t.LearningRate)
+		if err != nil {
+			return nil, fmt.Errorf("epoch %d, batch %d: %w", epoch, batch, err)
+		}
+
+		stats.Loss += batchLoss * float64(len(inputs))
+
+		for _, c := range t.Callbacks {
+			err = c.BatchEnd(t, n, BatchStats{
+				Epoch:        epoch,
+				Batch:        batch,
+				LearningRate: t.LearningRate,
+				Loss:         batchLoss,
+				GradientNorm: n.GradientNorm(),
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	stats.Loss /= float64(len(training))
+
+	err := t.validate(n, validation, &stats)
+	if err != nil {
+		return nil, fmt.Errorf("epoch %d: validating: %w", epoch, err)
+	}
+
+	if o, ok := t.Schedule.(schedule.Observer); ok {
+		val, err := t.ScheduleMonitor.value(stats)
+		if err != nil {
+			return nil, fmt.Errorf("epoch %d: schedule: %w", epoch, err)
+		}
+
+		o.Observe(val)
+	}
+
+	for _, c := range t.Callbacks {
+		err = c.EpochEnd(t, n, stats)
+		if err != nil {
+			return &stats, err
+		}
+	}
+
+	return &stats, nil
+}
+
+// scheduleLearningRate sets the learning rate for the given batch from the schedule, if there is one.
+func (t *Trainer) scheduleLearningRate(epoch, batch, numBatches int) {
+	if t.Schedule != nil {
+		t.LearningRate = t.Schedule.LearningRate(float64(epoch) + float64(batch)/float64(numBatches))
+	}
+}
+
+// validate computes the validation loss and metric of n and stores them in stats.
+func (t *Trainer) validate(n *Network, validation []Sample, stats *EpochStats) error {
+	stats.ValidationLoss = math.NaN()
+	stats.ValidationMetric = math.NaN()
+
+	if len(validation) == 0 {
+		return nil
+	}
+
+	stats.ValidationLoss = 0
+	if t.Metric != nil {
+		stats.ValidationMetric = 0
+	}
+
+	for _, s := range validation {
+		output, err := n.Predict(s.Input)
+		if err != nil {
+			return err
+		}
+
+		stats.ValidationLoss += t.Loss.Value(output, s.Target)
+		if t.Metric != nil {
+			stats.ValidationMetric += t.Metric(output, s.Target)
+		}
+	}
+
+	stats.ValidationLoss /= float64(len(validation))
+	stats.ValidationMetric /= float64(len(validation))
+
+	return nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
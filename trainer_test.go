@@ -0,0 +1,420 @@
+package network
+
+import (
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/farhaven/nn-go/activation"
+	"github.com/farhaven/nn-go/loss"
+	"github.com/farhaven/nn-go/optimizer"
+	"github.com/farhaven/nn-go/schedule"
+)
+
+func xorSamples() []Sample {
+	return []Sample{
+		{Input: []float64{0, 0}, Target: []float64{0}},
+		{Input: []float64{0, 1}, Target: []float64{1}},
+		{Input: []float64{1, 0}, Target: []float64{1}},
+		{Input: []float64{1, 1}, Target: []float64{0}},
+	}
+}
+
+var xorConfig = []LayerConf{
+	{Inputs: 2},
+	{Inputs: 4, Activation: activation.Tanh{}, Bias: true},
+	{Inputs: 1, Activation: activation.Sigmoid{}, Bias: true},
+}
+
+func TestSplit(t *testing.T) {
+	samples := make([]Sample, 10)
+
+	training, validation := Split(samples, 0.2)
+	if len(training) != 8 || len(validation) != 2 {
+		t.Errorf(`unexpected split: %d training, %d validation samples`, len(training), len(validation))
+	}
+	if &validation[0] != &samples[0] || &training[0] != &samples[2] {
+		t.Error(`validation samples are not taken from the front`)
+	}
+}
+
+func TestClassificationError(t *testing.T) {
+	if e := ClassificationError([]float64{0.1, 0.7, 0.2}, []float64{0, 1, 0}); e != 0 {
+		t.Error(`expected 0 for correct classification, got`, e)
+	}
+	if e := ClassificationError([]float64{0.8, 0.1, 0.1}, []float64{0, 1, 0}); e != 1 {
+		t.Error(`expected 1 for wrong classification, got`, e)
+	}
+}
+
+func TestTrainerLearnXOR(t *testing.T) {
+	net := testNetwork(t, xorConfig, 1)
+
+	batches := 0
+	trainer := Trainer{
+		Loss:         loss.SquaredError{},
+		Optimizer:    optimizer.Momentum{Mu: 0.9},
+		LearningRate: 0.1,
+		BatchSize:    3,
+		Epochs:       2000,
+		Callbacks: []Callback{
+			CallbackFuncs{
+				OnBatchEnd: func(*Trainer, *Network, BatchStats) error {
+					batches++
+					return nil
+				},
+			},
+		},
+	}
+
+	history, err := trainer.Train(net, xorSamples(), xorSamples())
+	if err != nil {
+		t.Fatal(`training failed:`, err)
+	}
+
+	if len(history) != trainer.Epochs {
+		t.Errorf(`expected %d epochs in history, got %d`, trainer.Epochs, len(history))
+	}
+
+	// 4 samples in batches of 3
+	if batches != 2*trainer.Epochs {
+		t.Errorf(`expected %d batches, got %d`, 2*trainer.Epochs, batches)
+	}
+
+	last := history[len(history)-1]
+	if last.ValidationLoss > 0.01 {
+		t.Error(`validation loss too high:`, last.ValidationLoss)
+	}
+	if !math.IsNaN(last.ValidationMetric) {
+		t.Error(`expected NaN validation metric without a metric, got`, last.ValidationMetric)
+	}
+	if last.Loss >= history[0].Loss {
+		t.Errorf(`loss didn't decrease: %f -> %f`, history[0].Loss, last.Loss)
+	}
+}
+
+func TestTrainerIsReproducible(t *testing.T) {
+	train := func() History {
+		trainer := Trainer{
+			Loss:         loss.SquaredError{},
+			LearningRate: 0.5,
+			BatchSize:    1,
+			Epochs:       10,
+			Metric:       ClassificationError,
+		}
+
+		history, err := trainer.Train(testNetwork(t, xorConfig, 42), xorSamples(), xorSamples())
+		if err != nil {
+			t.Fatal(`training failed:`, err)
+		}
+
+		return history
+	}
+
+	h1, h2 := train(), train()
+	for idx := range h1 {
+		if h1[idx] != h2[idx] {
+			t.Fatalf(`epoch %d differs: %v != %v`, idx, h1[idx], h2[idx])
+		}
+	}
+}
+
+func TestTrainerCallbacks(t *testing.T) {
+	boom := errors.New("boom")
+
+	for _, tc := range []struct {
+		name      string
+		err       error
+		expectErr error
+	}{
+		{name: "stop", err: ErrStopTraining},
+		{name: "error", err: boom, expectErr: boom},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			trainer := Trainer{
+				Loss:         loss.SquaredError{},
+				LearningRate: 0.1,
+				Epochs:       10,
+				Callbacks: []Callback{
+					CallbackFuncs{
+						OnEpochEnd: func(tr *Trainer, _ *Network, stats EpochStats) error {
+							tr.LearningRate /= 2
+
+							if stats.Epoch == 2 {
+								return tc.err
+							}
+							return nil
+						},
+					},
+				},
+			}
+
+			history, err := trainer.Train(testNetwork(t, xorConfig, 1), xorSamples(), nil)
+			if !errors.Is(err, tc.expectErr) {
+				t.Fatalf(`expected error %v, got %v`, tc.expectErr, err)
+			}
+
+			if len(history) != 3 {
+				t.Fatal(`expected 3 epochs in history, got`, len(history))
+			}
+
+			for idx, lr := range []float64{0.1, 0.05, 0.025} {
+				if history[idx].LearningRate != lr {
+					t.Errorf(`epoch %d: expected learning rate %f, got %f`, idx, lr, history[idx].LearningRate)
+				}
+			}
+
+			if !math.IsNaN(history[0].ValidationLoss) {
+				t.Error(`expected NaN validation loss without validation samples`)
+			}
+		})
+	}
+}
+
+func TestTrainerStopAtLossAndCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nn-go-test")
+	if err != nil {
+		t.Fatal(`can't create temporary directory:`, err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "checkpoint")
+
+	net := testNetwork(t, xorConfig, 1)
+	trainer := Trainer{
+		Loss:         loss.SquaredError{},
+		Optimizer:    optimizer.Momentum{Mu: 0.9},
+		LearningRate: 0.1,
+		Epochs:       5000,
+		Callbacks:    []Callback{Checkpoint{Path: path}, StopAtLoss{Target: 0.01}},
+	}
+
+	history, err := trainer.Train(net, xorSamples(), nil)
+	if err != nil {
+		t.Fatal(`training failed:`, err)
+	}
+
+	if len(history) == trainer.Epochs {
+		t.Fatal(`training didn't stop early`)
+	}
+	if history[len(history)-1].Loss > 0.01 {
+		t.Error(`training stopped before reaching the target loss`)
+	}
+
+	restored := testNetwork(t, xorConfig, 2)
+	restored.SetOptimizer(trainer.Optimizer)
+
+	fh, err := os.Open(path)
+	if err != nil {
+		t.Fatal(`can't open checkpoint:`, err)
+	}
+	defer fh.Close()
+
+	_, err = restored.ReadFrom(fh)
+	if err != nil {
+		t.Fatal(`can't restore checkpoint:`, err)
+	}
+
+	for _, s := range xorSamples() {
+		expected := net.Forward(s.Input)
+		actual := restored.Forward(s.Input)
+		if expected[0] != actual[0] {
+			t.Errorf(`restored network output differs for %v: %f != %f`, s.Input, actual[0], expected[0])
+		}
+	}
+}
+
+func TestTrainerErrors(t *testing.T) {
+	net := testNetwork(t, xorConfig, 1)
+
+	_, err := (&Trainer{Epochs: 1}).Train(net, xorSamples(), nil)
+	if err == nil {
+		t.Error(`expected error for trainer without loss`)
+	}
+
+	_, err = (&Trainer{Loss: loss.SquaredError{}, Epochs: 1}).Train(net, nil, nil)
+	if err == nil {
+		t.Error(`expected error without training samples`)
+	}
+
+	samples := []Sample{{Input: []float64{1}, Target: []float64{1}}}
+	_, err = (&Trainer{Loss: loss.SquaredError{}, Epochs: 1}).Train(net, samples, nil)
+	if err == nil {
+		t.Error(`expected error for samples with wrong dimensions`)
+	}
+}
+
+func TestEarlyStopping(t *testing.T) {
+	net := testNetwork(t, xorConfig, 1)
+	es := &EarlyStopping{Patience: 2, MinDelta: 0.1}
+
+	var bestOutput []float64
+
+	for idx, tc := range []struct {
+		loss float64
+		stop bool
+	}{
+		{loss: 1},
+		{loss: 0.5}, // Improvement
+		{loss: 0.45},
+		{loss: 0.6, stop: true},
+	} {
+		err := es.EpochEnd(nil, net, EpochStats{Epoch: idx, ValidationLoss: tc.loss})
+		if tc.stop != errors.Is(err, ErrStopTraining) {
+			t.Fatalf(`epoch %d: unexpected error %v`, idx, err)
+		}
+		if !tc.stop && err != nil {
+			t.Fatalf(`epoch %d: unexpected error %v`, idx, err)
+		}
+
+		if idx == 1 {
+			bestOutput = net.Forward([]float64{1, 0})
+		}
+
+		// Modify the network so that restoring the best one makes a difference
+		_, err = net.Train([]float64{1, 0}, []float64{1}, loss.SquaredError{}, 1)
+		if err != nil {
+			t.Fatal(`training failed:`, err)
+		}
+	}
+
+	epoch, value := es.Best()
+	if epoch != 1 || value != 0.5 {
+		t.Errorf(`unexpected best epoch %d with value %f`, epoch, value)
+	}
+
+	err := es.TrainEnd(nil, net, nil)
+	if err != nil {
+		t.Fatal(`can't end training:`, err)
+	}
+
+	output := net.Forward([]float64{1, 0})
+	if output[0] != bestOutput[0] {
+		t.Errorf(`best network wasn't restored: got output %f, expected %f`, output[0], bestOutput[0])
+	}
+}
+
+func TestTrainerEarlyStopping(t *testing.T) {
+	es := &EarlyStopping{Monitor: MonitorValidationMetric, Patience: 3}
+	trainer := Trainer{
+		Loss:         loss.SquaredError{},
+		LearningRate: 0, // Never improves
+		Epochs:       100,
+		Metric:       ClassificationError,
+		Callbacks:    []Callback{es},
+	}
+
+	for run := 0; run < 2; run++ {
+		history, err := trainer.Train(testNetwork(t, xorConfig, 1), xorSamples(), xorSamples())
+		if err != nil {
+			t.Fatal(`training failed:`, err)
+		}
+
+		if len(history) != 4 {
+			t.Errorf(`run %d: expected 4 epochs, got %d`, run, len(history))
+		}
+	}
+
+	_, err := trainer.Train(testNetwork(t, xorConfig, 1), xorSamples(), nil)
+	if err == nil {
+		t.Error(`expected error when monitoring a validation metric without validation samples`)
+	}
+}
+
+func TestTrainerSchedule(t *testing.T) {
+	var rates []float64
+
+	trainer := Trainer{
+		Loss:      loss.SquaredError{},
+		BatchSize: 2,
+		Epochs:    3,
+		Schedule:  schedule.Warmup{Epochs: 1, After: schedule.Step{Initial: 0.1, Factor: 0.5}},
+		Callbacks: []Callback{
+			CallbackFuncs{
+				OnBatchEnd: func(_ *Trainer, _ *Network, stats BatchStats) error {
+					rates = append(rates, stats.LearningRate)
+					return nil
+				},
+			},
+		},
+	}
+
+	history, err := trainer.Train(testNetwork(t, xorConfig, 1), xorSamples(), nil)
+	if err != nil {
+		t.Fatal(`training failed:`, err)
+	}
+
+	expected := []float64{0, 0.05, 0.1, 0.1, 0.05, 0.05}
+	if len(rates) != len(expected) {
+		t.Fatalf(`expected %d batches, got %d`, len(expected), len(rates))
+	}
+	for idx, rate := range expected {
+		if math.Abs(rates[idx]-rate) > 1e-12 {
+			t.Errorf(`batch %d: expected learning rate %v, got %v`, idx, rate, rates[idx])
+		}
+	}
+
+	for idx, rate := range []float64{0, 0.1, 0.05} {
+		if math.Abs(history[idx].LearningRate-rate) > 1e-12 {
+			t.Errorf(`epoch %d: expected learning rate %v, got %v`, idx, rate, history[idx].LearningRate)
+		}
+	}
+}
+
+func TestTrainerObservingSchedule(t *testing.T) {
+	trainer := Trainer{
+		Loss:            loss.SquaredError{},
+		Epochs:          4,
+		Schedule:        &schedule.ReduceOnPlateau{Initial: 1e-12, Factor: 0.5, Patience: 1, MinDelta: 1e-6},
+		ScheduleMonitor: MonitorLoss,
+	}
+
+	// The learning rate is too small to make progress, so the schedule reduces it after every epoch but the first.
+	history, err := trainer.Train(testNetwork(t, xorConfig, 1), xorSamples(), nil)
+	if err != nil {
+		t.Fatal(`training failed:`, err)
+	}
+
+	for idx, rate := range []float64{1e-12, 1e-12, 0.5e-12, 0.25e-12} {
+		if history[idx].LearningRate != rate {
+			t.Errorf(`epoch %d: expected learning rate %v, got %v`, idx, rate, history[idx].LearningRate)
+		}
+	}
+
+	trainer.ScheduleMonitor = MonitorValidationLoss
+	_, err = trainer.Train(testNetwork(t, xorConfig, 1), xorSamples(), nil)
+	if err == nil {
+		t.Error(`expected error when observing the validation loss without validation samples`)
+	}
+}
+
+func TestTrainerTrainingMode(t *testing.T) {
+	net := testNetwork(t, xorConfig, 1)
+
+	trainer := Trainer{
+		Loss:   loss.SquaredError{},
+		Epochs: 1,
+		Callbacks: []Callback{
+			CallbackFuncs{
+				OnBatchEnd: func(_ *Trainer, n *Network, _ BatchStats) error {
+					if n.Mode() != Training {
+						t.Error(`network isn't in training mode during training`)
+					}
+					return nil
+				},
+			},
+		},
+	}
+
+	_, err := trainer.Train(net, xorSamples(), nil)
+	if err != nil {
+		t.Fatal(`training failed:`, err)
+	}
+
+	if net.Mode() != Inference {
+		t.Error(`mode wasn't restored after training`)
+	}
+}